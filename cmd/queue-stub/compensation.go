@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/participant"
+)
+
+// Set of policies of compensating sagas which the stub hasn't executed.
+const (
+	// PolicyFail responds with an error.
+	PolicyFail = "fail"
+	// PolicyAcknowledge responds compensated as if there was nothing to undo.
+	PolicyAcknowledge = "acknowledge"
+	// PolicyDeadLetter moves the command to the dead-letter queue without a response.
+	PolicyDeadLetter = "dead-letter"
+)
+
+// Execution is the state of a saga in the stub.
+type Execution struct {
+	ExecutedAt    time.Time  `json:"executed_at"`
+	CompensatedAt *time.Time `json:"compensated_at,omitempty"`
+}
+
+// ledger keeps the sagas executed and compensated by the stub.
+type ledger struct {
+	policy string
+
+	mu         sync.Mutex
+	executions map[uuid.UUID]Execution
+}
+
+// newLedger constructs an empty ledger with the policy of compensating unknown sagas.
+func newLedger(policy string) (*ledger, error) {
+	switch policy {
+	case PolicyFail, PolicyAcknowledge, PolicyDeadLetter:
+	default:
+		return nil, fmt.Errorf("unknown compensation policy %q", policy)
+	}
+
+	return &ledger{policy: policy, executions: make(map[uuid.UUID]Execution)}, nil
+}
+
+// Executed records that the saga was executed.
+func (l *ledger) Executed(sagaID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.executions[sagaID] = Execution{ExecutedAt: time.Now().UTC()}
+}
+
+// Compensate records that the saga was compensated. A saga which wasn't executed is handled according to the policy.
+// Compensating a saga again succeeds, so redelivered commands get the same response.
+func (l *ledger) Compensate(cmd queue.Command) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.executions[cmd.SagaID]
+	if !ok {
+		switch l.policy {
+		case PolicyAcknowledge:
+			return nil
+		case PolicyDeadLetter:
+			return fmt.Errorf("%w: compensation of unknown saga %s", queue.ErrRejected, cmd.SagaID)
+		default:
+			return participant.Fail("saga was not executed")
+		}
+	}
+
+	if e.CompensatedAt == nil {
+		now := time.Now().UTC()
+		e.CompensatedAt = &now
+		l.executions[cmd.SagaID] = e
+	}
+
+	return nil
+}
+
+// Executions returns the state of sagas.
+func (l *ledger) Executions() map[uuid.UUID]Execution {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	executions := make(map[uuid.UUID]Execution, len(l.executions))
+	for id, e := range l.executions {
+		executions[id] = e
+	}
+
+	return executions
+}
+
+// Reset forgets all sagas.
+func (l *ledger) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.executions = make(map[uuid.UUID]Execution)
+}
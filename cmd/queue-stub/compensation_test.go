@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/participant"
+)
+
+func TestLedger_Compensate(t *testing.T) {
+	cmd := queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: saga.CommandCompensate}
+
+	t.Run("executed", func(t *testing.T) {
+		l, err := newLedger(PolicyFail)
+		require.NoError(t, err)
+		l.Executed(cmd.SagaID)
+
+		require.NoError(t, l.Compensate(cmd))
+		compensatedAt := l.Executions()[cmd.SagaID].CompensatedAt
+		require.NotNil(t, compensatedAt)
+
+		require.NoError(t, l.Compensate(cmd))
+		assert.Equal(t, compensatedAt, l.Executions()[cmd.SagaID].CompensatedAt)
+	})
+
+	t.Run("unknown saga", func(t *testing.T) {
+		l, err := newLedger(PolicyFail)
+		require.NoError(t, err)
+		var businessErr *participant.BusinessError
+		assert.ErrorAs(t, l.Compensate(cmd), &businessErr)
+
+		l, err = newLedger(PolicyAcknowledge)
+		require.NoError(t, err)
+		assert.NoError(t, l.Compensate(cmd))
+		assert.Empty(t, l.Executions())
+
+		l, err = newLedger(PolicyDeadLetter)
+		require.NoError(t, err)
+		assert.ErrorIs(t, l.Compensate(cmd), queue.ErrRejected)
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		_, err := newLedger("ignore")
+		assert.Error(t, err)
+	})
+}
+
+func TestQueueStub_Compensate(t *testing.T) {
+	ctx := context.Background()
+	ctrl, processor, sender := newControlled(t)
+
+	start := newStartCommand()
+	require.NoError(t, processor.ProcessMessage(ctx, start))
+	compensate := queue.Command{ID: uuid.New(), SagaID: start.SagaID, Name: saga.CommandCompensate}
+	require.NoError(t, processor.ProcessMessage(ctx, compensate))
+	unknown := queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: saga.CommandCompensate}
+	require.NoError(t, processor.ProcessMessage(ctx, unknown))
+
+	require.Len(t, sender.sent, 3)
+	assert.Equal(t, saga.StatusWorkDone, sender.sent[0].(queue.Response).Status)
+	assert.Equal(t, saga.StatusCompensated, sender.sent[1].(queue.Response).Status)
+	assert.Equal(t, saga.StatusError, sender.sent[2].(queue.Response).Status)
+
+	w := call(t, ctrl.Routes(), http.MethodGet, "/sagas", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var sagas map[uuid.UUID]Execution
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sagas))
+	require.Len(t, sagas, 1)
+	assert.NotNil(t, sagas[start.SagaID].CompensatedAt)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/participant"
+)
+
+// Set of outcomes of scripted responses.
+const (
+	OutcomeDone  = "done"
+	OutcomeError = "error"
+	OutcomeDrop  = "drop"
+)
+
+// Scripted is a response set through the control API for one of the next commands.
+// The payload of a done response is the payload of the command if it's empty.
+type Scripted struct {
+	Outcome string          `json:"outcome"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Received is a command received by the stub.
+type Received struct {
+	Command    queue.Command `json:"command"`
+	ReceivedAt time.Time     `json:"received_at"`
+}
+
+// controlState is the response of the state endpoint.
+type controlState struct {
+	Paused   bool                    `json:"paused"`
+	Received []Received              `json:"received"`
+	Scripted []Scripted              `json:"scripted"`
+	Sagas    map[uuid.UUID]Execution `json:"sagas"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type dropKey struct{}
+
+// control lets tests inspect and drive the stub: it records received commands, pauses consumption
+// and keeps responses scripted for the next commands. It sits in front of the participant
+// as a processor and behind it as a sender.
+type control struct {
+	next   participant.Sender
+	ledger *ledger
+	log    *zap.SugaredLogger
+
+	mu       sync.Mutex
+	received []Received
+	scripted []Scripted
+	// resumed is closed when consumption isn't paused.
+	resumed chan struct{}
+}
+
+// newControl constructs the control sending responses with the next sender and exposing the ledger of sagas.
+func newControl(next participant.Sender, ledger *ledger, log *zap.SugaredLogger) *control {
+	resumed := make(chan struct{})
+	close(resumed)
+
+	return &control{next: next, ledger: ledger, log: log, resumed: resumed}
+}
+
+// Processor returns the processor recording commands and waiting while consumption is paused
+// before passing them to the next processor.
+func (c *control) Processor(next queue.Processor) queue.Processor {
+	return controlled{control: c, next: next}
+}
+
+type controlled struct {
+	control *control
+	next    queue.Processor
+}
+
+func (p controlled) ProcessMessage(ctx context.Context, inp any) error {
+	if cmd, ok := inp.(queue.Command); ok {
+		p.control.record(cmd)
+	}
+
+	p.control.mu.Lock()
+	resumed := p.control.resumed
+	p.control.mu.Unlock()
+	select {
+	case <-resumed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var drop bool
+	return p.next.ProcessMessage(context.WithValue(ctx, dropKey{}, &drop), inp)
+}
+
+// Send sends the response unless it's scripted to be dropped.
+func (c *control) Send(ctx context.Context, resp any) error {
+	if drop, ok := ctx.Value(dropKey{}).(*bool); ok && *drop {
+		c.log.Infow("control", "status", "response dropped", "response", resp)
+		return nil
+	}
+
+	return c.next.Send(ctx, resp)
+}
+
+// Respond applies the next scripted response to the command. It reports false if no response is scripted.
+func (c *control) Respond(ctx context.Context, cmd queue.Command) (json.RawMessage, bool, error) {
+	c.mu.Lock()
+	if len(c.scripted) == 0 {
+		c.mu.Unlock()
+		return nil, false, nil
+	}
+	s := c.scripted[0]
+	c.scripted = c.scripted[1:]
+	c.mu.Unlock()
+
+	switch s.Outcome {
+	case OutcomeError:
+		return nil, true, &participant.BusinessError{Reason: "scripted error", Payload: s.Payload}
+	case OutcomeDrop:
+		if drop, ok := ctx.Value(dropKey{}).(*bool); ok {
+			*drop = true
+		}
+	}
+	if len(s.Payload) == 0 {
+		return cmd.Payload, true, nil
+	}
+
+	return s.Payload, true, nil
+}
+
+func (c *control) record(cmd queue.Command) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.received = append(c.received, Received{Command: cmd, ReceivedAt: time.Now().UTC()})
+}
+
+// Routes returns the routes of the control API.
+func (c *control) Routes() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/state", c.handleState).Methods(http.MethodGet)
+	router.HandleFunc("/commands", c.handleCommands).Methods(http.MethodGet)
+	router.HandleFunc("/sagas", c.handleSagas).Methods(http.MethodGet)
+	router.HandleFunc("/responses", c.handleResponses).Methods(http.MethodPost)
+	router.HandleFunc("/pause", c.handlePause).Methods(http.MethodPost)
+	router.HandleFunc("/resume", c.handleResume).Methods(http.MethodPost)
+	router.HandleFunc("/reset", c.handleReset).Methods(http.MethodPost)
+
+	return router
+}
+
+// handleState returns received commands, pending scripted responses, executed sagas and whether consumption is paused.
+func (c *control) handleState(w http.ResponseWriter, _ *http.Request) {
+	c.mu.Lock()
+	state := controlState{
+		Paused:   c.paused(),
+		Received: append([]Received{}, c.received...),
+		Scripted: append([]Scripted{}, c.scripted...),
+		Sagas:    c.ledger.Executions(),
+	}
+	c.mu.Unlock()
+
+	c.respond(w, http.StatusOK, state)
+}
+
+// handleCommands returns received commands.
+func (c *control) handleCommands(w http.ResponseWriter, _ *http.Request) {
+	c.mu.Lock()
+	received := append([]Received{}, c.received...)
+	c.mu.Unlock()
+
+	c.respond(w, http.StatusOK, received)
+}
+
+// handleSagas returns the sagas executed by the stub and when they were compensated.
+func (c *control) handleSagas(w http.ResponseWriter, _ *http.Request) {
+	c.respond(w, http.StatusOK, c.ledger.Executions())
+}
+
+// handleResponses appends the list of scripted responses to the pending ones.
+func (c *control) handleResponses(w http.ResponseWriter, r *http.Request) {
+	var scripted []Scripted
+	if err := json.NewDecoder(r.Body).Decode(&scripted); err != nil {
+		c.respond(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("decode responses: %v", err)})
+		return
+	}
+	for _, s := range scripted {
+		switch s.Outcome {
+		case OutcomeDone, OutcomeError, OutcomeDrop:
+		default:
+			c.respond(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("unknown outcome %q", s.Outcome)})
+			return
+		}
+	}
+
+	c.mu.Lock()
+	c.scripted = append(c.scripted, scripted...)
+	c.mu.Unlock()
+
+	c.respond(w, http.StatusOK, nil)
+}
+
+// handlePause stops consumption of commands. The command being received waits until consumption is resumed.
+func (c *control) handlePause(w http.ResponseWriter, _ *http.Request) {
+	c.mu.Lock()
+	if !c.paused() {
+		c.resumed = make(chan struct{})
+	}
+	c.mu.Unlock()
+
+	c.log.Infow("control", "status", "consumption paused")
+	c.respond(w, http.StatusOK, nil)
+}
+
+// handleResume continues consumption of commands.
+func (c *control) handleResume(w http.ResponseWriter, _ *http.Request) {
+	c.mu.Lock()
+	c.resume()
+	c.mu.Unlock()
+
+	c.log.Infow("control", "status", "consumption resumed")
+	c.respond(w, http.StatusOK, nil)
+}
+
+// handleReset forgets received commands, scripted responses and executed sagas and resumes consumption.
+func (c *control) handleReset(w http.ResponseWriter, _ *http.Request) {
+	c.mu.Lock()
+	c.received = nil
+	c.scripted = nil
+	c.resume()
+	c.mu.Unlock()
+	c.ledger.Reset()
+
+	c.log.Infow("control", "status", "state reset")
+	c.respond(w, http.StatusOK, nil)
+}
+
+// paused reports whether consumption is paused. It must be called with the mutex held.
+func (c *control) paused() bool {
+	select {
+	case <-c.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+// resume closes the resumed channel if consumption is paused. It must be called with the mutex held.
+func (c *control) resume() {
+	if c.paused() {
+		close(c.resumed)
+	}
+}
+
+func (c *control) respond(w http.ResponseWriter, statusCode int, data any) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		c.log.Errorw("respond", "ERROR", fmt.Errorf("json marshal: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if _, err := w.Write(jsonData); err != nil {
+		c.log.Errorw("respond", "ERROR", fmt.Errorf("write output: %w", err))
+		return
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/participant"
+)
+
+func newControlled(t *testing.T) (*control, queue.Processor, *recordingSender) {
+	t.Helper()
+
+	log := zap.NewNop().Sugar()
+	sender := &recordingSender{}
+	ledger, err := newLedger(PolicyFail)
+	require.NoError(t, err)
+	ctrl := newControl(sender, ledger, log)
+	stub := queueStub{control: ctrl, chaos: newChaos(Scenario{}, 1, sender, log), ledger: ledger, log: log}
+	p := participant.New("service1", ctrl)
+	p.Execute(stub.Execute)
+	p.Compensate(stub.Compensate)
+
+	return ctrl, ctrl.Processor(p), sender
+}
+
+func call(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func newStartCommand() queue.Command {
+	return queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: saga.CommandStart, Payload: json.RawMessage(`{"n":1}`)}
+}
+
+func TestControl_Responses(t *testing.T) {
+	ctx := context.Background()
+	ctrl, processor, sender := newControlled(t)
+	routes := ctrl.Routes()
+
+	w := call(t, routes, http.MethodPost, "/responses", `[{"outcome": "error"}, {"outcome": "drop"}, {"outcome": "done", "payload": {"n":2}}]`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, processor.ProcessMessage(ctx, newStartCommand()))
+	}
+
+	require.Len(t, sender.sent, 3)
+	assert.Equal(t, saga.StatusError, sender.sent[0].(queue.Response).Status)
+	assert.JSONEq(t, `{"n":2}`, string(sender.sent[1].(queue.Response).Payload))
+	assert.JSONEq(t, `{"n":1}`, string(sender.sent[2].(queue.Response).Payload))
+
+	w = call(t, routes, http.MethodGet, "/commands", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var received []Received
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &received))
+	assert.Len(t, received, 4)
+
+	w = call(t, routes, http.MethodPost, "/responses", `[{"outcome": "maybe"}]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestControl_PauseResumeReset(t *testing.T) {
+	ctx := context.Background()
+	ctrl, processor, sender := newControlled(t)
+	routes := ctrl.Routes()
+
+	require.Equal(t, http.StatusOK, call(t, routes, http.MethodPost, "/pause", "").Code)
+	require.Equal(t, http.StatusOK, call(t, routes, http.MethodPost, "/responses", `[{"outcome": "error"}]`).Code)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- processor.ProcessMessage(ctx, newStartCommand())
+	}()
+	assert.Never(t, func() bool { return sender.count() > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+
+	var state controlState
+	require.NoError(t, json.Unmarshal(call(t, routes, http.MethodGet, "/state", "").Body.Bytes(), &state))
+	assert.True(t, state.Paused)
+	assert.Len(t, state.Received, 1)
+	assert.Len(t, state.Scripted, 1)
+
+	require.Equal(t, http.StatusOK, call(t, routes, http.MethodPost, "/resume", "").Code)
+	require.NoError(t, <-done)
+	assert.Equal(t, 1, sender.count())
+
+	require.Equal(t, http.StatusOK, call(t, routes, http.MethodPost, "/pause", "").Code)
+	require.Equal(t, http.StatusOK, call(t, routes, http.MethodPost, "/reset", "").Code)
+	require.NoError(t, json.Unmarshal(call(t, routes, http.MethodGet, "/state", "").Body.Bytes(), &state))
+	assert.Equal(t, controlState{Received: []Received{}, Scripted: []Scripted{}, Sagas: map[uuid.UUID]Execution{}}, state)
+}
@@ -2,22 +2,26 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/ardanlabs/conf/v3"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 
-	"github.com/illyasch/saga-service/pkg/business/saga"
 	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/participant"
+	"github.com/illyasch/saga-service/pkg/sys/app"
+	"github.com/illyasch/saga-service/pkg/sys/envelope"
 	"github.com/illyasch/saga-service/pkg/sys/logger"
+	"github.com/illyasch/saga-service/pkg/sys/tracing"
 )
 
 const configPrefix = "STUB"
@@ -33,6 +37,45 @@ type config struct {
 		MaxMessages     int64         `conf:"default:10"`
 		WaitTime        int64         `conf:"default:20"`
 		ShutdownTimeout time.Duration `conf:"default:20s"`
+		// DeadLetterQueue receives rejected commands, it's <CommandsQueue>-dlq if it's empty.
+		DeadLetterQueue string
+	}
+	Signing struct {
+		KeyID  string
+		Keys   map[string]string `conf:"mask"`
+		Strict bool              `conf:"default:false"`
+	}
+	Encryption struct {
+		KeyringFile string
+	}
+	Control struct {
+		APIHost      string        `conf:"default:0.0.0.0:4000"`
+		ReadTimeout  time.Duration `conf:"default:5s"`
+		WriteTimeout time.Duration `conf:"default:10s"`
+	}
+	Compensation struct {
+		UnknownPolicy string `conf:"default:fail"`
+	}
+	Behaviour struct {
+		ScenarioFile        string
+		FailureRate         float64
+		FailSagas           []string
+		LatencyDistribution string
+		LatencyMean         time.Duration
+		LatencyStdDev       time.Duration
+		LatencyMin          time.Duration
+		LatencyMax          time.Duration
+		DropRate            float64
+		DuplicateRate       float64
+		ReorderWindow       int
+		Seed                int64
+	}
+	Tracing struct {
+		Exporter    string
+		Endpoint    string  `conf:"default:localhost:4318"`
+		Insecure    bool    `conf:"default:true"`
+		File        string  `conf:"default:traces.json"`
+		Probability float64 `conf:"default:1"`
 	}
 }
 
@@ -72,6 +115,26 @@ func run(log *zap.SugaredLogger) error {
 	}
 	log.Infow("startup", "config", out)
 
+	// Start tracing support.
+	stopTracing, err := tracing.Start(tracing.Config{
+		ServiceName: cfg.ServiceName,
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		File:        cfg.Tracing.File,
+		Probability: cfg.Tracing.Probability,
+	})
+	if err != nil {
+		return fmt.Errorf("starting tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Queue.ShutdownTimeout)
+		defer cancel()
+		if err := stopTracing(ctx); err != nil {
+			log.Errorw("shutdown", "ERROR", err)
+		}
+	}()
+
 	// Create connectivity to the queue.
 	awsConfig := aws.NewConfig().WithRegion(cfg.Queue.AWSRegion)
 	if cfg.Queue.AWSEndpoint != "" {
@@ -79,57 +142,116 @@ func run(log *zap.SugaredLogger) error {
 	}
 	// Generic AWS service container with credentials.
 	awsSQS := sqs.New(session.Must(session.NewSession()), awsConfig)
+	// Create signing of queue messages.
+	var senderOpts []queue.SenderOption
+	var pollOpts []queue.PollOption
+	if len(cfg.Signing.Keys) > 0 {
+		keyring, err := queue.NewKeyring(cfg.Signing.KeyID, cfg.Signing.Keys)
+		if err != nil {
+			return fmt.Errorf("creating signing keyring: %w", err)
+		}
+		senderOpts = append(senderOpts, queue.WithSigning(keyring))
+		pollOpts = append(pollOpts, queue.WithVerification(keyring, cfg.Signing.Strict))
+	}
+
+	// Create encryption of message payloads.
+	if cfg.Encryption.KeyringFile != "" {
+		keyring, err := envelope.LoadFileKeyring(cfg.Encryption.KeyringFile)
+		if err != nil {
+			return fmt.Errorf("loading encryption keyring: %w", err)
+		}
+		cipher := envelope.New(keyring)
+		senderOpts = append(senderOpts, queue.WithEncryption(cipher))
+		pollOpts = append(pollOpts, queue.WithDecryption(cipher))
+	}
+
 	// Create queue sender.
-	sender, err := queue.NewSender(awsSQS, cfg.Queue.ResponsesQueue)
+	sender, err := queue.NewSender(awsSQS, cfg.Queue.ResponsesQueue, senderOpts...)
 	if err != nil {
-		return fmt.Errorf("creating receiver(%s): %w", saga.QueueName, err)
+		return fmt.Errorf("creating sender(%s): %w", cfg.Queue.ResponsesQueue, err)
 	}
 	// Create queue receiver.
 	r, err := queue.NewReceiver(awsSQS, cfg.Queue.CommandsQueue, cfg.Queue.MaxMessages, cfg.Queue.WaitTime)
 	if err != nil {
-		return fmt.Errorf("creating receiver(%s): %w", saga.QueueName, err)
+		return fmt.Errorf("creating receiver(%s): %w", cfg.Queue.CommandsQueue, err)
 	}
-	// Create queue poller.
-	poller, err := queue.NewPoll[queue.Command](
-		&r,
-		queueStub{service: cfg.ServiceName, sender: sender, log: log},
-		log,
-	)
+	// Create sender for rejected commands.
+	deadLetter := cfg.Queue.DeadLetterQueue
+	if deadLetter == "" {
+		deadLetter = queue.DeadLetterName(cfg.Queue.CommandsQueue)
+	}
+	dlq, err := queue.NewSender(awsSQS, deadLetter)
 	if err != nil {
-		return fmt.Errorf("new poller: %w", err)
+		return fmt.Errorf("creating dead-letter sender(%s): %w", deadLetter, err)
+	}
+	pollOpts = append(pollOpts, queue.WithDeadLetter(dlq))
+	// Create the scripted behaviour.
+	scenario, err := loadScenario(cfg)
+	if err != nil {
+		return fmt.Errorf("loading scenario: %w", err)
+	}
+	seed := cfg.Behaviour.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
 	}
+	log.Infow("startup", "scenario", scenario, "seed", seed)
+	chaos := newChaos(scenario, seed, sender, log)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Create the control of the stub and the participant echoing payloads of commands.
+	ledger, err := newLedger(cfg.Compensation.UnknownPolicy)
+	if err != nil {
+		return fmt.Errorf("creating ledger: %w", err)
+	}
+	ctrl := newControl(chaos, ledger, log)
+	stub := queueStub{control: ctrl, chaos: chaos, ledger: ledger, log: log}
+	p := participant.New(cfg.ServiceName, ctrl, participant.WithLogger(log))
+	p.Execute(stub.Execute)
+	p.Compensate(stub.Compensate)
 
-	// Make a channel to listen for an interrupt or terminate signal from the OS.
-	// Use a buffered channel because the signal package requires it.
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+	// Create queue poller.
+	poller, err := queue.NewPoll[queue.Command](&r, ctrl.Processor(p), log, pollOpts...)
+	if err != nil {
+		return fmt.Errorf("new poller: %w", err)
+	}
 
-	// Make a channel to listen for errors coming from the poller. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	pollerErrors := make(chan error, 1)
+	// Construct a server of the control API.
+	httpServer := http.Server{
+		Addr:         cfg.Control.APIHost,
+		Handler:      ctrl.Routes(),
+		ReadTimeout:  cfg.Control.ReadTimeout,
+		WriteTimeout: cfg.Control.WriteTimeout,
+		ErrorLog:     zap.NewStdLog(log.Desugar()),
+	}
 
-	// Start the service listening for incoming queue messages.
-	go func() {
+	// Adding tasks to App.
+	a := &app.App{}
+	// Spin up the queue poller.
+	a.Add(func(ctx context.Context) error {
 		log.Infow("startup", "status", "queue listener started")
-		pollerErrors <- poller.Start(ctx)
-	}()
-
-	// Shutdown
-	// Blocking main and waiting for shutdown.
-	select {
-	case err := <-pollerErrors:
-		return fmt.Errorf("listener error: %w", err)
+		return poller.Start(ctx)
+	})
+	// Spin up sending of responses held for reordering.
+	a.Add(chaos.Run)
+	// Spin up the control API.
+	a.Add(func(ctx context.Context) error {
+		log.Infow("startup", "status", "control API started", "host", httpServer.Addr)
+		if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("control API: %w", err)
+		}
+		return nil
+	})
+	// Defer control API shutdown on the service exit.
+	a.Add(func(ctx context.Context) error {
+		<-ctx.Done()
+		ctxWithTimeout, cancel := context.WithTimeout(context.Background(), cfg.Queue.ShutdownTimeout)
+		defer cancel()
+		log.Infow("shutdown", "status", "stopping control API", "host", httpServer.Addr)
 
-	case sig := <-shutdown:
-		log.Infow("shutdown", "status", "shutdown started", "signal", sig)
-		defer log.Infow("shutdown", "status", "shutdown complete", "signal", sig)
+		return httpServer.Shutdown(ctxWithTimeout)
+	})
 
-		// Asking poller to shut down.
-		cancel()
-		time.Sleep(cfg.Queue.ShutdownTimeout)
+	if a.Run(context.Background()) != app.ExitOK {
+		return errors.New("stub service failed")
 	}
 
 	return nil
@@ -158,27 +280,75 @@ func parseConfig(prefix string, logger *zap.SugaredLogger) (config, error) {
 	return cfg, nil
 }
 
+// loadScenario reads the scenario file if it's set, otherwise builds the scenario from the configuration.
+func loadScenario(cfg config) (Scenario, error) {
+	b := cfg.Behaviour
+	if b.ScenarioFile != "" {
+		return LoadScenario(b.ScenarioFile)
+	}
+
+	s := Scenario{
+		FailureRate: b.FailureRate,
+		Latency: Latency{
+			Distribution: b.LatencyDistribution,
+			Mean:         Duration(b.LatencyMean),
+			StdDev:       Duration(b.LatencyStdDev),
+			Min:          Duration(b.LatencyMin),
+			Max:          Duration(b.LatencyMax),
+		},
+		DropRate:      b.DropRate,
+		DuplicateRate: b.DuplicateRate,
+		ReorderWindow: b.ReorderWindow,
+	}
+	for _, id := range b.FailSagas {
+		sagaID, err := uuid.Parse(id)
+		if err != nil {
+			return Scenario{}, fmt.Errorf("parse saga ID %q: %w", id, err)
+		}
+		s.FailSagas = append(s.FailSagas, sagaID)
+	}
+	if err := s.Validate(); err != nil {
+		return Scenario{}, err
+	}
+
+	return s, nil
+}
+
+// queueStub handles commands of sagas. Responses scripted through the control API take precedence,
+// otherwise commands fail according to the scenario.
 type queueStub struct {
-	service string
-	sender  *queue.Sender
+	control *control
+	chaos   *chaos
+	ledger  *ledger
 	log     *zap.SugaredLogger
 }
 
-func (q queueStub) ProcessMessage(_ context.Context, inp any) error {
-	command, ok := inp.(queue.Command)
+// Execute echoes the payload of the command and records the saga as executed.
+func (q queueStub) Execute(ctx context.Context, cmd queue.Command) (json.RawMessage, error) {
+	q.log.Infow("processing", "command", cmd.Name, "saga", cmd.SagaID)
+
+	payload, ok, err := q.control.Respond(ctx, cmd)
 	if !ok {
-		return fmt.Errorf("malformed command")
+		payload, err = cmd.Payload, q.chaos.Fail(cmd)
 	}
-
-	q.log.Infow("processing", "command", command.Name, "saga", command.SagaID)
-	err := q.sender.Send(queue.Response{
-		SagaID:  command.SagaID,
-		Service: q.service,
-		Status:  saga.StatusWorkDone,
-	})
 	if err != nil {
-		return fmt.Errorf("sending response: %w", err)
+		return nil, err
 	}
+	q.ledger.Executed(cmd.SagaID)
 
-	return nil
+	return payload, nil
+}
+
+// Compensate records the saga executed earlier as compensated.
+func (q queueStub) Compensate(ctx context.Context, cmd queue.Command) (json.RawMessage, error) {
+	q.log.Infow("processing", "command", cmd.Name, "saga", cmd.SagaID)
+
+	if payload, ok, err := q.control.Respond(ctx, cmd); ok {
+		return payload, err
+	}
+	if err := q.ledger.Compensate(cmd); err != nil {
+		return nil, err
+	}
+
+	return cmd.Payload, nil
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/participant"
+)
+
+// Set of distributions of the latency of responses.
+const (
+	LatencyFixed       = "fixed"
+	LatencyUniform     = "uniform"
+	LatencyNormal      = "normal"
+	LatencyExponential = "exponential"
+)
+
+// reorderFlushInterval is how often held responses are sent if the reorder window isn't filled.
+const reorderFlushInterval = time.Second
+
+// Duration is a time.Duration written as a string, e.g. "250ms", in a scenario file.
+type Duration time.Duration
+
+// UnmarshalText parses the duration.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Latency describes the distribution of the delay of responses. Delays are clamped to [Min, Max],
+// Max is ignored if it's zero.
+type Latency struct {
+	Distribution string   `json:"distribution"`
+	Mean         Duration `json:"mean"`
+	StdDev       Duration `json:"std_dev"`
+	Min          Duration `json:"min"`
+	Max          Duration `json:"max"`
+}
+
+// Scenario scripts the behaviour of the stub. Rates are probabilities from 0 to 1.
+type Scenario struct {
+	// FailureRate is the probability of responding with an error.
+	FailureRate float64 `json:"failure_rate"`
+	// FailSagas are the sagas which are always responded with an error.
+	FailSagas []uuid.UUID `json:"fail_sagas"`
+	Latency   Latency     `json:"latency"`
+	// DropRate is the probability of not sending a response, so the saga is stuck.
+	DropRate float64 `json:"drop_rate"`
+	// DuplicateRate is the probability of sending a response twice.
+	DuplicateRate float64 `json:"duplicate_rate"`
+	// ReorderWindow is the number of responses which are held and sent in a random order.
+	ReorderWindow int `json:"reorder_window"`
+}
+
+// LoadScenario reads the scenario from the JSON file.
+func LoadScenario(path string) (Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Scenario{}, fmt.Errorf("read scenario: %w", err)
+	}
+
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Scenario{}, fmt.Errorf("parse scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return Scenario{}, err
+	}
+
+	return s, nil
+}
+
+// Validate checks rates and the latency distribution.
+func (s Scenario) Validate() error {
+	for name, rate := range map[string]float64{
+		"failure rate":   s.FailureRate,
+		"drop rate":      s.DropRate,
+		"duplicate rate": s.DuplicateRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s %v is out of [0, 1]", name, rate)
+		}
+	}
+	if s.ReorderWindow < 0 {
+		return fmt.Errorf("negative reorder window %d", s.ReorderWindow)
+	}
+
+	switch s.Latency.Distribution {
+	case "", LatencyFixed, LatencyUniform, LatencyNormal, LatencyExponential:
+	default:
+		return fmt.Errorf("unknown latency distribution %q", s.Latency.Distribution)
+	}
+	if s.Latency.Distribution == LatencyUniform && s.Latency.Max == 0 {
+		return fmt.Errorf("uniform latency requires max")
+	}
+	if s.Latency.Max != 0 && s.Latency.Max < s.Latency.Min {
+		return fmt.Errorf("latency max %v is less than min %v", time.Duration(s.Latency.Max), time.Duration(s.Latency.Min))
+	}
+
+	return nil
+}
+
+// chaos plays the scenario. It decides failures of commands and sends responses through the
+// next sender with delays, drops, duplicates and reordering.
+type chaos struct {
+	scenario  Scenario
+	failSagas map[uuid.UUID]bool
+	next      participant.Sender
+	log       *zap.SugaredLogger
+
+	mu   sync.Mutex
+	rand *rand.Rand
+	held []held
+}
+
+// held is a response waiting to be sent in a random order.
+type held struct {
+	ctx  context.Context
+	resp any
+}
+
+// newChaos constructs the chaos of the scenario with the random seed.
+func newChaos(s Scenario, seed int64, next participant.Sender, log *zap.SugaredLogger) *chaos {
+	c := chaos{
+		scenario:  s,
+		failSagas: make(map[uuid.UUID]bool, len(s.FailSagas)),
+		next:      next,
+		log:       log,
+		rand:      rand.New(rand.NewSource(seed)),
+	}
+	for _, id := range s.FailSagas {
+		c.failSagas[id] = true
+	}
+
+	return &c
+}
+
+// Fail returns a business failure if the command has to be responded with an error.
+func (c *chaos) Fail(cmd queue.Command) error {
+	if c.failSagas[cmd.SagaID] {
+		return participant.Fail("scripted failure of the saga")
+	}
+	if c.chance(c.scenario.FailureRate) {
+		return participant.Fail("random failure")
+	}
+
+	return nil
+}
+
+// Send sends the response according to the scenario. Delayed and held responses are sent in the background
+// and errors of sending them are logged.
+func (c *chaos) Send(ctx context.Context, resp any) error {
+	if c.chance(c.scenario.DropRate) {
+		c.log.Infow("chaos", "status", "response dropped", "response", resp)
+		return nil
+	}
+	copies := 1
+	if c.chance(c.scenario.DuplicateRate) {
+		c.log.Infow("chaos", "status", "response duplicated", "response", resp)
+		copies = 2
+	}
+
+	if c.scenario.ReorderWindow > 0 {
+		c.hold(ctx, resp, copies)
+		return nil
+	}
+
+	delay := c.latency()
+	if delay == 0 {
+		return c.send(ctx, resp, copies)
+	}
+	go func() {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		if err := c.send(ctx, resp, copies); err != nil {
+			c.log.Errorw("chaos", "ERROR", err)
+		}
+	}()
+
+	return nil
+}
+
+// Run sends held responses periodically, so they aren't held forever when few commands arrive.
+func (c *chaos) Run(ctx context.Context) error {
+	if c.scenario.ReorderWindow == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(reorderFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.flush()
+		}
+	}
+}
+
+// hold keeps the response and sends all held responses shuffled when the window is filled.
+func (c *chaos) hold(ctx context.Context, resp any, copies int) {
+	c.mu.Lock()
+	for i := 0; i < copies; i++ {
+		c.held = append(c.held, held{ctx: ctx, resp: resp})
+	}
+	full := len(c.held) >= c.scenario.ReorderWindow
+	c.mu.Unlock()
+
+	if full {
+		c.flush()
+	}
+}
+
+// flush sends held responses in a random order.
+func (c *chaos) flush() {
+	c.mu.Lock()
+	responses := c.held
+	c.held = nil
+	c.rand.Shuffle(len(responses), func(i, j int) {
+		responses[i], responses[j] = responses[j], responses[i]
+	})
+	c.mu.Unlock()
+
+	for _, h := range responses {
+		if err := c.send(h.ctx, h.resp, 1); err != nil {
+			c.log.Errorw("chaos", "ERROR", err)
+		}
+	}
+}
+
+func (c *chaos) send(ctx context.Context, resp any, copies int) error {
+	for i := 0; i < copies; i++ {
+		if err := c.next.Send(ctx, resp); err != nil {
+			return fmt.Errorf("send response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// chance reports whether an event of the probability happens.
+func (c *chaos) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < p
+}
+
+// latency returns the delay of a response drawn from the distribution.
+func (c *chaos) latency() time.Duration {
+	l := c.scenario.Latency
+	mean, stdDev, min, max := float64(l.Mean), float64(l.StdDev), float64(l.Min), float64(l.Max)
+
+	c.mu.Lock()
+	var d float64
+	switch l.Distribution {
+	case LatencyFixed:
+		d = mean
+	case LatencyUniform:
+		d = min + c.rand.Float64()*(max-min)
+	case LatencyNormal:
+		d = mean + c.rand.NormFloat64()*stdDev
+	case LatencyExponential:
+		d = c.rand.ExpFloat64() * mean
+	}
+	c.mu.Unlock()
+
+	d = math.Max(d, min)
+	if max > 0 {
+		d = math.Min(d, max)
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/participant"
+)
+
+type recordingSender struct {
+	mu   sync.Mutex
+	sent []any
+}
+
+func (s *recordingSender) Send(_ context.Context, msg any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func (s *recordingSender) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sent)
+}
+
+func TestLoadScenario(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	sagaID := uuid.New()
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"failure_rate": 0.1,
+		"fail_sagas": ["`+sagaID.String()+`"],
+		"latency": {"distribution": "uniform", "min": "100ms", "max": "2s"},
+		"reorder_window": 5
+	}`), 0600))
+
+	s, err := LoadScenario(path)
+	require.NoError(t, err)
+	assert.Equal(t, Scenario{
+		FailureRate:   0.1,
+		FailSagas:     []uuid.UUID{sagaID},
+		Latency:       Latency{Distribution: LatencyUniform, Min: Duration(100 * time.Millisecond), Max: Duration(2 * time.Second)},
+		ReorderWindow: 5,
+	}, s)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"drop_rate": 2}`), 0600))
+	_, err = LoadScenario(path)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"latency": {"distribution": "pareto"}}`), 0600))
+	_, err = LoadScenario(path)
+	assert.Error(t, err)
+}
+
+func TestChaos(t *testing.T) {
+	ctx := context.Background()
+	log := zap.NewNop().Sugar()
+
+	t.Run("fail sagas", func(t *testing.T) {
+		sagaID := uuid.New()
+		c := newChaos(Scenario{FailSagas: []uuid.UUID{sagaID}}, 1, &recordingSender{}, log)
+
+		var businessErr *participant.BusinessError
+		assert.ErrorAs(t, c.Fail(queue.Command{SagaID: sagaID}), &businessErr)
+		assert.NoError(t, c.Fail(queue.Command{SagaID: uuid.New()}))
+	})
+
+	t.Run("failure rate", func(t *testing.T) {
+		c := newChaos(Scenario{FailureRate: 1}, 1, &recordingSender{}, log)
+		assert.Error(t, c.Fail(queue.Command{SagaID: uuid.New()}))
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		sender := &recordingSender{}
+		c := newChaos(Scenario{DropRate: 1}, 1, sender, log)
+
+		require.NoError(t, c.Send(ctx, queue.Response{ID: uuid.New()}))
+		assert.Equal(t, 0, sender.count())
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		sender := &recordingSender{}
+		c := newChaos(Scenario{DuplicateRate: 1}, 1, sender, log)
+
+		resp := queue.Response{ID: uuid.New()}
+		require.NoError(t, c.Send(ctx, resp))
+		assert.Equal(t, []any{resp, resp}, sender.sent)
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		sender := &recordingSender{}
+		c := newChaos(Scenario{Latency: Latency{Distribution: LatencyFixed, Mean: Duration(20 * time.Millisecond)}}, 1, sender, log)
+
+		require.NoError(t, c.Send(ctx, queue.Response{ID: uuid.New()}))
+		assert.Equal(t, 0, sender.count())
+		assert.Eventually(t, func() bool { return sender.count() == 1 }, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("reorder", func(t *testing.T) {
+		sender := &recordingSender{}
+		c := newChaos(Scenario{ReorderWindow: 3}, 1, sender, log)
+
+		var sent []any
+		for i := 0; i < 3; i++ {
+			resp := queue.Response{ID: uuid.New()}
+			sent = append(sent, resp)
+			require.NoError(t, c.Send(ctx, resp))
+			if i < 2 {
+				assert.Equal(t, 0, sender.count())
+			}
+		}
+		assert.ElementsMatch(t, sent, sender.sent)
+	})
+
+	t.Run("latency bounds", func(t *testing.T) {
+		c := newChaos(Scenario{Latency: Latency{
+			Distribution: LatencyNormal,
+			Mean:         Duration(time.Second),
+			StdDev:       Duration(time.Second),
+			Min:          Duration(500 * time.Millisecond),
+			Max:          Duration(1500 * time.Millisecond),
+		}}, 1, &recordingSender{}, log)
+
+		for i := 0; i < 100; i++ {
+			d := c.latency()
+			assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+			assert.LessOrEqual(t, d, 1500*time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/illyasch/saga-service/pkg/data/database"
+	"github.com/illyasch/saga-service/pkg/data/events"
+)
+
+// keepAliveInterval is how often a comment is sent to idle streams to keep connections open.
+const keepAliveInterval = 15 * time.Second
+
+// connKey is the context key of the connection of a request.
+type connKey struct{}
+
+// ConnContext puts the connection into the context of its requests, so streams can replace
+// the write deadline of the server. It's set as the ConnContext of the http.Server.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// eventResponse is a status change of a saga sent in a stream.
+type eventResponse struct {
+	SagaID     uuid.UUID `json:"saga_id"`
+	Workflow   string    `json:"workflow"`
+	Service    string    `json:"service"`
+	FromStatus string    `json:"from_status,omitempty"`
+	Status     string    `json:"status"`
+	Time       time.Time `json:"time"`
+}
+
+// handleSagaEvents streams the current state of a saga followed by its status changes.
+func (cfg APIConfig) handleSagaEvents(w http.ResponseWriter, r *http.Request) {
+	sagaID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		cfg.respond(w, http.StatusBadRequest, errorResponse{Error: "input saga id is incorrect"})
+		return
+	}
+
+	// Subscribe before reading the state, so no change is missed in between.
+	ch, cancel := cfg.Events.Subscribe(events.Filter{SagaID: sagaID}, lastEventID(r))
+	defer cancel()
+
+	state, err := cfg.Saga.Get(r.Context(), sagaID)
+	if errors.Is(err, database.ErrDBNotFound) {
+		cfg.respond(w, http.StatusNotFound, errorResponse{Error: "saga not found"})
+		return
+	}
+	if err != nil {
+		cfg.respond(w, http.StatusInternalServerError, errorResponse{Error: http.StatusText(http.StatusInternalServerError)})
+		cfg.log(r.Context()).Errorw("events", "ERROR", fmt.Errorf("get saga(%s): %w", sagaID, err))
+		return
+	}
+
+	cfg.stream(w, r, ch, sagaResponse{
+		ID:          state.ID,
+		Status:      state.Status,
+		Service:     state.Service,
+		DateCreated: state.DateCreated,
+		DateUpdated: state.DateUpdated,
+	})
+}
+
+// handleEvents streams status changes of all sagas filtered by the status and workflow query parameters.
+func (cfg APIConfig) handleEvents(w http.ResponseWriter, r *http.Request) {
+	filter := events.Filter{
+		Status:   r.URL.Query().Get("status"),
+		Workflow: r.URL.Query().Get("workflow"),
+	}
+
+	ch, cancel := cfg.Events.Subscribe(filter, lastEventID(r))
+	defer cancel()
+
+	cfg.stream(w, r, ch, nil)
+}
+
+// stream writes the initial state, if any, and the changes as server-sent events until the client
+// disconnects or the stream duration passes. The client reconnects with the ID of the last received event.
+func (cfg APIConfig) stream(w http.ResponseWriter, r *http.Request, ch <-chan events.Message, initial any) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		cfg.respond(w, http.StatusInternalServerError, errorResponse{Error: "streaming is not supported"})
+		return
+	}
+
+	// The write timeout of the server would break the stream, so it's replaced with a deadline of every write.
+	conn, _ := r.Context().Value(connKey{}).(net.Conn)
+	extendDeadline := func() {
+		if conn == nil {
+			return
+		}
+		var deadline time.Time
+		if cfg.WriteTimeout > 0 {
+			deadline = time.Now().Add(cfg.WriteTimeout)
+		}
+		_ = conn.SetWriteDeadline(deadline)
+	}
+	extendDeadline()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = fmt.Fprint(w, "retry: 1000\n\n")
+	if initial != nil {
+		if err := writeEvent(w, "", "state", initial); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	end := time.NewTimer(cfg.StreamDuration)
+	defer end.Stop()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case m, ok := <-ch:
+			// The subscription is dropped when the client falls behind, it reconnects to catch up.
+			if !ok {
+				return
+			}
+
+			extendDeadline()
+			e := eventResponse{
+				SagaID:     m.Event.SagaID,
+				Workflow:   m.Event.Workflow,
+				Service:    m.Event.Step,
+				FromStatus: m.Event.FromStatus,
+				Status:     m.Event.ToStatus,
+				Time:       m.Event.Time,
+			}
+			if err := writeEvent(w, strconv.FormatUint(m.ID, 10), "transition", e); err != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			extendDeadline()
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+
+		case <-end.C:
+			return
+
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a server-sent event with JSON data.
+func writeEvent(w http.ResponseWriter, id, event string, data any) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("json marshal: %w", err)
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, jsonData)
+	return err
+}
+
+// lastEventID returns the ID of the last event received by a reconnecting client.
+func lastEventID(r *http.Request) uint64 {
+	id, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	return id
+}
@@ -0,0 +1,54 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/cmd/saga-service/handlers"
+	"github.com/illyasch/saga-service/pkg/data/audit"
+	"github.com/illyasch/saga-service/pkg/data/events"
+)
+
+func TestStreamOutlivesWriteTimeout(t *testing.T) {
+	broker := events.NewBroker(10)
+	server := httptest.NewUnstartedServer(handlers.APIConfig{
+		Log:            zap.NewNop().Sugar(),
+		Events:         broker,
+		StreamDuration: 500 * time.Millisecond,
+		WriteTimeout:   time.Second,
+	}.Router())
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Config.ConnContext = handlers.ConnContext
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The change is published after the write timeout of the server.
+	time.AfterFunc(250*time.Millisecond, func() {
+		broker.Publish(context.Background(), audit.Event{Seq: 7, SagaID: uuid.New(), Workflow: "sample", ToStatus: "completed"})
+	})
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+
+	body := strings.Join(lines, "\n")
+	assert.Contains(t, body, "id: 7\nevent: transition\n")
+}
@@ -4,66 +4,304 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.uber.org/zap"
 
 	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/business/webhook"
 	"github.com/illyasch/saga-service/pkg/data/database"
+	"github.com/illyasch/saga-service/pkg/data/events"
+	"github.com/illyasch/saga-service/pkg/sys/auth"
+	"github.com/illyasch/saga-service/pkg/sys/ratelimit"
 	"github.com/jmoiron/sqlx"
 )
 
 // APIConfig contains all the mandatory systems required by handlers.
+// Webhooks is optional, without it callbacks are not accepted. Events is optional, without it
+// streams of saga status changes are not served. Streams are closed after the StreamDuration.
+// They outlive the write timeout of a server with ConnContext, every write of a stream has
+// the WriteTimeout instead.
+// Auth is optional, without it the API is open. RateLimits are limiters of requests per client keyed
+// by the route name, requests of routes without a limiter are not limited. Limits apply before authentication.
 type APIConfig struct {
-	Log  *zap.SugaredLogger
-	DB   *sqlx.DB
-	Saga saga.Saga
+	Log            *zap.SugaredLogger
+	DB             *sqlx.DB
+	Saga           saga.Saga
+	Webhooks       *webhook.Dispatcher
+	Events         *events.Broker
+	StreamDuration time.Duration
+	WriteTimeout   time.Duration
+	Auth           *auth.Auth
+	RateLimits     map[string]*ratelimit.Limiter
 }
 
+// Set of names of routes with rate limits.
+const (
+	RouteStart  = "start"
+	RouteSagas  = "sagas"
+	RouteManage = "manage"
+	RouteEvents = "events"
+)
+
 type errorResponse struct {
 	Error string `json:"error"`
 }
 
 // Router constructs a http.Handler with all application routes defined.
 func (cfg APIConfig) Router() http.Handler {
+	return cfg.middleware(cfg.Routes())
+}
+
+// Routes constructs the router of all application routes without the common middleware.
+func (cfg APIConfig) Routes() *mux.Router {
 	router := mux.NewRouter()
-	router.HandleFunc("/start", cfg.handleStart()).Methods(http.MethodPost)
+	router.Handle("/start", otelhttp.NewHandler(
+		cfg.limit(RouteStart, cfg.authorize(auth.ScopeStart, cfg.handleStart())), "/start")).Methods(http.MethodPost)
+	router.Handle("/sagas/{id}",
+		cfg.limit(RouteSagas, cfg.authorize(auth.ScopeRead, http.HandlerFunc(cfg.handleSaga)))).Methods(http.MethodGet)
+	router.Handle("/sagas/{id}/cancel",
+		cfg.limit(RouteManage, cfg.authorize(auth.ScopeManage, cfg.handleControl(cfg.Saga.Cancel)))).Methods(http.MethodPost)
+	router.Handle("/sagas/{id}/retry",
+		cfg.limit(RouteManage, cfg.authorize(auth.ScopeManage, cfg.handleControl(cfg.Saga.Retry)))).Methods(http.MethodPost)
+	if cfg.Events != nil {
+		router.Handle("/sagas/{id}/events",
+			cfg.limit(RouteEvents, cfg.authorize(auth.ScopeRead, http.HandlerFunc(cfg.handleSagaEvents)))).Methods(http.MethodGet)
+		router.Handle("/events",
+			cfg.limit(RouteEvents, cfg.authorize(auth.ScopeRead, http.HandlerFunc(cfg.handleEvents)))).Methods(http.MethodGet)
+	}
 	router.HandleFunc("/readiness", cfg.handleReadiness).Methods(http.MethodGet)
 	router.HandleFunc("/liveness", cfg.handleLiveness).Methods(http.MethodGet)
+	router.Handle("/metrics", cfg.authorize(auth.ScopeAdmin, promhttp.Handler())).Methods(http.MethodGet)
+	router.HandleFunc("/openapi.json", handleOpenAPI).Methods(http.MethodGet)
 
 	return router
 }
 
-// handleStart handler starts a saga with a given id.
+// authorize lets the request through to the handler only if the caller is authenticated and has the scope.
+// The claims of the caller are put into the request context.
+func (cfg APIConfig) authorize(scope string, h http.Handler) http.Handler {
+	if cfg.Auth == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := cfg.Auth.Authenticate(r)
+		if err == nil {
+			err = claims.Require(scope)
+		}
+		switch {
+		case errors.Is(err, auth.ErrForbidden):
+			cfg.respond(w, http.StatusForbidden, errorResponse{Error: fmt.Sprintf("scope %s is required", scope)})
+			cfg.log(r.Context()).Infow("auth", "ERROR", err, "subject", claims.Subject)
+			return
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			cfg.respond(w, http.StatusUnauthorized, errorResponse{Error: http.StatusText(http.StatusUnauthorized)})
+			cfg.log(r.Context()).Infow("auth", "ERROR", err)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), claims)))
+	})
+}
+
+// limit lets the request through to the handler only if the client is within the rate limit of the route.
+// Clients are limited by their remote address before they are authenticated, so guessing of credentials is limited too.
+func (cfg APIConfig) limit(route string, h http.Handler) http.Handler {
+	limiter, ok := cfg.RateLimits[route]
+	if !ok {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := "addr:" + r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			client = "addr:" + host
+		}
+
+		if ok, retryAfter := limiter.Allow(client); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			cfg.respond(w, http.StatusTooManyRequests, errorResponse{Error: http.StatusText(http.StatusTooManyRequests)})
+			cfg.log(r.Context()).Infow("ratelimit", "status", "rate limit is exceeded", "client", client)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleStart handler starts a saga with a given id, an optional JSON payload and an optional callback URL
+// which receives the outcome of the saga.
 func (cfg APIConfig) handleStart() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		var sagaUUID uuid.UUID
+		ctx := r.Context()
 
 		sagaID := r.FormValue("saga_id")
 		sagaUUID, err = uuid.Parse(sagaID)
 
 		if err != nil {
 			cfg.respond(w, http.StatusBadRequest, errorResponse{Error: "input saga id is incorrect"})
-			cfg.Log.Errorw("saga", "ERROR", fmt.Errorf("validation saga id(%s): %w", sagaID, err))
+			cfg.log(ctx).Errorw("saga", "ERROR", fmt.Errorf("validation saga id(%s): %w", sagaID, err))
 			return
 		}
 
-		if err := cfg.Saga.Start(r.Context(), sagaUUID); err != nil {
+		var payload json.RawMessage
+		if p := r.FormValue("payload"); p != "" {
+			if !json.Valid([]byte(p)) {
+				cfg.respond(w, http.StatusBadRequest, errorResponse{Error: "input payload is not valid JSON"})
+				cfg.log(ctx).Errorw("saga", "ERROR", fmt.Errorf("validation payload saga id(%s)", sagaID))
+				return
+			}
+			payload = json.RawMessage(p)
+		}
+
+		var callback *database.Webhook
+		if callbackURL := r.FormValue("callback_url"); callbackURL != "" {
+			if cfg.Webhooks == nil {
+				cfg.respond(w, http.StatusBadRequest, errorResponse{Error: "callbacks are not supported"})
+				return
+			}
+
+			callback, err = cfg.Webhooks.Callback(callbackURL, r.FormValue("callback_secret"))
+			if err != nil {
+				cfg.respond(w, http.StatusBadRequest, errorResponse{Error: "input callback url is incorrect"})
+				cfg.log(ctx).Errorw("saga", "ERROR", fmt.Errorf("validation callback saga id(%s): %w", sagaID, err))
+				return
+			}
+		}
+
+		err = cfg.Saga.Start(ctx, sagaUUID, payload, callback)
+		if errors.Is(err, database.ErrDBDuplicatedEntry) {
+			cfg.respond(w, http.StatusConflict, errorResponse{Error: "saga already exists"})
+			cfg.log(ctx).Infow("saga", "ERROR", fmt.Errorf("saga id(%s): %w", sagaID, err))
+			return
+		}
+		if errors.Is(err, saga.ErrTooManyRunning) {
+			cfg.respond(w, http.StatusTooManyRequests, errorResponse{Error: "too many running sagas"})
+			cfg.log(ctx).Infow("saga", "ERROR", err)
+			return
+		}
+		if err != nil {
 			cfg.respond(w, http.StatusInternalServerError, errorResponse{
 				Error: http.StatusText(http.StatusInternalServerError),
 			})
-			cfg.Log.Errorw("saga", "ERROR", fmt.Errorf("saga start: %w", err))
+			cfg.log(ctx).Errorw("saga", "ERROR", fmt.Errorf("saga start: %w", err))
 			return
 		}
 
 		cfg.respond(w, http.StatusOK, nil)
-		cfg.Log.Infow("saga", "statusCode", http.StatusOK, "method", r.Method, "path", r.URL.Path, "remoteaddr", r.RemoteAddr)
+	}
+}
+
+// sagaResponse is the state of a saga returned by the API.
+type sagaResponse struct {
+	ID          uuid.UUID        `json:"id"`
+	Status      string           `json:"status"`
+	Service     string           `json:"service"`
+	DateCreated time.Time        `json:"date_created"`
+	DateUpdated time.Time        `json:"date_updated"`
+	Webhook     *webhookResponse `json:"webhook,omitempty"`
+}
+
+// webhookResponse is the state of the delivery of a saga outcome to its callback.
+type webhookResponse struct {
+	URL           string     `json:"url"`
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttempt   *time.Time `json:"next_attempt,omitempty"`
+	DateDelivered *time.Time `json:"date_delivered,omitempty"`
+}
+
+// handleSaga returns the state of a saga with the state of its callback.
+func (cfg APIConfig) handleSaga(w http.ResponseWriter, r *http.Request) {
+	sagaID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		cfg.respond(w, http.StatusBadRequest, errorResponse{Error: "input saga id is incorrect"})
+		return
+	}
+
+	state, err := cfg.Saga.Get(r.Context(), sagaID)
+	if errors.Is(err, database.ErrDBNotFound) {
+		cfg.respond(w, http.StatusNotFound, errorResponse{Error: "saga not found"})
+		return
+	}
+	if err != nil {
+		cfg.respond(w, http.StatusInternalServerError, errorResponse{Error: http.StatusText(http.StatusInternalServerError)})
+		cfg.log(r.Context()).Errorw("saga", "ERROR", fmt.Errorf("get saga(%s): %w", sagaID, err))
+		return
+	}
+
+	resp := sagaResponse{
+		ID:          state.ID,
+		Status:      state.Status,
+		Service:     state.Service,
+		DateCreated: state.DateCreated,
+		DateUpdated: state.DateUpdated,
+	}
+
+	if cfg.Webhooks != nil {
+		wh, err := cfg.Webhooks.Delivery(r.Context(), sagaID)
+		switch {
+		case err == nil:
+			resp.Webhook = &webhookResponse{
+				URL:       wh.URL,
+				State:     wh.State,
+				Attempts:  wh.Attempts,
+				LastError: wh.LastError,
+			}
+			if wh.NextAttempt.Valid {
+				resp.Webhook.NextAttempt = &wh.NextAttempt.Time
+			}
+			if wh.DateDelivered.Valid {
+				resp.Webhook.DateDelivered = &wh.DateDelivered.Time
+			}
+		case !errors.Is(err, database.ErrDBNotFound):
+			cfg.respond(w, http.StatusInternalServerError, errorResponse{Error: http.StatusText(http.StatusInternalServerError)})
+			cfg.log(r.Context()).Errorw("saga", "ERROR", fmt.Errorf("get webhook(%s): %w", sagaID, err))
+			return
+		}
+	}
+
+	cfg.respond(w, http.StatusOK, resp)
+}
+
+// handleControl returns a handler applying the control operation, such as cancel or retry, to a saga.
+func (cfg APIConfig) handleControl(operation func(context.Context, uuid.UUID) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sagaID, err := uuid.Parse(mux.Vars(r)["id"])
+		if err != nil {
+			cfg.respond(w, http.StatusBadRequest, errorResponse{Error: "input saga id is incorrect"})
+			return
+		}
+
+		err = operation(r.Context(), sagaID)
+		switch {
+		case err == nil:
+			cfg.respond(w, http.StatusOK, nil)
+		case errors.Is(err, database.ErrDBNotFound):
+			cfg.respond(w, http.StatusNotFound, errorResponse{Error: "saga not found"})
+		case errors.Is(err, saga.ErrWrongStatus):
+			cfg.respond(w, http.StatusConflict, errorResponse{Error: err.Error()})
+		default:
+			cfg.respond(w, http.StatusInternalServerError, errorResponse{Error: http.StatusText(http.StatusInternalServerError)})
+			cfg.log(r.Context()).Errorw("saga", "ERROR", fmt.Errorf("%s saga(%s): %w", path.Base(r.URL.Path), sagaID, err))
+		}
 	}
 }
 
@@ -77,7 +315,7 @@ func (cfg APIConfig) handleReadiness(w http.ResponseWriter, r *http.Request) {
 	if err := database.StatusCheck(ctx, cfg.DB); err != nil {
 		status = "db not ready"
 		statusCode = http.StatusInternalServerError
-		cfg.Log.Errorw("readiness", "ERROR", fmt.Errorf("status check: %w", err))
+		cfg.log(r.Context()).Errorw("readiness", "ERROR", fmt.Errorf("status check: %w", err))
 	}
 
 	data := struct {
@@ -87,7 +325,6 @@ func (cfg APIConfig) handleReadiness(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cfg.respond(w, statusCode, data)
-	cfg.Log.Infow("readiness", "statusCode", statusCode, "method", r.Method, "path", r.URL.Path, "remoteaddr", r.RemoteAddr)
 }
 
 // handleLiveness returns simple status info if the service is alive. If the
@@ -119,7 +356,6 @@ func (cfg APIConfig) handleLiveness(w http.ResponseWriter, r *http.Request) {
 
 	statusCode := http.StatusOK
 	cfg.respond(w, statusCode, data)
-	cfg.Log.Infow("liveness", "statusCode", statusCode, "method", r.Method, "path", r.URL.Path, "remoteaddr", r.RemoteAddr)
 }
 
 func (cfg APIConfig) respond(w http.ResponseWriter, statusCode int, data any) {
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/sys/requestid"
+)
+
+// statusRecorder remembers the status code and the size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// middleware wraps the handler with the middleware common to all routes. Requests get an ID first,
+// so the access log and recovered panics carry it.
+func (cfg APIConfig) middleware(h http.Handler) http.Handler {
+	return cfg.requestID(cfg.accessLog(cfg.recoverPanic(h)))
+}
+
+// requestID puts the ID of the request into the context and the response. The ID is taken from
+// the request header or generated if the header is missing or not a valid request ID.
+func (cfg APIConfig) requestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestid.Header, id)
+
+		h.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// accessLog writes one log line per request with its status and latency.
+func (cfg APIConfig) accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := statusRecorder{ResponseWriter: w}
+
+		h.ServeHTTP(&rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		cfg.log(r.Context()).Infow("request", "statusCode", rec.status, "method", r.Method, "path", r.URL.Path,
+			"remoteaddr", r.RemoteAddr, "bytes", rec.bytes, "latency", time.Since(started).String())
+	})
+}
+
+// recoverPanic turns a panic of the handler into a 500 response.
+func (cfg APIConfig) recoverPanic(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// The abort panic is the way to abort a response, net/http handles it.
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			cfg.log(r.Context()).Errorw("panic", "ERROR", fmt.Errorf("%v", rec), "stack", string(debug.Stack()))
+			cfg.respond(w, http.StatusInternalServerError, errorResponse{Error: http.StatusText(http.StatusInternalServerError)})
+		}()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// log returns the logger with the ID of the request in the context.
+func (cfg APIConfig) log(ctx context.Context) *zap.SugaredLogger {
+	if id := requestid.FromContext(ctx); id != "" {
+		return cfg.Log.With("request_id", id)
+	}
+
+	return cfg.Log
+}
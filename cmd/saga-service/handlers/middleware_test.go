@@ -0,0 +1,154 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/cmd/saga-service/handlers"
+	"github.com/illyasch/saga-service/pkg/sys/auth"
+	"github.com/illyasch/saga-service/pkg/sys/ratelimit"
+	"github.com/illyasch/saga-service/pkg/sys/requestid"
+)
+
+func TestMiddleware(t *testing.T) {
+	router := handlers.APIConfig{Log: stdLgr, DB: postgresDB}.Router()
+
+	t.Run("request ID is kept", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/liveness", nil)
+		req.Header.Set(requestid.Header, "request1")
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "request1", rec.Header().Get(requestid.Header))
+	})
+
+	t.Run("request ID is generated", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/liveness", nil))
+		_, err := uuid.Parse(rec.Header().Get(requestid.Header))
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid request ID is replaced", func(t *testing.T) {
+		for name, id := range map[string]string{
+			"too long":         strings.Repeat("a", requestid.MaxLength+1),
+			"unsafe character": "request1\nlevel=error",
+			"space":            "request 1",
+			"non-ASCII":        "requést1",
+		} {
+			t.Run(name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/liveness", nil)
+				req.Header.Set(requestid.Header, id)
+				rec := httptest.NewRecorder()
+
+				router.ServeHTTP(rec, req)
+				assert.Equal(t, http.StatusOK, rec.Code)
+				_, err := uuid.Parse(rec.Header().Get(requestid.Header))
+				assert.NoError(t, err)
+			})
+		}
+	})
+
+	t.Run("longest request ID is kept", func(t *testing.T) {
+		id := strings.Repeat("a", requestid.MaxLength-7) + "-1_2.3:"
+		req := httptest.NewRequest(http.MethodGet, "/liveness", nil)
+		req.Header.Set(requestid.Header, id)
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, id, rec.Header().Get(requestid.Header))
+	})
+
+	t.Run("panic is recovered", func(t *testing.T) {
+		// The saga without storage panics on reading a saga.
+		rec := httptest.NewRecorder()
+
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sagas/"+uuid.NewString(), nil))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.JSONEq(t, `{"error": "Internal Server Error"}`, rec.Body.String())
+		assert.NotEmpty(t, rec.Header().Get(requestid.Header))
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	keys := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(keys, []byte(`[
+		{"client": "billing", "key": "billing-key", "scopes": ["saga:start"]},
+		{"client": "support", "key": "support-key", "scopes": ["saga:manage"]},
+		{"client": "ops", "key": "ops-key", "scopes": ["saga:admin"]}
+	]`), 0o600))
+	authn, err := auth.New(auth.Config{APIKeysFile: keys})
+	require.NoError(t, err)
+
+	router := handlers.APIConfig{Log: stdLgr, DB: postgresDB, Auth: authn}.Router()
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		code   int
+	}{
+		{name: "unauthenticated", method: http.MethodGet, path: "/metrics", code: http.StatusUnauthorized},
+		{name: "unknown key", method: http.MethodGet, path: "/metrics", key: "other-key", code: http.StatusUnauthorized},
+		{name: "missing scope", method: http.MethodGet, path: "/metrics", key: "billing-key", code: http.StatusForbidden},
+		{name: "granted scope", method: http.MethodGet, path: "/metrics", key: "ops-key", code: http.StatusOK},
+		// The saga ID is invalid, so granted requests stop at its validation.
+		{name: "cancel with the start scope", method: http.MethodPost, path: "/sagas/1/cancel", key: "billing-key", code: http.StatusForbidden},
+		{name: "retry with the start scope", method: http.MethodPost, path: "/sagas/1/retry", key: "billing-key", code: http.StatusForbidden},
+		{name: "cancel with the manage scope", method: http.MethodPost, path: "/sagas/1/cancel", key: "support-key", code: http.StatusBadRequest},
+		{name: "retry with the admin scope", method: http.MethodPost, path: "/sagas/1/retry", key: "ops-key", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(auth.HeaderAPIKey, tt.key)
+			}
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.code, rec.Code)
+		})
+	}
+}
+
+func TestLimitBeforeAuthorize(t *testing.T) {
+	keys := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(keys, []byte(`[{"client": "support", "key": "support-key", "scopes": ["saga:manage"]}]`), 0o600))
+	authn, err := auth.New(auth.Config{APIKeysFile: keys})
+	require.NoError(t, err)
+
+	router := handlers.APIConfig{
+		Log:        stdLgr,
+		DB:         postgresDB,
+		Auth:       authn,
+		RateLimits: map[string]*ratelimit.Limiter{handlers.RouteManage: ratelimit.New(0.01, 2)},
+	}.Router()
+	send := func(key, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/sagas/1/cancel", nil)
+		req.Header.Set(auth.HeaderAPIKey, key)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send("guessed-key-1", "192.0.2.1:1234").Code)
+	assert.Equal(t, http.StatusUnauthorized, send("guessed-key-2", "192.0.2.1:1235").Code)
+	rec := send("guessed-key-3", "192.0.2.1:1236")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// The saga ID is invalid, so a granted request stops at its validation.
+	assert.Equal(t, http.StatusBadRequest, send("support-key", "192.0.2.2:1234").Code)
+}
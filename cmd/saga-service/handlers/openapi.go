@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPI is the OpenAPI 3 document describing the API.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// handleOpenAPI returns the OpenAPI document of the API.
+func handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(OpenAPI)
+}
@@ -0,0 +1,62 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/cmd/saga-service/handlers"
+	"github.com/illyasch/saga-service/pkg/data/events"
+)
+
+// TestOpenAPI checks that the OpenAPI document describes exactly the routes of the router.
+func TestOpenAPI(t *testing.T) {
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(handlers.OpenAPI, &doc))
+	assert.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+
+	var documented []string
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	cfg := handlers.APIConfig{Log: stdLgr, DB: postgresDB, Events: events.NewBroker(1)}
+	var routed []string
+	err := cfg.Routes().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routed = append(routed, method+" "+path)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	sort.Strings(documented)
+	sort.Strings(routed)
+	assert.Equal(t, routed, documented)
+
+	rec := httptest.NewRecorder()
+	cfg.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(handlers.OpenAPI), rec.Body.String())
+}
@@ -18,14 +18,25 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
 	"github.com/illyasch/saga-service/cmd/saga-service/handlers"
+	"github.com/illyasch/saga-service/pkg/business/retention"
 	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/business/stuck"
+	"github.com/illyasch/saga-service/pkg/business/webhook"
+	"github.com/illyasch/saga-service/pkg/data/audit"
 	"github.com/illyasch/saga-service/pkg/data/database"
+	"github.com/illyasch/saga-service/pkg/data/database/dbschema"
+	"github.com/illyasch/saga-service/pkg/data/events"
 	"github.com/illyasch/saga-service/pkg/data/queue"
 	"github.com/illyasch/saga-service/pkg/sys/app"
+	"github.com/illyasch/saga-service/pkg/sys/auth"
+	"github.com/illyasch/saga-service/pkg/sys/envelope"
 	"github.com/illyasch/saga-service/pkg/sys/logger"
+	"github.com/illyasch/saga-service/pkg/sys/ratelimit"
+	"github.com/illyasch/saga-service/pkg/sys/tracing"
 )
 
 const configPrefix = "SAGA"
@@ -40,19 +51,97 @@ type config struct {
 		MaxIdleConns int    `conf:"default:0"`
 		MaxOpenConns int    `conf:"default:0"`
 		DisableTLS   bool   `conf:"default:true"`
+		SchemaCheck  bool   `conf:"default:true"`
 	}
 	Web struct {
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:10s"`
 		IdleTimeout     time.Duration `conf:"default:120s"`
+		StreamDuration  time.Duration `conf:"default:10m"`
 		ShutdownTimeout time.Duration `conf:"default:20s"`
 		APIHost         string        `conf:"default:0.0.0.0:3000"`
 	}
 	Queue struct {
-		AWSEndpoint string `conf:"default:http://localhost:4566"`
-		AWSRegion   string `conf:"default:us-west-1a"`
-		MaxMessages int64  `conf:"default:10"`
-		WaitTime    int64  `conf:"default:20"`
+		AWSEndpoint     string        `conf:"default:http://localhost:4566"`
+		AWSRegion       string        `conf:"default:us-west-1a"`
+		ResponsesQueue  string        `conf:"default:responses"`
+		DeadLetterQueue string        `conf:"default:responses-dlq"`
+		MaxMessages     int64         `conf:"default:10"`
+		WaitTime        int64         `conf:"default:20"`
+		BatchInterval   time.Duration `conf:"default:50ms"`
+	}
+	Workflow struct {
+		// SchemaDir has the JSON schemas of responses named after the services, e.g. service1.json.
+		SchemaDir string
+	}
+	Signing struct {
+		KeyID string
+		Keys  map[string]string `conf:"mask"`
+		// Owners maps ids of keys to the services signing responses with them.
+		Owners map[string]string
+		Strict bool `conf:"default:false"`
+	}
+	Auth struct {
+		APIKeysFile      string
+		HMACSecret       string `conf:"mask"`
+		RSAPublicKeyFile string
+		Issuer           string
+		Audience         string
+	}
+	RateLimit struct {
+		Rates      map[string]float64 `conf:"default:start:10;sagas:50;manage:5;events:5"`
+		Bursts     map[string]int     `conf:"default:start:20;sagas:100;manage:10;events:10"`
+		MaxRunning int
+	}
+	Encryption struct {
+		KeyringFile string
+	}
+	Webhook struct {
+		Interval    time.Duration `conf:"default:5s"`
+		Timeout     time.Duration `conf:"default:10s"`
+		BatchSize   int           `conf:"default:10"`
+		MaxAttempts int           `conf:"default:10"`
+		MinBackoff  time.Duration `conf:"default:5s"`
+		MaxBackoff  time.Duration `conf:"default:1h"`
+		// AllowPrivate allows callbacks to loopback, link-local and private addresses.
+		AllowPrivate bool `conf:"default:false"`
+	}
+	Outbox struct {
+		Interval  time.Duration `conf:"default:1s"`
+		BatchSize int           `conf:"default:100"`
+		Lease     time.Duration `conf:"default:30s"`
+	}
+	Stuck struct {
+		Interval      time.Duration `conf:"default:1m"`
+		Threshold     time.Duration `conf:"default:15m"`
+		Thresholds    map[string]time.Duration
+		Repeat        time.Duration
+		BatchSize     int    `conf:"default:1000"`
+		Notifier      string `conf:"default:log"`
+		WebhookURL    string
+		WebhookSecret string        `conf:"mask"`
+		Timeout       time.Duration `conf:"default:10s"`
+	}
+	Retention struct {
+		Interval  time.Duration `conf:"default:1h"`
+		Periods   map[string]time.Duration
+		BatchSize int           `conf:"default:100"`
+		Pause     time.Duration `conf:"default:100ms"`
+	}
+	Events struct {
+		Backend     string `conf:"default:postgres"`
+		HistorySize int    `conf:"default:1024"`
+	}
+	Audit struct {
+		Sink string `conf:"default:postgres"`
+		File string `conf:"default:audit.jsonl"`
+	}
+	Tracing struct {
+		Exporter    string
+		Endpoint    string  `conf:"default:localhost:4318"`
+		Insecure    bool    `conf:"default:true"`
+		File        string  `conf:"default:traces.json"`
+		Probability float64 `conf:"default:1"`
 	}
 }
 
@@ -98,10 +187,40 @@ func initialize(log *zap.SugaredLogger) (*app.App, error) {
 
 	expvar.NewString("build").Set(build)
 
+	// Start tracing support.
+	log.Infow("startup", "status", "initializing tracing support", "exporter", cfg.Tracing.Exporter)
+
+	stopTracing, err := tracing.Start(tracing.Config{
+		ServiceName: "saga-service",
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		File:        cfg.Tracing.File,
+		Probability: cfg.Tracing.Probability,
+	})
+	if err != nil {
+		return app, fmt.Errorf("starting tracing: %w", err)
+	}
+
+	// Create encryption of saga payloads.
+	var storageOpts []database.StorageOption
+	var senderOpts []queue.SenderOption
+	var pollOpts []queue.PollOption
+	if cfg.Encryption.KeyringFile != "" {
+		keyring, err := envelope.LoadFileKeyring(cfg.Encryption.KeyringFile)
+		if err != nil {
+			return app, fmt.Errorf("loading encryption keyring: %w", err)
+		}
+		cipher := envelope.New(keyring)
+		storageOpts = append(storageOpts, database.WithEncryption(cipher))
+		senderOpts = append(senderOpts, queue.WithEncryption(cipher))
+		pollOpts = append(pollOpts, queue.WithDecryption(cipher))
+	}
+
 	// Create connectivity to the database.
 	log.Infow("startup", "status", "initializing database support", "host", cfg.DB.Host)
 
-	db, err := database.Open(database.Config{
+	dbConfig := database.Config{
 		User:         cfg.DB.User,
 		Password:     cfg.DB.Password,
 		Host:         cfg.DB.Host,
@@ -109,11 +228,22 @@ func initialize(log *zap.SugaredLogger) (*app.App, error) {
 		MaxIdleConns: cfg.DB.MaxIdleConns,
 		MaxOpenConns: cfg.DB.MaxOpenConns,
 		DisableTLS:   cfg.DB.DisableTLS,
-	})
+	}
+	db, err := database.Open(dbConfig)
 	if err != nil {
 		return app, fmt.Errorf("connecting to db: %w", err)
 	}
 
+	// Refuse to start with a database schema behind the binary.
+	if cfg.DB.SchemaCheck {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := dbschema.Check(ctx, db)
+		cancel()
+		if err != nil {
+			return app, fmt.Errorf("checking db schema: %w", err)
+		}
+	}
+
 	// Create connectivity to the queue.
 	awsConfig := aws.NewConfig().WithRegion(cfg.Queue.AWSRegion)
 	if cfg.Queue.AWSEndpoint != "" {
@@ -122,28 +252,164 @@ func initialize(log *zap.SugaredLogger) (*app.App, error) {
 	// Generic AWS service container with credentials.
 	awsSQS := sqs.New(session.Must(session.NewSession()), awsConfig)
 
-	// Create saga business logic.
-	workflow, err := saga.NewWorkflowWithSQS(saga.SampleWorkflow, awsSQS)
+	// Create signing of queue messages.
+	if len(cfg.Signing.Keys) > 0 {
+		var keyringOpts []queue.KeyringOption
+		if len(cfg.Signing.Owners) > 0 {
+			keyringOpts = append(keyringOpts, queue.WithOwners(cfg.Signing.Owners))
+		}
+		keyring, err := queue.NewKeyring(cfg.Signing.KeyID, cfg.Signing.Keys, keyringOpts...)
+		if err != nil {
+			return app, fmt.Errorf("creating signing keyring: %w", err)
+		}
+		senderOpts = append(senderOpts, queue.WithSigning(keyring))
+		pollOpts = append(pollOpts, queue.WithVerification(keyring, cfg.Signing.Strict))
+	}
+
+	// Create saga business logic. Invalid schemas of responses fail the startup.
+	if cfg.Workflow.SchemaDir != "" {
+		if err := saga.LoadSchemas(cfg.Workflow.SchemaDir, saga.SampleWorkflow); err != nil {
+			return app, fmt.Errorf("loading response schemas: %w", err)
+		}
+	}
+	workflow, batches, err := saga.NewWorkflowWithBatchSQS(saga.SampleWorkflowName, saga.SampleWorkflow, awsSQS, cfg.Queue.BatchInterval, senderOpts...)
 	if err != nil {
 		return app, fmt.Errorf("creating saga workflow: %w", err)
 	}
-	sga := saga.New(workflow, database.NewStorage(db))
+	storage := database.NewStorage(db, storageOpts...)
+	prometheus.MustRegister(database.NewStatusCollector(storage))
+	// Create audit of saga state changes.
+	sagaOpts := []saga.Option{saga.WithLogger(log)}
+	switch cfg.Audit.Sink {
+	case "postgres":
+		sagaOpts = append(sagaOpts, saga.WithAudit(audit.NewPostgresSink(db)))
+	case "file":
+		sink, err := audit.NewFileSink(cfg.Audit.File)
+		if err != nil {
+			return app, fmt.Errorf("creating audit sink: %w", err)
+		}
+		app.Add(func(ctx context.Context) error {
+			<-ctx.Done()
+			return sink.Close()
+		})
+		sagaOpts = append(sagaOpts, saga.WithAudit(sink))
+	case "":
+	default:
+		return app, fmt.Errorf("unknown audit sink %q", cfg.Audit.Sink)
+	}
+	// Create delivery of saga outcomes to callbacks.
+	webhooks := webhook.NewDispatcher(storage, webhook.Config{
+		Interval:     cfg.Webhook.Interval,
+		Timeout:      cfg.Webhook.Timeout,
+		BatchSize:    cfg.Webhook.BatchSize,
+		MaxAttempts:  cfg.Webhook.MaxAttempts,
+		MinBackoff:   cfg.Webhook.MinBackoff,
+		MaxBackoff:   cfg.Webhook.MaxBackoff,
+		AllowPrivate: cfg.Webhook.AllowPrivate,
+	}, log)
+	sagaOpts = append(sagaOpts, saga.WithNotifier(webhooks))
+	// Create streaming of saga status changes. With the postgres backend changes are relayed
+	// to all instances of the service.
+	broker := events.NewBroker(cfg.Events.HistorySize)
+	switch cfg.Events.Backend {
+	case "memory":
+		sagaOpts = append(sagaOpts, saga.WithPublisher(broker))
+	case "postgres":
+		sagaOpts = append(sagaOpts, saga.WithPublisher(events.NewPostgresPublisher(db, log)))
+		app.Add(func(ctx context.Context) error {
+			return events.Listen(ctx, database.ConnString(dbConfig), broker, log)
+		})
+	default:
+		return app, fmt.Errorf("unknown events backend %q", cfg.Events.Backend)
+	}
+	if cfg.RateLimit.MaxRunning > 0 {
+		sagaOpts = append(sagaOpts, saga.WithMaxRunning(cfg.RateLimit.MaxRunning))
+	}
+	sga := saga.New(workflow, storage, sagaOpts...)
+	// Create detection of sagas stuck in a step.
+	var notifier stuck.Notifier
+	switch cfg.Stuck.Notifier {
+	case "log":
+		notifier = stuck.NewLogNotifier(log)
+	case "webhook":
+		notifier, err = stuck.NewWebhookNotifier(cfg.Stuck.WebhookURL, cfg.Stuck.WebhookSecret, cfg.Stuck.Timeout)
+		if err != nil {
+			return app, fmt.Errorf("creating stuck saga notifier: %w", err)
+		}
+	default:
+		return app, fmt.Errorf("unknown stuck saga notifier %q", cfg.Stuck.Notifier)
+	}
+	detector := stuck.NewDetector(storage, notifier, stuck.Config{
+		Interval:   cfg.Stuck.Interval,
+		Status:     saga.StatusStarted,
+		Threshold:  cfg.Stuck.Threshold,
+		Thresholds: cfg.Stuck.Thresholds,
+		Repeat:     cfg.Stuck.Repeat,
+		BatchSize:  cfg.Stuck.BatchSize,
+	}, log)
+	// Create archiving of finished sagas after their retention.
+	policies, err := retention.ParsePolicies(cfg.Retention.Periods)
+	if err != nil {
+		return app, fmt.Errorf("parsing retention periods: %w", err)
+	}
+	archiver, err := retention.NewArchiver(storage, retention.Config{
+		Interval:  cfg.Retention.Interval,
+		Policies:  policies,
+		BatchSize: cfg.Retention.BatchSize,
+		Pause:     cfg.Retention.Pause,
+	}, log)
+	if err != nil {
+		return app, fmt.Errorf("creating archiver: %w", err)
+	}
 	// Create queue receiver.
-	r, err := queue.NewReceiver(awsSQS, saga.QueueName, cfg.Queue.MaxMessages, cfg.Queue.WaitTime)
+	r, err := queue.NewReceiver(awsSQS, cfg.Queue.ResponsesQueue, cfg.Queue.MaxMessages, cfg.Queue.WaitTime)
+	if err != nil {
+		return app, fmt.Errorf("creating receiver(%s): %w", cfg.Queue.ResponsesQueue, err)
+	}
+	// Create sender for rejected responses.
+	dlq, err := queue.NewSender(awsSQS, cfg.Queue.DeadLetterQueue)
 	if err != nil {
-		return app, fmt.Errorf("creating receiver(%s): %w", saga.QueueName, err)
+		return app, fmt.Errorf("creating dead-letter sender(%s): %w", cfg.Queue.DeadLetterQueue, err)
 	}
 	// Create saga response queue poller.
-	poller, err := queue.NewPoll[queue.Response](&r, sga, log)
+	pollOpts = append(pollOpts, queue.WithDeadLetter(dlq))
+	poller, err := queue.NewPoll[queue.Response](&r, sga, log, pollOpts...)
 	if err != nil {
 		return app, fmt.Errorf("creating poller: %w", err)
 	}
 
+	// Create authentication of API callers.
+	authn, err := auth.New(auth.Config{
+		APIKeysFile:      cfg.Auth.APIKeysFile,
+		HMACSecret:       cfg.Auth.HMACSecret,
+		RSAPublicKeyFile: cfg.Auth.RSAPublicKeyFile,
+		Issuer:           cfg.Auth.Issuer,
+		Audience:         cfg.Auth.Audience,
+	})
+	if err != nil {
+		return app, fmt.Errorf("creating auth: %w", err)
+	}
+	if authn == nil {
+		log.Warnw("startup", "status", "no auth keys are configured, the API is open")
+	}
+
+	// Create rate limits of API routes.
+	rateLimits := make(map[string]*ratelimit.Limiter, len(cfg.RateLimit.Rates))
+	for route, perSecond := range cfg.RateLimit.Rates {
+		rateLimits[route] = ratelimit.New(perSecond, cfg.RateLimit.Bursts[route])
+	}
+
 	// Construct the mux for the API calls.
 	apiMux := handlers.APIConfig{
-		DB:   db,
-		Log:  log,
-		Saga: sga,
+		DB:             db,
+		Log:            log,
+		Saga:           sga,
+		Webhooks:       &webhooks,
+		Events:         broker,
+		StreamDuration: cfg.Web.StreamDuration,
+		WriteTimeout:   cfg.Web.WriteTimeout,
+		Auth:           authn,
+		RateLimits:     rateLimits,
 	}.Router()
 
 	// Construct a server to service the requests against the mux.
@@ -154,6 +420,8 @@ func initialize(log *zap.SugaredLogger) (*app.App, error) {
 		WriteTimeout: cfg.Web.WriteTimeout,
 		IdleTimeout:  cfg.Web.IdleTimeout,
 		ErrorLog:     zap.NewStdLog(log.Desugar()),
+		// Streams of events replace the write timeout with deadlines of their writes.
+		ConnContext: handlers.ConnContext,
 	}
 
 	// Adding tasks to App.
@@ -170,6 +438,26 @@ func initialize(log *zap.SugaredLogger) (*app.App, error) {
 	app.Add(func(ctx context.Context) error {
 		return poller.Start(ctx)
 	})
+	// Spin up relay of state changes which weren't delivered after they were committed.
+	app.Add(func(ctx context.Context) error {
+		return sga.RunOutbox(ctx, saga.OutboxConfig{
+			Interval:  cfg.Outbox.Interval,
+			BatchSize: cfg.Outbox.BatchSize,
+			Lease:     cfg.Outbox.Lease,
+		})
+	})
+	// Spin up delivery of webhooks.
+	app.Add(webhooks.Run)
+	// Spin up detection of stuck sagas.
+	app.Add(detector.Run)
+	// Spin up archiving of finished sagas, if retention periods are set.
+	if len(policies) > 0 {
+		app.Add(archiver.Run)
+	}
+	// Spin up batch senders of the workflow.
+	for _, batch := range batches {
+		app.Add(batch.Run)
+	}
 	// Defer HTTP server shutdown on the server exit.
 	app.Add(func(ctx context.Context) error {
 		<-ctx.Done()
@@ -179,6 +467,15 @@ func initialize(log *zap.SugaredLogger) (*app.App, error) {
 
 		return httpServer.Shutdown(ctxWithTimeout)
 	})
+	// Defer flushing of traces on the server exit.
+	app.Add(func(ctx context.Context) error {
+		<-ctx.Done()
+		ctxWithTimeout, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
+		defer cancel()
+		log.Infow("shutdown", "status", "stopping tracing support")
+
+		return stopTracing(ctxWithTimeout)
+	})
 	// Defer database connection closing on the server exit.
 	app.Add(func(ctx context.Context) error {
 		<-ctx.Done()
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/retention"
+	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/data/archive"
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// Set of destinations of archived sagas.
+const (
+	ArchiveTable = "table"
+	ArchiveFile  = "file"
+)
+
+// Archive moves finished sagas and their audit trail to the archive tables or exports them to a file.
+func Archive(log *zap.SugaredLogger, cfg database.Config, args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	to := fs.String("to", ArchiveTable, "destination of sagas: table or file")
+	path := fs.String("file", "sagas.jsonl.gz", "compressed JSON-lines file the sagas are appended to")
+	statuses := fs.String("status", strings.Join([]string{saga.StatusCompleted, saga.StatusCompensated, saga.StatusCancelled}, ","),
+		"comma separated terminal statuses of sagas")
+	workflow := fs.String("workflow", "", "workflow of sagas, all workflows if empty")
+	olderThan := fs.Duration("older-than", 0, "minimal time since the last update of sagas")
+	batchSize := fs.Int("batch-size", 100, "number of sagas archived in one transaction")
+	pause := fs.Duration("pause", 100*time.Millisecond, "pause between batches")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if *olderThan <= 0 || *batchSize <= 0 {
+		fmt.Println("archive --older-than d [--status s,s] [--workflow w] [--to table|file] [--file path] [--batch-size n] [--pause d]")
+		return ErrHelp
+	}
+
+	periods := make(map[string]time.Duration)
+	for _, status := range strings.Split(*statuses, ",") {
+		key := status
+		if *workflow != "" {
+			key = *workflow + "/" + status
+		}
+		periods[key] = *olderThan
+	}
+	policies, err := retention.ParsePolicies(periods)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer db.Close()
+	storage := database.NewStorage(db)
+
+	var store retention.Store
+	switch *to {
+	case ArchiveTable:
+		store = storage
+	case ArchiveFile:
+		file, err := archive.NewFile(*path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		store = exportStore{storage: storage, file: file}
+	default:
+		return fmt.Errorf("unknown destination %q", *to)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	archiver, err := retention.NewArchiver(store, retention.Config{Policies: policies, BatchSize: *batchSize, Pause: *pause}, log)
+	if err != nil {
+		return fmt.Errorf("create archiver: %w", err)
+	}
+	archived, err := archiver.Archive(ctx)
+	fmt.Printf("archived %d sagas\n", archived)
+	if err != nil {
+		return fmt.Errorf("archive sagas: %w", err)
+	}
+
+	return nil
+}
+
+// exportStore archives sagas by exporting them to a file.
+type exportStore struct {
+	storage database.Storage
+	file    *archive.File
+}
+
+func (s exportStore) ArchiveSagas(ctx context.Context, f database.ArchiveFilter, limit int) (int, error) {
+	return s.storage.ExportSagas(ctx, f, limit, s.file.Write)
+}
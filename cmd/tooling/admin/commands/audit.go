@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/illyasch/saga-service/pkg/data/audit"
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// Audit prints the audit trail of the saga as JSON lines. The trail is read from the database,
+// or from the file written by the file audit sink if the file is given.
+func Audit(cfg database.Config, id string, file string) error {
+	if id == "" {
+		fmt.Println("help: audit <saga_id> [file]")
+		return ErrHelp
+	}
+
+	sagaID, err := uuid.Parse(id)
+	if err != nil {
+		return fmt.Errorf("parse saga id: %w", err)
+	}
+
+	var events []audit.Event
+	if file != "" {
+		if events, err = audit.ReadFile(file, sagaID); err != nil {
+			return fmt.Errorf("read audit file: %w", err)
+		}
+	} else {
+		db, err := database.Open(cfg)
+		if err != nil {
+			return fmt.Errorf("connect database: %w", err)
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if events, err = audit.NewPostgresSink(db).Trail(ctx, sagaID); err != nil {
+			return fmt.Errorf("read audit trail: %w", err)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("json marshal: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -3,9 +3,13 @@ package commands
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"time"
 
+	"github.com/ardanlabs/darwin"
+
 	"github.com/illyasch/saga-service/pkg/data/database"
 	"github.com/illyasch/saga-service/pkg/data/database/dbschema"
 )
@@ -13,8 +17,25 @@ import (
 // ErrHelp provides context that help was given.
 var ErrHelp = errors.New("provided help")
 
-// Migrate creates the schema in the database.
-func Migrate(cfg database.Config) error {
+// Migrate creates the schema in the database, reports the state of migrations or reverts them.
+func Migrate(cfg database.Config, args []string) error {
+	command := "up"
+	if len(args) > 0 && (args[0] == "status" || args[0] == "down") {
+		command, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("migrate "+command, flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print the SQL of the migrations without applying them")
+	format := fs.String("format", FormatTable, "output format of status: table or json")
+	to := fs.Float64("to", -1, "version to revert the schema to, 0 reverts all migrations")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if command == "down" && *to < 0 {
+		fmt.Println("migrate down --to version [--dry-run]")
+		return ErrHelp
+	}
+
 	db, err := database.Open(cfg)
 	if err != nil {
 		return fmt.Errorf("connect database: %w", err)
@@ -24,10 +45,60 @@ func Migrate(cfg database.Config) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := dbschema.Migrate(ctx, db); err != nil {
-		return fmt.Errorf("migrate database: %w", err)
+	switch {
+	case command == "status":
+		statuses, err := dbschema.Status(ctx, db)
+		if err != nil {
+			return fmt.Errorf("migration status: %w", err)
+		}
+		return output(*format, statuses, func(w io.Writer) {
+			fmt.Fprintln(w, "VERSION\tDESCRIPTION\tSTATE\tAPPLIED")
+			for _, s := range statuses {
+				applied := ""
+				if s.AppliedAt != nil {
+					applied = s.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%g\t%s\t%s\t%s\n", s.Version, s.Description, s.State, applied)
+			}
+		})
+
+	case command == "down" && *dryRun:
+		rollbacks, err := dbschema.Rollbacks(ctx, db, *to)
+		if err != nil {
+			return fmt.Errorf("plan down migrations: %w", err)
+		}
+		printMigrations(rollbacks)
+
+	case command == "down":
+		rollbacks, err := dbschema.Down(ctx, db, *to)
+		if err != nil {
+			return fmt.Errorf("revert migrations: %w", err)
+		}
+		fmt.Printf("reverted %d migrations\n", len(rollbacks))
+
+	case *dryRun:
+		pending, err := dbschema.Pending(ctx, db)
+		if err != nil {
+			return fmt.Errorf("plan migrations: %w", err)
+		}
+		printMigrations(pending)
+
+	default:
+		if err := dbschema.Migrate(ctx, db); err != nil {
+			return fmt.Errorf("migrate database: %w", err)
+		}
+		fmt.Println("migrations complete")
 	}
 
-	fmt.Println("migrations complete")
 	return nil
 }
+
+// printMigrations prints the migrations in the format of the schema file.
+func printMigrations(migrations []darwin.Migration) {
+	if len(migrations) == 0 {
+		fmt.Println("-- No migrations to apply")
+	}
+	for _, m := range migrations {
+		fmt.Printf("-- Version: %g\n-- Description: %s\n%s\n", m.Version, m.Description, m.Script)
+	}
+}
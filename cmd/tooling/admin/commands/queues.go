@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+// Queues creates the queues of the workflow or reports how they differ from it.
+func Queues(qcfg QueueConfig, args []string) error {
+	if len(args) == 0 || (args[0] != "provision" && args[0] != "verify") {
+		fmt.Println("queues provision|verify [--responses q] [--dead-letter q] [--visibility-timeout d] [--retention d] [--max-receive n]")
+		return ErrHelp
+	}
+
+	fs := flag.NewFlagSet("queues "+args[0], flag.ContinueOnError)
+	var cfg saga.QueueConfig
+	fs.StringVar(&cfg.Responses, "responses", saga.QueueName, "queue of responses of the services")
+	fs.StringVar(&cfg.DeadLetter, "dead-letter", "", "dead-letter queue of responses, <responses>-dlq by default")
+	fs.DurationVar(&cfg.VisibilityTimeout, "visibility-timeout", 30*time.Second, "visibility timeout of queues")
+	fs.DurationVar(&cfg.RetentionPeriod, "retention", 4*24*time.Hour, "message retention period of queues")
+	fs.IntVar(&cfg.MaxReceiveCount, "max-receive", 5, "receives of a message before it's moved to the dead-letter queue")
+	format := fs.String("format", FormatTable, "output format of verify: table or json")
+	if _, err := parseFlags(fs, args[1:], 0); err != nil {
+		return err
+	}
+
+	workflow := saga.Workflow{Name: saga.SampleWorkflowName, Services: saga.SampleWorkflow}
+	specs := saga.QueueSpecs([]saga.Workflow{workflow}, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if args[0] == "provision" {
+		if err := queue.Provision(ctx, qcfg.sqs(), specs); err != nil {
+			return fmt.Errorf("provision queues: %w", err)
+		}
+
+		fmt.Printf("provisioned %d queues\n", len(specs))
+		return nil
+	}
+
+	drifts, err := queue.Verify(ctx, qcfg.sqs(), specs)
+	if err != nil {
+		return fmt.Errorf("verify queues: %w", err)
+	}
+	err = output(*format, drifts, func(w io.Writer) {
+		fmt.Fprintln(w, "QUEUE\tATTRIBUTE\tWANT\tGOT")
+		for _, d := range drifts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Queue, d.Attribute, d.Want, d.Got)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(drifts) > 0 {
+		return fmt.Errorf("%d queue attributes differ from the workflow", len(drifts))
+	}
+
+	return nil
+}
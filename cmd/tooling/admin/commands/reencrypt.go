@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/illyasch/saga-service/pkg/data/database"
+	"github.com/illyasch/saga-service/pkg/sys/envelope"
+)
+
+// reencryptBatchSize is the number of values re-encrypted in one transaction.
+const reencryptBatchSize = 100
+
+// Reencrypt seals saga payloads and webhook secrets again with the current key of the keyring.
+// It is used after a new key has been added to the keyring and made current, or to seal payloads
+// and secrets stored before the encryption was enabled.
+func Reencrypt(cfg database.Config, keyringFile string) error {
+	if keyringFile == "" {
+		return fmt.Errorf("keyring file is not configured")
+	}
+
+	keyring, err := envelope.LoadFileKeyring(keyringFile)
+	if err != nil {
+		return fmt.Errorf("load keyring: %w", err)
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	storage := database.NewStorage(db, database.WithEncryption(envelope.New(keyring)))
+	n, err := storage.Reencrypt(ctx, reencryptBatchSize)
+	if err != nil {
+		return fmt.Errorf("reencrypt: %w", err)
+	}
+
+	fmt.Printf("re-encrypted %d saga payloads and webhook secrets\n", n)
+	return nil
+}
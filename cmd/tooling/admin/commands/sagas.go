@@ -0,0 +1,363 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/business/webhook"
+	"github.com/illyasch/saga-service/pkg/data/audit"
+	"github.com/illyasch/saga-service/pkg/data/database"
+	"github.com/illyasch/saga-service/pkg/data/events"
+	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/sys/envelope"
+)
+
+// Set of output formats of the sagas commands.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// QueueConfig is the connection to the queues of the workflow.
+type QueueConfig struct {
+	AWSEndpoint  string
+	AWSRegion    string
+	SigningKeyID string
+	SigningKeys  map[string]string
+	KeyringFile  string
+}
+
+// sqs returns the SQS client of the configuration.
+func (cfg QueueConfig) sqs() *sqs.SQS {
+	awsConfig := aws.NewConfig().WithRegion(cfg.AWSRegion)
+	if cfg.AWSEndpoint != "" {
+		awsConfig.WithEndpoint(cfg.AWSEndpoint)
+	}
+
+	return sqs.New(session.Must(session.NewSession()), awsConfig)
+}
+
+// senderOptions returns the signing and encryption of commands sent to the services.
+func (cfg QueueConfig) senderOptions() ([]queue.SenderOption, error) {
+	var opts []queue.SenderOption
+	if len(cfg.SigningKeys) > 0 {
+		keyring, err := queue.NewKeyring(cfg.SigningKeyID, cfg.SigningKeys)
+		if err != nil {
+			return nil, fmt.Errorf("create signing keyring: %w", err)
+		}
+		opts = append(opts, queue.WithSigning(keyring))
+	}
+	if cfg.KeyringFile != "" {
+		keyring, err := envelope.LoadFileKeyring(cfg.KeyringFile)
+		if err != nil {
+			return nil, fmt.Errorf("load keyring: %w", err)
+		}
+		opts = append(opts, queue.WithEncryption(envelope.New(keyring)))
+	}
+
+	return opts, nil
+}
+
+// Sagas inspects and manages sagas in the database.
+func Sagas(log *zap.SugaredLogger, cfg database.Config, qcfg QueueConfig, args []string) error {
+	if len(args) == 0 {
+		return sagasHelp()
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer db.Close()
+
+	var storageOpts []database.StorageOption
+	if qcfg.KeyringFile != "" {
+		keyring, err := envelope.LoadFileKeyring(qcfg.KeyringFile)
+		if err != nil {
+			return fmt.Errorf("load keyring: %w", err)
+		}
+		storageOpts = append(storageOpts, database.WithEncryption(envelope.New(keyring)))
+	}
+	storage := database.NewStorage(db, storageOpts...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "list":
+		return sagasList(ctx, storage, args[1:])
+	case "show":
+		return sagasShow(ctx, db, storage, args[1:])
+	case "cancel":
+		return sagasCancel(ctx, log, db, storage, args[1:])
+	case "retry":
+		return sagasRetry(ctx, log, db, storage, qcfg, args[1:])
+	case "purge":
+		return sagasPurge(ctx, storage, args[1:])
+	default:
+		return sagasHelp()
+	}
+}
+
+func sagasHelp() error {
+	fmt.Println("sagas list [--status s] [--service s] [--older-than d] [--limit n] [--format table|json]")
+	fmt.Println("sagas show <saga_id> [--format table|json]")
+	fmt.Println("sagas cancel <saga_id>")
+	fmt.Println("sagas retry <saga_id> [--from-step service]")
+	fmt.Println("sagas purge --older-than d [--status s,s]")
+	return ErrHelp
+}
+
+func sagasList(ctx context.Context, storage database.Storage, args []string) error {
+	fs := flag.NewFlagSet("sagas list", flag.ContinueOnError)
+	var f database.SagaFilter
+	fs.StringVar(&f.Status, "status", "", "status of sagas")
+	fs.StringVar(&f.Service, "service", "", "current step of sagas")
+	fs.DurationVar(&f.OlderThan, "older-than", 0, "minimal age of sagas")
+	fs.IntVar(&f.Limit, "limit", 100, "maximal number of sagas")
+	format := fs.String("format", FormatTable, "output format: table or json")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	sagas, err := storage.ListSagas(ctx, f)
+	if err != nil {
+		return fmt.Errorf("list sagas: %w", err)
+	}
+	summaries := make([]sagaSummary, 0, len(sagas))
+	for _, s := range sagas {
+		summaries = append(summaries, sagaSummary{
+			ID:          s.ID,
+			Status:      s.Status,
+			Step:        s.Service,
+			DateCreated: s.DateCreated,
+			DateUpdated: s.DateUpdated,
+		})
+	}
+
+	return output(*format, summaries, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tSTATUS\tSTEP\tCREATED\tUPDATED")
+		for _, s := range summaries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.Status, s.Step,
+				s.DateCreated.Format(time.RFC3339), s.DateUpdated.Format(time.RFC3339))
+		}
+	})
+}
+
+// sagaSummary is an item of the output of the list command.
+type sagaSummary struct {
+	ID          uuid.UUID `json:"id"`
+	Status      string    `json:"status"`
+	Step        string    `json:"step"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+}
+
+// sagaDetails is the output of the show command.
+type sagaDetails struct {
+	ID          uuid.UUID       `json:"id"`
+	Status      string          `json:"status"`
+	Step        string          `json:"step"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	DateCreated time.Time       `json:"date_created"`
+	DateUpdated time.Time       `json:"date_updated"`
+	History     []audit.Event   `json:"history"`
+}
+
+func sagasShow(ctx context.Context, db *sqlx.DB, storage database.Storage, args []string) error {
+	fs := flag.NewFlagSet("sagas show", flag.ContinueOnError)
+	format := fs.String("format", FormatTable, "output format: table or json")
+	sagaID, err := parseSagaID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	state, err := storage.GetSaga(ctx, sagaID)
+	if err != nil {
+		return fmt.Errorf("get saga: %w", err)
+	}
+	history, err := audit.NewPostgresSink(db).Trail(ctx, sagaID)
+	if err != nil {
+		return fmt.Errorf("read audit trail: %w", err)
+	}
+
+	details := sagaDetails{
+		ID:          state.ID,
+		Status:      state.Status,
+		Step:        state.Service,
+		Payload:     state.Payload,
+		DateCreated: state.DateCreated,
+		DateUpdated: state.DateUpdated,
+		History:     history,
+	}
+
+	return output(*format, details, func(w io.Writer) {
+		fmt.Fprintf(w, "ID\t%s\n", details.ID)
+		fmt.Fprintf(w, "STATUS\t%s\n", details.Status)
+		fmt.Fprintf(w, "STEP\t%s\n", details.Step)
+		fmt.Fprintf(w, "PAYLOAD\t%s\n", details.Payload)
+		fmt.Fprintf(w, "CREATED\t%s\n", details.DateCreated.Format(time.RFC3339))
+		fmt.Fprintf(w, "UPDATED\t%s\n", details.DateUpdated.Format(time.RFC3339))
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "TIME\tSTEP\tFROM\tTO\tTRIGGERED BY")
+		for _, e := range details.History {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Step, e.FromStatus, e.ToStatus, e.TriggeredBy)
+		}
+	})
+}
+
+func sagasCancel(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, storage database.Storage, args []string) error {
+	sagaID, err := parseSagaID(flag.NewFlagSet("sagas cancel", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+
+	// Cancelling doesn't send commands, so the services don't need queues.
+	workflow := saga.Workflow{Name: saga.SampleWorkflowName, Services: saga.SampleWorkflow}
+	if err := newSaga(log, db, storage, workflow).Cancel(ctx, sagaID); err != nil {
+		return fmt.Errorf("cancel saga: %w", err)
+	}
+
+	fmt.Printf("saga %s cancelled\n", sagaID)
+	return nil
+}
+
+func sagasRetry(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, storage database.Storage, qcfg QueueConfig, args []string) error {
+	fs := flag.NewFlagSet("sagas retry", flag.ContinueOnError)
+	step := fs.String("from-step", "", "service to start the saga from instead of the failed one")
+	sagaID, err := parseSagaID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	opts, err := qcfg.senderOptions()
+	if err != nil {
+		return err
+	}
+	workflow, err := saga.NewWorkflowWithSQS(saga.SampleWorkflowName, saga.SampleWorkflow, qcfg.sqs(), opts...)
+	if err != nil {
+		return fmt.Errorf("create saga workflow: %w", err)
+	}
+	if err := newSaga(log, db, storage, workflow).RetryFrom(ctx, sagaID, *step); err != nil {
+		return fmt.Errorf("retry saga: %w", err)
+	}
+
+	fmt.Printf("saga %s retried\n", sagaID)
+	return nil
+}
+
+func sagasPurge(ctx context.Context, storage database.Storage, args []string) error {
+	fs := flag.NewFlagSet("sagas purge", flag.ContinueOnError)
+	olderThan := fs.Duration("older-than", 0, "minimal time since the last update of sagas")
+	statuses := fs.String("status", strings.Join([]string{saga.StatusCompleted, saga.StatusCompensated, saga.StatusCancelled}, ","),
+		"comma separated statuses of sagas")
+	batchSize := fs.Int("batch-size", 500, "number of sagas deleted by one statement")
+	if _, err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if *olderThan <= 0 || *batchSize <= 0 {
+		fmt.Println("help: sagas purge --older-than d [--status s,s] [--batch-size n]")
+		return ErrHelp
+	}
+	for _, status := range strings.Split(*statuses, ",") {
+		if status == saga.StatusStarted {
+			return fmt.Errorf("running sagas can't be purged")
+		}
+	}
+
+	n, err := storage.PurgeSagas(ctx, strings.Split(*statuses, ","), *olderThan, *batchSize)
+	if err != nil {
+		return fmt.Errorf("purge sagas: %w", err)
+	}
+
+	fmt.Printf("purged %d sagas\n", n)
+	return nil
+}
+
+// newSaga constructs the saga business logic recording changes like the service does.
+func newSaga(log *zap.SugaredLogger, db *sqlx.DB, storage database.Storage, workflow saga.Workflow) saga.Saga {
+	return saga.New(workflow, storage,
+		saga.WithAudit(audit.NewPostgresSink(db)),
+		saga.WithNotifier(webhook.NewDispatcher(storage, webhook.Config{}, log)),
+		saga.WithPublisher(events.NewPostgresPublisher(db, log)),
+		saga.WithLogger(log),
+	)
+}
+
+// parseFlags parses flags which may come before and after positional arguments and returns
+// the positional arguments. It returns ErrHelp if the number of the arguments is not n.
+func parseFlags(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, ErrHelp
+			}
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != n {
+		fs.Usage()
+		return nil, ErrHelp
+	}
+
+	return positional, nil
+}
+
+// parseSagaID parses the flags and the saga ID argument.
+func parseSagaID(fs *flag.FlagSet, args []string) (uuid.UUID, error) {
+	positional, err := parseFlags(fs, args, 1)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	sagaID, err := uuid.Parse(positional[0])
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("parse saga id: %w", err)
+	}
+
+	return sagaID, nil
+}
+
+// output prints the data as JSON or as a table written by the function.
+func output(format string, data any, table func(io.Writer)) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(data); err != nil {
+			return fmt.Errorf("json marshal: %w", err)
+		}
+	case FormatTable:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		table(w)
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("write output: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	return nil
+}
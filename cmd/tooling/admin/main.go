@@ -47,6 +47,17 @@ func run(log *zap.SugaredLogger) error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:true"`
 		}
+		Encryption struct {
+			KeyringFile string
+		}
+		Queue struct {
+			AWSEndpoint string `conf:"default:http://localhost:4566"`
+			AWSRegion   string `conf:"default:us-west-1a"`
+		}
+		Signing struct {
+			KeyID string
+			Keys  map[string]string `conf:"mask"`
+		}
 	}{
 		Version: conf.Version{
 			Build: build,
@@ -79,15 +90,23 @@ func run(log *zap.SugaredLogger) error {
 		DisableTLS: cfg.DB.DisableTLS,
 	}
 
-	return processCommands(cfg.Args, log, dbConfig)
+	queueConfig := commands.QueueConfig{
+		AWSEndpoint:  cfg.Queue.AWSEndpoint,
+		AWSRegion:    cfg.Queue.AWSRegion,
+		SigningKeyID: cfg.Signing.KeyID,
+		SigningKeys:  cfg.Signing.Keys,
+		KeyringFile:  cfg.Encryption.KeyringFile,
+	}
+
+	return processCommands(cfg.Args, log, dbConfig, queueConfig)
 }
 
 // processCommands handles the execution of the commands specified on
 // the command line.
-func processCommands(args conf.Args, log *zap.SugaredLogger, dbConfig database.Config) error {
+func processCommands(args conf.Args, log *zap.SugaredLogger, dbConfig database.Config, queueConfig commands.QueueConfig) error {
 	switch args.Num(0) {
 	case "migrate":
-		if err := commands.Migrate(dbConfig); err != nil {
+		if err := commands.Migrate(dbConfig, args[1:]); err != nil {
 			return fmt.Errorf("migrating database: %w", err)
 		}
 
@@ -96,9 +115,39 @@ func processCommands(args conf.Args, log *zap.SugaredLogger, dbConfig database.C
 			return fmt.Errorf("seeding database: %w", err)
 		}
 
+	case "reencrypt":
+		if err := commands.Reencrypt(dbConfig, queueConfig.KeyringFile); err != nil {
+			return fmt.Errorf("re-encrypting payloads: %w", err)
+		}
+
+	case "audit":
+		if err := commands.Audit(dbConfig, args.Num(1), args.Num(2)); err != nil {
+			return fmt.Errorf("exporting audit trail: %w", err)
+		}
+
+	case "sagas":
+		if err := commands.Sagas(log, dbConfig, queueConfig, args[1:]); err != nil {
+			return fmt.Errorf("managing sagas: %w", err)
+		}
+
+	case "archive":
+		if err := commands.Archive(log, dbConfig, args[1:]); err != nil {
+			return fmt.Errorf("archiving sagas: %w", err)
+		}
+
+	case "queues":
+		if err := commands.Queues(queueConfig, args[1:]); err != nil {
+			return fmt.Errorf("managing queues: %w", err)
+		}
+
 	default:
-		fmt.Println("migrate: create the schema in the database")
+		fmt.Println("migrate: create the schema in the database, report the state of migrations or revert them")
 		fmt.Println("seed: add data to the database")
+		fmt.Println("reencrypt: encrypt saga payloads and webhook secrets with the current key of the keyring")
+		fmt.Println("audit: export the audit trail of a saga from the database or an audit file")
+		fmt.Println("sagas: list, show, cancel, retry and purge sagas")
+		fmt.Println("archive: move finished sagas to archive tables or export them to a file")
+		fmt.Println("queues: create the queues of the workflow or report how they differ from it")
 		fmt.Println("provide a command to get more help.")
 		return commands.ErrHelp
 	}
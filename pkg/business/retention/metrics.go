@@ -0,0 +1,13 @@
+package retention
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// archivedSagas is the number of archived sagas per status.
+var archivedSagas = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "saga",
+	Name:      "archived_sagas_total",
+	Help:      "Number of finished sagas archived after their retention per status.",
+}, []string{"status"})
@@ -0,0 +1,157 @@
+// Package retention archives finished sagas after their retention period. Periods are set per terminal
+// status and can be overridden per workflow.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// Store interface abstracts archiving of finished sagas in batches.
+type Store interface {
+	ArchiveSagas(context.Context, database.ArchiveFilter, int) (int, error)
+}
+
+// Policy is the retention period of sagas of the workflow which finished in the status.
+// A policy without a workflow applies to the workflows without their own policy for the status.
+type Policy struct {
+	Workflow  string
+	Status    string
+	Retention time.Duration
+}
+
+// Config is the properties of the archiving. Sagas are archived in batches of BatchSize with Pause between them,
+// so that other transactions aren't blocked for long.
+type Config struct {
+	Interval  time.Duration
+	Policies  []Policy
+	BatchSize int
+	Pause     time.Duration
+}
+
+// ParsePolicies returns policies from retention periods keyed by a status or a workflow and a status
+// separated by a slash, e.g. "completed" or "sample/completed".
+func ParsePolicies(periods map[string]time.Duration) ([]Policy, error) {
+	policies := make([]Policy, 0, len(periods))
+	for key, period := range periods {
+		p := Policy{Status: key, Retention: period}
+		if i := strings.LastIndex(key, "/"); i >= 0 {
+			p.Workflow, p.Status = key[:i], key[i+1:]
+			if p.Workflow == "" {
+				return nil, fmt.Errorf("policy %q: empty workflow", key)
+			}
+		}
+
+		switch p.Status {
+		case saga.StatusCompleted, saga.StatusCompensated, saga.StatusCancelled:
+		default:
+			return nil, fmt.Errorf("policy %q: status %q isn't terminal", key, p.Status)
+		}
+		if period <= 0 {
+			return nil, fmt.Errorf("policy %q: retention must be positive", key)
+		}
+		policies = append(policies, p)
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Status != policies[j].Status {
+			return policies[i].Status < policies[j].Status
+		}
+		return policies[i].Workflow < policies[j].Workflow
+	})
+	return policies, nil
+}
+
+// Archiver periodically archives sagas past their retention.
+type Archiver struct {
+	store Store
+	cfg   Config
+	log   *zap.SugaredLogger
+}
+
+// NewArchiver constructs an Archiver of sagas in the store. The batch size must be positive.
+func NewArchiver(store Store, cfg Config, log *zap.SugaredLogger) (*Archiver, error) {
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive: %d", cfg.BatchSize)
+	}
+
+	return &Archiver{store: store, cfg: cfg, log: log}, nil
+}
+
+// Run archives sagas every interval until the context is cancelled.
+func (a *Archiver) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			archived, err := a.Archive(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				a.log.Errorw("retention", "ERROR", err)
+			}
+			if archived > 0 {
+				a.log.Infow("retention", "status", "sagas archived", "count", archived)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Archive archives all sagas past their retention and returns their number.
+func (a *Archiver) Archive(ctx context.Context) (int, error) {
+	var total int
+	for _, f := range filters(a.cfg.Policies) {
+		for {
+			archived, err := a.store.ArchiveSagas(ctx, f, a.cfg.BatchSize)
+			if err != nil {
+				return total, fmt.Errorf("archive %s sagas: %w", f.Status, err)
+			}
+			total += archived
+			archivedSagas.WithLabelValues(f.Status).Add(float64(archived))
+			if archived < a.cfg.BatchSize {
+				break
+			}
+
+			select {
+			case <-time.After(a.cfg.Pause):
+			case <-ctx.Done():
+				return total, ctx.Err()
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// filters returns the filters of sagas past retention of the policies. A policy without a workflow
+// excludes the workflows with their own policy for the status.
+func filters(policies []Policy) []database.ArchiveFilter {
+	own := make(map[string][]string)
+	for _, p := range policies {
+		if p.Workflow != "" {
+			own[p.Status] = append(own[p.Status], p.Workflow)
+		}
+	}
+
+	fs := make([]database.ArchiveFilter, 0, len(policies))
+	for _, p := range policies {
+		f := database.ArchiveFilter{Status: p.Status, Workflow: p.Workflow, OlderThan: p.Retention}
+		if p.Workflow == "" {
+			f.ExceptWorkflows = own[p.Status]
+		}
+		fs = append(fs, f)
+	}
+
+	return fs
+}
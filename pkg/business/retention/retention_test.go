@@ -0,0 +1,105 @@
+//go:generate mockgen -destination=mock_store_test.go -package=retention_test github.com/illyasch/saga-service/pkg/business/retention Store
+package retention_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/retention"
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := retention.ParsePolicies(map[string]time.Duration{
+		"completed":        720 * time.Hour,
+		"sample/completed": 24 * time.Hour,
+		"cancelled":        168 * time.Hour,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []retention.Policy{
+		{Status: "cancelled", Retention: 168 * time.Hour},
+		{Status: "completed", Retention: 720 * time.Hour},
+		{Workflow: "sample", Status: "completed", Retention: 24 * time.Hour},
+	}, policies)
+
+	for key, period := range map[string]time.Duration{
+		"started":    time.Hour,
+		"error":      time.Hour,
+		"/cancelled": time.Hour,
+		"completed":  0,
+	} {
+		_, err := retention.ParsePolicies(map[string]time.Duration{key: period})
+		assert.Error(t, err, key)
+	}
+}
+
+func TestArchiver_Archive(t *testing.T) {
+	cfg := retention.Config{
+		Interval: time.Minute,
+		Policies: []retention.Policy{
+			{Status: "completed", Retention: 720 * time.Hour},
+			{Workflow: "sample", Status: "completed", Retention: 24 * time.Hour},
+			{Status: "cancelled", Retention: 168 * time.Hour},
+		},
+		BatchSize: 2,
+		Pause:     time.Millisecond,
+	}
+
+	t.Run("batches until the last one isn't full", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := NewMockStore(ctrl)
+		gomock.InOrder(
+			store.EXPECT().ArchiveSagas(gomock.Any(), database.ArchiveFilter{
+				Status: "completed", ExceptWorkflows: []string{"sample"}, OlderThan: 720 * time.Hour,
+			}, 2).Return(2, nil),
+			store.EXPECT().ArchiveSagas(gomock.Any(), database.ArchiveFilter{
+				Status: "completed", ExceptWorkflows: []string{"sample"}, OlderThan: 720 * time.Hour,
+			}, 2).Return(1, nil),
+			store.EXPECT().ArchiveSagas(gomock.Any(), database.ArchiveFilter{
+				Status: "completed", Workflow: "sample", OlderThan: 24 * time.Hour,
+			}, 2).Return(0, nil),
+			store.EXPECT().ArchiveSagas(gomock.Any(), database.ArchiveFilter{
+				Status: "cancelled", OlderThan: 168 * time.Hour,
+			}, 2).Return(1, nil),
+		)
+
+		archiver, err := retention.NewArchiver(store, cfg, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		archived, err := archiver.Archive(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 4, archived)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := NewMockStore(ctrl)
+		store.EXPECT().ArchiveSagas(gomock.Any(), gomock.Any(), 2).Return(2, nil)
+		store.EXPECT().ArchiveSagas(gomock.Any(), gomock.Any(), 2).Return(0, errors.New("connection reset"))
+
+		archiver, err := retention.NewArchiver(store, cfg, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		archived, err := archiver.Archive(context.Background())
+		assert.ErrorContains(t, err, "connection reset")
+		assert.Equal(t, 2, archived)
+	})
+
+	t.Run("batch size isn't positive", func(t *testing.T) {
+		for _, size := range []int{0, -1} {
+			cfg := cfg
+			cfg.BatchSize = size
+			_, err := retention.NewArchiver(nil, cfg, zap.NewNop().Sugar())
+			assert.ErrorContains(t, err, "batch size must be positive")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/illyasch/saga-service/pkg/data/audit"
+	"github.com/illyasch/saga-service/pkg/data/database"
+	"github.com/illyasch/saga-service/pkg/sys/requestid"
+)
+
+// triggeredByAPI is the actor of state changes caused by calls of the API.
+const triggeredByAPI = "api"
+
+// AuditSink interface abstracts recording of saga state changes.
+type AuditSink interface {
+	Record(context.Context, audit.Event) error
+}
+
+// Publisher interface abstracts streaming of saga state changes. Streaming is best-effort,
+// so publishing doesn't fail state changes.
+type Publisher interface {
+	Publish(context.Context, audit.Event)
+}
+
+// transition describes a state change of a saga.
+type transition struct {
+	sagaID      uuid.UUID
+	step        string
+	from, to    string
+	triggeredBy string
+}
+
+// entry returns the outbox entry of the state change, which is stored with the change.
+func (s Saga) entry(ctx context.Context, t transition) database.OutboxEntry {
+	return database.OutboxEntry{
+		Event: audit.Event{
+			ID:          uuid.New(),
+			SagaID:      t.sagaID,
+			Workflow:    s.workflow.Name,
+			Step:        t.step,
+			FromStatus:  t.from,
+			ToStatus:    t.to,
+			TriggeredBy: t.triggeredBy,
+			RequestID:   requestid.FromContext(ctx),
+			Time:        time.Now().UTC(),
+		},
+	}
+}
+
+// deliver dispatches the committed state change and removes its outbox entry. The change is committed
+// already, so a failure is only logged and the entry is delivered again by the outbox relay.
+func (s Saga) deliver(ctx context.Context, e database.OutboxEntry) {
+	if err := s.dispatch(ctx, e.Event); err != nil {
+		s.log.Warnw("saga", "WARNING", fmt.Errorf("deliver state change of saga %s: %w", e.SagaID, err))
+		return
+	}
+
+	if err := s.storage.DeleteOutbox(ctx, e.ID); err != nil {
+		s.log.Errorw("saga", "ERROR", fmt.Errorf("delete outbox entry of saga %s: %w", e.SagaID, err))
+	}
+}
+
+// dispatch notifies the caller about the terminal status and sends the state change to the audit sink and
+// to the publisher, if any. Notification is idempotent, so it goes first and a failed audit doesn't repeat it.
+func (s Saga) dispatch(ctx context.Context, e audit.Event) error {
+	if s.notifier != nil && e.ToStatus != StatusStarted {
+		if err := s.notifier.Notify(ctx, e.SagaID, e.ToStatus); err != nil {
+			return fmt.Errorf("notify: %w", err)
+		}
+	}
+	if s.auditSink != nil {
+		if err := s.auditSink.Record(ctx, e); err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+	}
+	if s.publisher != nil {
+		s.publisher.Publish(ctx, e)
+	}
+
+	return nil
+}
+
+// OutboxConfig configures the relay of state changes which weren't delivered after they were committed.
+type OutboxConfig struct {
+	Interval  time.Duration
+	BatchSize int
+	// Lease is the time a claimed entry is hidden from other instances while it's delivered.
+	Lease time.Duration
+}
+
+// RunOutbox delivers the undelivered state changes every interval until the context is cancelled.
+func (s Saga) RunOutbox(ctx context.Context, cfg OutboxConfig) error {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.relay(ctx, cfg)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// relay delivers a batch of claimed outbox entries in the order of the changes.
+func (s Saga) relay(ctx context.Context, cfg OutboxConfig) {
+	entries, err := s.storage.ClaimOutbox(ctx, cfg.BatchSize, cfg.Lease)
+	if err != nil {
+		s.log.Errorw("saga", "ERROR", fmt.Errorf("claim outbox: %w", err))
+		return
+	}
+
+	for _, e := range entries {
+		if err := s.dispatch(ctx, e.Event); err != nil {
+			s.log.Warnw("saga", "WARNING", fmt.Errorf("deliver state change of saga %s: %w", e.SagaID, err), "attempt", e.Attempts)
+			continue
+		}
+		if err := s.storage.DeleteOutbox(ctx, e.ID); err != nil {
+			s.log.Errorw("saga", "ERROR", fmt.Errorf("delete outbox entry of saga %s: %w", e.SagaID, err))
+		}
+	}
+}
+
+// triggeredByService returns the actor of state changes caused by responses of the service.
+func triggeredByService(service string) string {
+	return "service:" + service
+}
@@ -0,0 +1,55 @@
+package saga
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of sagas labelled by the workflow name.
+var (
+	sagasStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Name:      "sagas_started_total",
+		Help:      "Number of started sagas.",
+	}, []string{"workflow"})
+	sagasCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Name:      "sagas_completed_total",
+		Help:      "Number of completed sagas.",
+	}, []string{"workflow"})
+	sagasFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Name:      "sagas_failed_total",
+		Help:      "Number of sagas finished with an error.",
+	}, []string{"workflow"})
+	sagasCancelled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Name:      "sagas_cancelled_total",
+		Help:      "Number of cancelled sagas.",
+	}, []string{"workflow"})
+	// invalidResponses counts responses rejected because of an unknown status or an invalid payload.
+	invalidResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Name:      "invalid_responses_total",
+		Help:      "Number of responses rejected because of an unknown status or an invalid payload.",
+	}, []string{"workflow"})
+	// transitionConflicts counts transitions applied again because the saga was changed concurrently.
+	transitionConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Name:      "transition_conflicts_total",
+		Help:      "Number of saga transitions retried after a concurrent change of the saga.",
+	}, []string{"workflow"})
+	// illegalTransitions counts rejected transitions of sagas to statuses they can't move to.
+	illegalTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Name:      "illegal_transitions_total",
+		Help:      "Number of rejected transitions of sagas to statuses they can't move to.",
+	}, []string{"workflow"})
+	// stepDuration measures time from sending a command to a service until its successful response.
+	stepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "saga",
+		Name:      "step_duration_seconds",
+		Help:      "Latency of saga steps per service.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"service"})
+)
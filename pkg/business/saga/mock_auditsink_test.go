@@ -0,0 +1,50 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/illyasch/saga-service/pkg/business/saga (interfaces: AuditSink)
+
+// Package saga_test is a generated GoMock package.
+package saga_test
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	audit "github.com/illyasch/saga-service/pkg/data/audit"
+)
+
+// MockAuditSink is a mock of AuditSink interface.
+type MockAuditSink struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuditSinkMockRecorder
+}
+
+// MockAuditSinkMockRecorder is the mock recorder for MockAuditSink.
+type MockAuditSinkMockRecorder struct {
+	mock *MockAuditSink
+}
+
+// NewMockAuditSink creates a new mock instance.
+func NewMockAuditSink(ctrl *gomock.Controller) *MockAuditSink {
+	mock := &MockAuditSink{ctrl: ctrl}
+	mock.recorder = &MockAuditSinkMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuditSink) EXPECT() *MockAuditSinkMockRecorder {
+	return m.recorder
+}
+
+// Record mocks base method.
+func (m *MockAuditSink) Record(arg0 context.Context, arg1 audit.Event) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Record", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Record indicates an expected call of Record.
+func (mr *MockAuditSinkMockRecorder) Record(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Record", reflect.TypeOf((*MockAuditSink)(nil).Record), arg0, arg1)
+}
@@ -5,43 +5,45 @@
 package saga_test
 
 import (
-	gomock "github.com/golang/mock/gomock"
+	context "context"
 	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
 )
 
-// MockSender is a mock of Sender interface
+// MockSender is a mock of Sender interface.
 type MockSender struct {
 	ctrl     *gomock.Controller
 	recorder *MockSenderMockRecorder
 }
 
-// MockSenderMockRecorder is the mock recorder for MockSender
+// MockSenderMockRecorder is the mock recorder for MockSender.
 type MockSenderMockRecorder struct {
 	mock *MockSender
 }
 
-// NewMockSender creates a new mock instance
+// NewMockSender creates a new mock instance.
 func NewMockSender(ctrl *gomock.Controller) *MockSender {
 	mock := &MockSender{ctrl: ctrl}
 	mock.recorder = &MockSenderMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockSender) EXPECT() *MockSenderMockRecorder {
 	return m.recorder
 }
 
-// Send mocks base method
-func (m *MockSender) Send(arg0 interface{}) error {
+// Send mocks base method.
+func (m *MockSender) Send(arg0 context.Context, arg1 interface{}) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Send", arg0)
+	ret := m.ctrl.Call(m, "Send", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// Send indicates an expected call of Send
-func (mr *MockSenderMockRecorder) Send(arg0 interface{}) *gomock.Call {
+// Send indicates an expected call of Send.
+func (mr *MockSenderMockRecorder) Send(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockSender)(nil).Send), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockSender)(nil).Send), arg0, arg1)
 }
@@ -6,72 +6,134 @@ package saga_test
 
 import (
 	context "context"
+	json "encoding/json"
+	reflect "reflect"
+	time "time"
+
 	gomock "github.com/golang/mock/gomock"
 	uuid "github.com/google/uuid"
-	reflect "reflect"
+	database "github.com/illyasch/saga-service/pkg/data/database"
 )
 
-// MockStorer is a mock of Storer interface
+// MockStorer is a mock of Storer interface.
 type MockStorer struct {
 	ctrl     *gomock.Controller
 	recorder *MockStorerMockRecorder
 }
 
-// MockStorerMockRecorder is the mock recorder for MockStorer
+// MockStorerMockRecorder is the mock recorder for MockStorer.
 type MockStorerMockRecorder struct {
 	mock *MockStorer
 }
 
-// NewMockStorer creates a new mock instance
+// NewMockStorer creates a new mock instance.
 func NewMockStorer(ctrl *gomock.Controller) *MockStorer {
 	mock := &MockStorer{ctrl: ctrl}
 	mock.recorder = &MockStorerMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockStorer) EXPECT() *MockStorerMockRecorder {
 	return m.recorder
 }
 
-// InsertSaga mocks base method
-func (m *MockStorer) InsertSaga(arg0 context.Context, arg1 uuid.UUID, arg2, arg3 string) error {
+// ClaimOutbox mocks base method.
+func (m *MockStorer) ClaimOutbox(arg0 context.Context, arg1 int, arg2 time.Duration) ([]database.OutboxEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimOutbox", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]database.OutboxEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimOutbox indicates an expected call of ClaimOutbox.
+func (mr *MockStorerMockRecorder) ClaimOutbox(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOutbox", reflect.TypeOf((*MockStorer)(nil).ClaimOutbox), arg0, arg1, arg2)
+}
+
+// DeleteOutbox mocks base method.
+func (m *MockStorer) DeleteOutbox(arg0 context.Context, arg1 uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteOutbox", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteOutbox indicates an expected call of DeleteOutbox.
+func (mr *MockStorerMockRecorder) DeleteOutbox(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOutbox", reflect.TypeOf((*MockStorer)(nil).DeleteOutbox), arg0, arg1)
+}
+
+// GetSaga mocks base method.
+func (m *MockStorer) GetSaga(arg0 context.Context, arg1 uuid.UUID) (database.Saga, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSaga", arg0, arg1)
+	ret0, _ := ret[0].(database.Saga)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSaga indicates an expected call of GetSaga.
+func (mr *MockStorerMockRecorder) GetSaga(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSaga", reflect.TypeOf((*MockStorer)(nil).GetSaga), arg0, arg1)
+}
+
+// InsertSaga mocks base method.
+func (m *MockStorer) InsertSaga(arg0 context.Context, arg1 uuid.UUID, arg2, arg3, arg4 string, arg5 json.RawMessage, arg6 *database.Webhook, arg7 int, arg8 *database.OutboxEntry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InsertSaga", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InsertSaga indicates an expected call of InsertSaga.
+func (mr *MockStorerMockRecorder) InsertSaga(arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertSaga", reflect.TypeOf((*MockStorer)(nil).InsertSaga), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
+}
+
+// RetrySaga mocks base method.
+func (m *MockStorer) RetrySaga(arg0 context.Context, arg1 uuid.UUID, arg2 int, arg3 string, arg4 *database.OutboxEntry) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "InsertSaga", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "RetrySaga", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// InsertSaga indicates an expected call of InsertSaga
-func (mr *MockStorerMockRecorder) InsertSaga(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+// RetrySaga indicates an expected call of RetrySaga.
+func (mr *MockStorerMockRecorder) RetrySaga(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertSaga", reflect.TypeOf((*MockStorer)(nil).InsertSaga), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetrySaga", reflect.TypeOf((*MockStorer)(nil).RetrySaga), arg0, arg1, arg2, arg3, arg4)
 }
 
-// UpdateService mocks base method
-func (m *MockStorer) UpdateService(arg0 context.Context, arg1 uuid.UUID, arg2, arg3 string) error {
+// UpdateService mocks base method.
+func (m *MockStorer) UpdateService(arg0 context.Context, arg1 uuid.UUID, arg2 int, arg3 string, arg4 *database.OutboxEntry) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateService", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "UpdateService", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateService indicates an expected call of UpdateService
-func (mr *MockStorerMockRecorder) UpdateService(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+// UpdateService indicates an expected call of UpdateService.
+func (mr *MockStorerMockRecorder) UpdateService(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateService", reflect.TypeOf((*MockStorer)(nil).UpdateService), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateService", reflect.TypeOf((*MockStorer)(nil).UpdateService), arg0, arg1, arg2, arg3, arg4)
 }
 
-// UpdateStatus mocks base method
-func (m *MockStorer) UpdateStatus(arg0 context.Context, arg1 uuid.UUID, arg2 string) error {
+// UpdateStatus mocks base method.
+func (m *MockStorer) UpdateStatus(arg0 context.Context, arg1 uuid.UUID, arg2 int, arg3 string, arg4 *database.OutboxEntry) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateStatus", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "UpdateStatus", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateStatus indicates an expected call of UpdateStatus
-func (mr *MockStorerMockRecorder) UpdateStatus(arg0, arg1, arg2 interface{}) *gomock.Call {
+// UpdateStatus indicates an expected call of UpdateStatus.
+func (mr *MockStorerMockRecorder) UpdateStatus(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateStatus", reflect.TypeOf((*MockStorer)(nil).UpdateStatus), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateStatus", reflect.TypeOf((*MockStorer)(nil).UpdateStatus), arg0, arg1, arg2, arg3, arg4)
 }
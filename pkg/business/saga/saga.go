@@ -3,151 +3,441 @@ package saga
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.uber.org/zap"
 
+	"github.com/illyasch/saga-service/pkg/data/database"
 	"github.com/illyasch/saga-service/pkg/data/queue"
 )
 
 const (
-	CommandStart    = "start"
-	QueueName       = "responses"
-	StatusStarted   = "started"
-	StatusError     = "error"
-	StatusWorkDone  = "done"
-	StatusCompleted = "completed"
+	CommandStart = "start"
+	// CommandCompensate asks a service to undo the work done for a saga.
+	CommandCompensate = "compensate"
+	QueueName         = "responses"
+	StatusStarted     = "started"
+	StatusError       = "error"
+	StatusWorkDone    = "done"
+	StatusCompleted   = "completed"
+
+	// StatusCompensated and StatusCancelled are terminal statuses of sagas which were rolled back or cancelled.
+	StatusCompensated = "compensated"
+	StatusCancelled   = "cancelled"
 )
 
 // Storer interface abstracts data access operations for persisting a saga.
 type Storer interface {
-	InsertSaga(context.Context, uuid.UUID, string, string) error
-	UpdateStatus(context.Context, uuid.UUID, string) error
-	UpdateService(context.Context, uuid.UUID, string, string) error
+	InsertSaga(context.Context, uuid.UUID, string, string, string, json.RawMessage, *database.Webhook, int, *database.OutboxEntry) error
+	UpdateStatus(context.Context, uuid.UUID, int, string, *database.OutboxEntry) error
+	UpdateService(context.Context, uuid.UUID, int, string, *database.OutboxEntry) error
+	RetrySaga(context.Context, uuid.UUID, int, string, *database.OutboxEntry) error
+	ClaimOutbox(context.Context, int, time.Duration) ([]database.OutboxEntry, error)
+	DeleteOutbox(context.Context, uuid.UUID) error
+	GetSaga(context.Context, uuid.UUID) (database.Saga, error)
 }
 
+// transitionAttempts is the number of attempts of a transition of a saga which is changed concurrently.
+const transitionAttempts = 3
+
 // Sender interface abstracts sending a message to queue.
 type Sender interface {
-	Send(any) error
+	Send(context.Context, any) error
+}
+
+// Notifier interface abstracts notifying callers about terminal statuses of sagas.
+type Notifier interface {
+	Notify(context.Context, uuid.UUID, string) error
 }
 
 // Service type has data for a service which is orchestrated by a saga.
+// Schema is optional and describes the payload of a successful response from the service.
 type Service struct {
 	Name   string
 	Topic  string
 	Sender Sender
+	Schema *jsonschema.Schema
 }
 
 // Saga contains the database for storing URLs.
 type Saga struct {
-	storage  Storer
-	workflow Workflow
+	storage    Storer
+	workflow   Workflow
+	auditSink  AuditSink
+	notifier   Notifier
+	publisher  Publisher
+	maxRunning int
+	log        *zap.SugaredLogger
 }
 
 var (
 	ErrEndOfWorkflow   = fmt.Errorf("end of workflow")
 	ErrServiceNotFound = fmt.Errorf("service not found")
+	ErrTooManyRunning  = fmt.Errorf("too many running sagas")
+	ErrWrongStatus     = fmt.Errorf("wrong saga status")
 )
 
+// Option configures optional behaviour of a Saga.
+type Option func(*Saga)
+
+// WithAudit makes the Saga record every state change to the sink.
+func WithAudit(sink AuditSink) Option {
+	return func(s *Saga) {
+		s.auditSink = sink
+	}
+}
+
+// WithNotifier makes the Saga notify callers when sagas reach terminal statuses.
+func WithNotifier(n Notifier) Option {
+	return func(s *Saga) {
+		s.notifier = n
+	}
+}
+
+// WithPublisher makes the Saga publish every state change.
+func WithPublisher(p Publisher) Option {
+	return func(s *Saga) {
+		s.publisher = p
+	}
+}
+
+// WithMaxRunning makes the Saga refuse to start a saga while the given number of sagas of its workflow are running.
+// The storage counts the sagas while storing the new one, so concurrent starts can't exceed the cap.
+func WithMaxRunning(n int) Option {
+	return func(s *Saga) {
+		s.maxRunning = n
+	}
+}
+
+// WithLogger makes the Saga log rejected transitions to the logger.
+func WithLogger(log *zap.SugaredLogger) Option {
+	return func(s *Saga) {
+		s.log = log
+	}
+}
+
 // New constructs a new Saga.
-func New(workflow Workflow, storage Storer) Saga {
-	return Saga{storage: storage, workflow: workflow}
+func New(workflow Workflow, storage Storer, opts ...Option) Saga {
+	s := Saga{storage: storage, workflow: workflow, log: zap.NewNop().Sugar()}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return s
 }
 
 // Start starts a new saga with a given ID. The method can be called by HTTP handler.
-func (s Saga) Start(ctx context.Context, sagaID uuid.UUID) error {
+// The payload is optional and is passed to the first service of the workflow. The callback is optional
+// and is stored with the saga. It returns database.ErrDBDuplicatedEntry if the saga has been already started.
+func (s Saga) Start(ctx context.Context, sagaID uuid.UUID, payload json.RawMessage, callback *database.Webhook) error {
+	ctx, span := s.startSpan(ctx, "saga.start", sagaID)
+	err := s.start(ctx, sagaID, payload, callback)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s Saga) start(ctx context.Context, sagaID uuid.UUID, payload json.RawMessage, callback *database.Webhook) error {
 	if len(s.workflow.Services) == 0 {
 		return fmt.Errorf("empty workflow")
 	}
 	service := s.workflow.Services[0]
 
-	if err := s.storage.InsertSaga(ctx, sagaID, service.Name, StatusStarted); err != nil {
+	entry := s.entry(ctx, transition{sagaID: sagaID, step: service.Name, to: StatusStarted, triggeredBy: triggeredByAPI})
+	err := s.storage.InsertSaga(ctx, sagaID, s.workflow.Name, service.Name, StatusStarted, payload, callback, s.maxRunning, &entry)
+	if errors.Is(err, database.ErrDBLimitExceeded) {
+		return fmt.Errorf("%w: %v", ErrTooManyRunning, err)
+	}
+	if err != nil {
 		return err
 	}
+	s.deliver(ctx, entry)
 
-	err := service.send(queue.Command{
-		SagaID: sagaID,
-		Name:   CommandStart,
+	err = service.send(ctx, queue.Command{
+		ID:      CommandID(sagaID, service.Name, CommandStart, 0),
+		SagaID:  sagaID,
+		Name:    CommandStart,
+		Payload: payload,
 	})
 	if err != nil {
+		// The saga can't be started again with its ID, so it fails and can be retried.
+		ferr := s.transit(ctx, sagaID, func(state database.Saga) error {
+			if state.Status != StatusStarted || state.Service != service.Name {
+				return nil
+			}
+			return s.finish(ctx, state, service.Name, StatusError, triggeredByAPI)
+		})
+		if ferr != nil {
+			s.log.Errorw("saga", "ERROR", fmt.Errorf("fail saga %s: %w", sagaID, ferr))
+		}
 		return fmt.Errorf("service send: %w", err)
 	}
+	sagasStarted.WithLabelValues(s.workflow.Name).Inc()
 
 	return nil
 }
 
 // ProcessMessage receives a message with a response from a service and decides which service has to
-// be called next according to the saga workflow.
+// be called next according to the saga workflow. The payload of the response is passed to the next service.
 func (s Saga) ProcessMessage(ctx context.Context, inp any) error {
 	response, ok := inp.(queue.Response)
 	if !ok {
 		return fmt.Errorf("malformed response")
 	}
 
+	ctx, span := s.startSpan(ctx, "saga.process", response.SagaID,
+		attributeService.String(response.Service), attributeStatus.String(response.Status))
+	err := s.process(ctx, response)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s Saga) process(ctx context.Context, response queue.Response) error {
+	if _, err := s.findService(response.Service); err != nil {
+		invalidResponses.WithLabelValues(s.workflow.Name).Inc()
+		return fmt.Errorf("%w: saga %s: %v", queue.ErrRejected, response.SagaID, err)
+	}
+
 	switch response.Status {
 	case StatusWorkDone:
+		if err := s.validate(response); err != nil {
+			invalidResponses.WithLabelValues(s.workflow.Name).Inc()
+			return fmt.Errorf("%w: saga %s service %s: %v", queue.ErrRejected, response.SagaID, response.Service, err)
+		}
+
 		return s.startNextService(ctx, response)
 
 	case StatusError:
-		if err := s.storage.UpdateStatus(ctx, response.SagaID, response.Status); err != nil {
-			return fmt.Errorf("save status: %w", err)
+		if err := s.finishStep(ctx, response, StatusError); err != nil {
+			return err
 		}
 
 		return fmt.Errorf("response error %s", response.SagaID)
 
 	default:
-		return fmt.Errorf("unknown status %s saga %s", response.Status, response.SagaID)
+		invalidResponses.WithLabelValues(s.workflow.Name).Inc()
+		return fmt.Errorf("%w: unknown status %s saga %s", queue.ErrRejected, response.Status, response.SagaID)
 	}
 }
 
+// observeStep measures the latency of the step finished by the response. The step is timed from
+// the last update of the saga, so a duplicate response of an already finished step is not measured.
+func (s Saga) observeStep(state database.Saga, r queue.Response) {
+	if state.Service != r.Service || state.Status != StatusStarted {
+		return
+	}
+
+	stepDuration.WithLabelValues(r.Service).Observe(time.Since(state.DateUpdated).Seconds())
+}
+
+// validate checks the response payload against the schema of the service which sent it.
+func (s Saga) validate(r queue.Response) error {
+	i, err := s.findService(r.Service)
+	if err != nil || s.workflow.Services[i].Schema == nil {
+		return nil
+	}
+
+	var payload any
+	if len(r.Payload) > 0 {
+		if err := json.Unmarshal(r.Payload, &payload); err != nil {
+			return fmt.Errorf("payload: %w", err)
+		}
+	}
+
+	if err := s.workflow.Services[i].Schema.Validate(payload); err != nil {
+		return fmt.Errorf("payload: %w", err)
+	}
+
+	return nil
+}
+
+// startNextService moves the saga to the service following the one which sent the response, or finishes the saga
+// after the last service. A response to a saga which is not running anymore or is in another step is a duplicate
+// or came after the saga was cancelled or retried, so it is ignored as well as a response to an unknown saga.
 func (s Saga) startNextService(ctx context.Context, r queue.Response) error {
-	next, err := s.findNextService(r.Service)
-	if err != nil {
-		if err == ErrEndOfWorkflow {
-			if err := s.storage.UpdateStatus(ctx, r.SagaID, StatusCompleted); err != nil {
-				return fmt.Errorf("update status: %w", err)
-			}
+	err := s.transit(ctx, r.SagaID, func(state database.Saga) error {
+		if state.Status != StatusStarted || state.Service != r.Service {
 			return nil
 		}
+		s.observeStep(state, r)
 
-		if err := s.storage.UpdateStatus(ctx, r.SagaID, StatusError); err != nil {
-			return fmt.Errorf("update status: %w", err)
+		next, err := s.findNextService(r.Service)
+		if err == ErrEndOfWorkflow {
+			return s.finish(ctx, state, r.Service, StatusCompleted, triggeredByService(r.Service))
 		}
-		return fmt.Errorf("find next: %w", err)
+		if err != nil {
+			return fmt.Errorf("find next: %w", err)
+		}
+
+		entry := s.entry(ctx, transition{sagaID: r.SagaID, step: next.Name, from: StatusStarted, to: StatusStarted,
+			triggeredBy: triggeredByService(r.Service)})
+		if err := s.storage.UpdateService(ctx, r.SagaID, state.Version, next.Name, &entry); err != nil {
+			return fmt.Errorf("update service: %w", err)
+		}
+		s.deliver(ctx, entry)
+
+		err = next.send(ctx, queue.Command{
+			ID:      CommandID(r.SagaID, next.Name, CommandStart, state.Retries),
+			SagaID:  r.SagaID,
+			Name:    CommandStart,
+			Payload: r.Payload,
+		})
+		if err != nil {
+			return fmt.Errorf("service send: %w", err)
+		}
+
+		return nil
+	})
+	if errors.Is(err, database.ErrDBNotFound) {
+		return nil
 	}
 
-	if err := s.storage.UpdateService(ctx, r.SagaID, r.Service, next.Name); err != nil {
-		// if service was not updated we don't send a command to the service.
-		if err == sql.ErrNoRows {
+	return err
+}
+
+// finishStep moves the saga to the terminal status after the response. A response to a saga which is not
+// running anymore or is in another step is a duplicate or came after the saga was cancelled or retried,
+// so it is ignored.
+func (s Saga) finishStep(ctx context.Context, r queue.Response, status string) error {
+	err := s.transit(ctx, r.SagaID, func(state database.Saga) error {
+		if state.Status != StatusStarted || state.Service != r.Service {
 			return nil
 		}
-		return fmt.Errorf("update service: %w", err)
+
+		return s.finish(ctx, state, r.Service, status, triggeredByService(r.Service))
+	})
+	if errors.Is(err, database.ErrDBNotFound) {
+		return nil
 	}
 
-	err = next.send(queue.Command{
-		SagaID: r.SagaID,
-		Name:   CommandStart,
+	return err
+}
+
+// finish moves the saga from its state to the terminal status and notifies the caller.
+// It returns a *database.ConflictError if the saga was changed since its state was read.
+func (s Saga) finish(ctx context.Context, state database.Saga, step, status, triggeredBy string) error {
+	entry := s.entry(ctx, transition{sagaID: state.ID, step: step, from: state.Status, to: status, triggeredBy: triggeredBy})
+	err := s.changeStatus(state, status, func() error {
+		return s.storage.UpdateStatus(ctx, state.ID, state.Version, status, &entry)
 	})
 	if err != nil {
-		return fmt.Errorf("service send: %w", err)
+		return fmt.Errorf("update status: %w", err)
 	}
+	switch status {
+	case StatusCompleted:
+		sagasCompleted.WithLabelValues(s.workflow.Name).Inc()
+	case StatusCancelled:
+		sagasCancelled.WithLabelValues(s.workflow.Name).Inc()
+	default:
+		sagasFailed.WithLabelValues(s.workflow.Name).Inc()
+	}
+	s.deliver(ctx, entry)
 
 	return nil
 }
 
-func (s Saga) findNextService(service string) (Service, error) {
-	var i int
-	found := false
-	for i = range s.workflow.Services {
-		if s.workflow.Services[i].Name == service {
-			found = true
-			break
+// transit applies the transition to the current state of the saga. If the saga is changed concurrently,
+// the transition is applied again to the reloaded state, so it has to check the state before changing it.
+func (s Saga) transit(ctx context.Context, sagaID uuid.UUID, apply func(database.Saga) error) error {
+	for attempt := 1; ; attempt++ {
+		state, err := s.storage.GetSaga(ctx, sagaID)
+		if err != nil {
+			return err
 		}
+
+		err = apply(state)
+		var conflict *database.ConflictError
+		if attempt >= transitionAttempts || !errors.As(err, &conflict) {
+			return err
+		}
+		transitionConflicts.WithLabelValues(s.workflow.Name).Inc()
 	}
+}
 
-	if !found {
-		return Service{}, ErrServiceNotFound
+// Cancel stops the running saga. Responses of services to the saga which come later are ignored.
+func (s Saga) Cancel(ctx context.Context, sagaID uuid.UUID) error {
+	return s.transit(ctx, sagaID, func(state database.Saga) error {
+		if err := s.checkTransition(state, StatusCancelled); err != nil {
+			return err
+		}
+
+		return s.finish(ctx, state, state.Service, StatusCancelled, triggeredByAPI)
+	})
+}
+
+// Retry starts the failed step of the saga again with the payload of the saga. The commands of the retried
+// saga get new IDs, so the services don't drop them as duplicates of the ones sent before.
+func (s Saga) Retry(ctx context.Context, sagaID uuid.UUID) error {
+	return s.RetryFrom(ctx, sagaID, "")
+}
+
+// RetryFrom starts the failed saga again from the step of the workflow with the payload of the saga.
+// An empty step means the failed step.
+func (s Saga) RetryFrom(ctx context.Context, sagaID uuid.UUID, step string) error {
+	return s.transit(ctx, sagaID, func(state database.Saga) error {
+		if err := s.checkTransition(state, StatusStarted); err != nil {
+			return err
+		}
+		from := step
+		if from == "" {
+			from = state.Service
+		}
+		i, err := s.findService(from)
+		if err != nil {
+			return fmt.Errorf("find service %s: %w", from, err)
+		}
+		service := s.workflow.Services[i]
+
+		entry := s.entry(ctx, transition{sagaID: sagaID, step: service.Name, from: state.Status, to: StatusStarted,
+			triggeredBy: triggeredByAPI})
+		err = s.changeStatus(state, StatusStarted, func() error {
+			return s.storage.RetrySaga(ctx, sagaID, state.Version, service.Name, &entry)
+		})
+		if err != nil {
+			return fmt.Errorf("retry: %w", err)
+		}
+		s.deliver(ctx, entry)
+
+		err = service.send(ctx, queue.Command{
+			ID:      CommandID(sagaID, service.Name, CommandStart, state.Retries+1),
+			SagaID:  sagaID,
+			Name:    CommandStart,
+			Payload: state.Payload,
+		})
+		if err != nil {
+			return fmt.Errorf("service send: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// Get returns the state of the saga.
+func (s Saga) Get(ctx context.Context, sagaID uuid.UUID) (database.Saga, error) {
+	return s.storage.GetSaga(ctx, sagaID)
+}
+
+// CommandID returns the ID of a command sent to a service in a saga after the number of retries of the saga.
+// Commands of a retried saga get new IDs, so services and FIFO queues don't drop them as duplicates of
+// the commands sent before the retry.
+func CommandID(sagaID uuid.UUID, service, command string, retry int) uuid.UUID {
+	if retry == 0 {
+		return queue.MessageID(sagaID, service+"/"+command)
+	}
+
+	return queue.MessageID(sagaID, fmt.Sprintf("%s/%s/%d", service, command, retry))
+}
+
+func (s Saga) findNextService(service string) (Service, error) {
+	i, err := s.findService(service)
+	if err != nil {
+		return Service{}, err
 	}
 	if i+1 >= len(s.workflow.Services) {
 		return Service{}, ErrEndOfWorkflow
@@ -156,6 +446,16 @@ func (s Saga) findNextService(service string) (Service, error) {
 	return s.workflow.Services[i+1], nil
 }
 
-func (s Service) send(msg queue.Command) error {
-	return s.Sender.Send(msg)
+func (s Saga) findService(service string) (int, error) {
+	for i := range s.workflow.Services {
+		if s.workflow.Services[i].Name == service {
+			return i, nil
+		}
+	}
+
+	return 0, ErrServiceNotFound
+}
+
+func (s Service) send(ctx context.Context, msg queue.Command) error {
+	return s.Sender.Send(ctx, msg)
 }
@@ -1,20 +1,28 @@
 //go:generate mockgen -destination=mock_storer_test.go -package=saga_test github.com/illyasch/saga-service/pkg/business/saga Storer
 //go:generate mockgen -destination=mock_sender_test.go -package=saga_test github.com/illyasch/saga-service/pkg/business/saga Sender
+//go:generate mockgen -destination=mock_auditsink_test.go -package=saga_test github.com/illyasch/saga-service/pkg/business/saga AuditSink
+//go:generate mockgen -destination=mock_notifier_test.go -package=saga_test github.com/illyasch/saga-service/pkg/business/saga Notifier
 package saga_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/data/audit"
+	"github.com/illyasch/saga-service/pkg/data/database"
 	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/sys/requestid"
 )
 
 func TestSaga_Start(t *testing.T) {
@@ -23,25 +31,28 @@ func TestSaga_Start(t *testing.T) {
 		defer ctrl.Finish()
 
 		sagaID := uuid.New()
+		payload := json.RawMessage(`{"order_id": 42}`)
 		workflow := saga.Workflow{
 			Services: saga.SampleWorkflow,
 		}
 
-		storage := NewMockStorer(ctrl)
+		storage := newStorage(ctrl)
 		storage.EXPECT().
-			InsertSaga(gomock.Any(), sagaID, workflow.Services[0].Name, saga.StatusStarted).
+			InsertSaga(gomock.Any(), sagaID, workflow.Name, workflow.Services[0].Name, saga.StatusStarted, payload, gomock.Nil(), 0, gomock.Any()).
 			Return(nil)
 
 		sender := NewMockSender(ctrl)
-		sender.EXPECT().Send(queue.Command{
-			SagaID: sagaID,
-			Name:   saga.CommandStart,
+		sender.EXPECT().Send(gomock.Any(), queue.Command{
+			ID:      saga.CommandID(sagaID, workflow.Services[0].Name, saga.CommandStart, 0),
+			SagaID:  sagaID,
+			Name:    saga.CommandStart,
+			Payload: payload,
 		}).Return(nil)
 		workflow.Services[0].Sender = sender
 
 		s := saga.New(workflow, storage)
 
-		err := s.Start(context.Background(), sagaID)
+		err := s.Start(context.Background(), sagaID, payload, nil)
 		require.NoError(t, err)
 	})
 
@@ -50,23 +61,24 @@ func TestSaga_Start(t *testing.T) {
 		defer ctrl.Finish()
 
 		sagaID := uuid.New()
+		var payload json.RawMessage
 		workflow := saga.Workflow{
 			Services: saga.SampleWorkflow,
 		}
 
 		dbErr := errors.New("DB error")
-		storage := NewMockStorer(ctrl)
+		storage := newStorage(ctrl)
 		storage.EXPECT().
-			InsertSaga(gomock.Any(), sagaID, workflow.Services[0].Name, saga.StatusStarted).
+			InsertSaga(gomock.Any(), sagaID, workflow.Name, workflow.Services[0].Name, saga.StatusStarted, payload, gomock.Nil(), 0, gomock.Any()).
 			Return(dbErr)
 
 		sender := NewMockSender(ctrl)
-		sender.EXPECT().Send(gomock.Any()).Times(0)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Times(0)
 		workflow.Services[0].Sender = sender
 
 		s := saga.New(workflow, storage)
 
-		err := s.Start(context.Background(), sagaID)
+		err := s.Start(context.Background(), sagaID, payload, nil)
 		assert.ErrorIs(t, err, dbErr)
 	})
 
@@ -75,28 +87,163 @@ func TestSaga_Start(t *testing.T) {
 		defer ctrl.Finish()
 
 		sagaID := uuid.New()
+		var payload json.RawMessage
 		workflow := saga.Workflow{
 			Services: saga.SampleWorkflow,
 		}
 
 		qErr := errors.New("queue error")
-		storage := NewMockStorer(ctrl)
+		storage := newStorage(ctrl)
 		storage.EXPECT().
-			InsertSaga(gomock.Any(), sagaID, workflow.Services[0].Name, saga.StatusStarted).
+			InsertSaga(gomock.Any(), sagaID, workflow.Name, workflow.Services[0].Name, saga.StatusStarted, payload, gomock.Nil(), 0, gomock.Any()).
 			Return(nil)
 
 		sender := NewMockSender(ctrl)
-		sender.EXPECT().Send(queue.Command{
+		sender.EXPECT().Send(gomock.Any(), queue.Command{
+			ID:     saga.CommandID(sagaID, workflow.Services[0].Name, saga.CommandStart, 0),
 			SagaID: sagaID,
 			Name:   saga.CommandStart,
 		}).Return(qErr)
 		workflow.Services[0].Sender = sender
 
+		// The saga which wasn't started fails, so it can be retried.
+		storage.EXPECT().
+			GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[0].Name, Version: 1}, nil)
+		storage.EXPECT().
+			UpdateStatus(gomock.Any(), sagaID, 1, saga.StatusError, gomock.Any()).
+			Return(nil)
+
 		s := saga.New(workflow, storage)
 
-		err := s.Start(context.Background(), sagaID)
+		err := s.Start(context.Background(), sagaID, payload, nil)
 		assert.ErrorIs(t, err, qErr)
 	})
+
+	t.Run("published change has the sequence number of its outbox entry", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			InsertSaga(gomock.Any(), sagaID, workflow.Name, workflow.Services[0].Name, saga.StatusStarted, gomock.Any(), gomock.Nil(), 0, gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ uuid.UUID, _, _, _ string, _ json.RawMessage, _ *database.Webhook, _ int, e *database.OutboxEntry) error {
+				e.Seq = 42
+				return nil
+			})
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+		workflow.Services[0].Sender = sender
+
+		pub := &publisher{}
+		s := saga.New(workflow, storage, saga.WithPublisher(pub))
+
+		require.NoError(t, s.Start(context.Background(), sagaID, nil, nil))
+		require.Len(t, pub.events, 1)
+		assert.Equal(t, uint64(42), pub.events[0].Seq)
+		assert.Equal(t, sagaID, pub.events[0].SagaID)
+	})
+
+	t.Run("saga start with callback", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+		callback := &database.Webhook{URL: "https://example.com/hook", Secret: "secret", State: database.WebhookWaiting}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			InsertSaga(gomock.Any(), sagaID, workflow.Name, workflow.Services[0].Name, saga.StatusStarted, gomock.Any(), callback, 0, gomock.Any()).
+			Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+		workflow.Services[0].Sender = sender
+
+		s := saga.New(workflow, storage)
+
+		require.NoError(t, s.Start(context.Background(), sagaID, nil, callback))
+	})
+
+	t.Run("saga already started", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			InsertSaga(gomock.Any(), sagaID, workflow.Name, workflow.Services[0].Name, saga.StatusStarted, gomock.Any(), gomock.Nil(), 0, gomock.Any()).
+			Return(database.ErrDBDuplicatedEntry)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Times(0)
+		workflow.Services[0].Sender = sender
+
+		s := saga.New(workflow, storage)
+
+		err := s.Start(context.Background(), sagaID, nil, nil)
+		assert.ErrorIs(t, err, database.ErrDBDuplicatedEntry)
+	})
+
+	t.Run("saga start under the running cap", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			InsertSaga(gomock.Any(), sagaID, workflow.Name, workflow.Services[0].Name, saga.StatusStarted, gomock.Any(), gomock.Nil(), 2, gomock.Any()).
+			Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+		workflow.Services[0].Sender = sender
+
+		s := saga.New(workflow, storage, saga.WithMaxRunning(2))
+
+		err := s.Start(context.Background(), sagaID, nil, nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("saga start over the running cap", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			InsertSaga(gomock.Any(), gomock.Any(), workflow.Name, gomock.Any(), saga.StatusStarted, gomock.Any(), gomock.Any(), 2, gomock.Any()).
+			Return(database.ErrDBLimitExceeded)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Times(0)
+		workflow.Services[0].Sender = sender
+
+		s := saga.New(workflow, storage, saga.WithMaxRunning(2))
+
+		err := s.Start(context.Background(), uuid.New(), nil, nil)
+		assert.ErrorIs(t, err, saga.ErrTooManyRunning)
+	})
 }
 
 func TestSaga_ProcessMessage(t *testing.T) {
@@ -109,13 +256,17 @@ func TestSaga_ProcessMessage(t *testing.T) {
 			Services: saga.SampleWorkflow,
 		}
 
-		storage := NewMockStorer(ctrl)
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[0].Name, Version: 3}, nil)
 		storage.EXPECT().
-			UpdateService(gomock.Any(), sagaID, workflow.Services[0].Name, workflow.Services[1].Name).
+			UpdateService(gomock.Any(), sagaID, 3, workflow.Services[1].Name, gomock.Any()).
 			Return(nil)
 
 		sender := NewMockSender(ctrl)
-		sender.EXPECT().Send(queue.Command{
+		sender.EXPECT().Send(gomock.Any(), queue.Command{
+			ID:     saga.CommandID(sagaID, workflow.Services[1].Name, saga.CommandStart, 0),
 			SagaID: sagaID,
 			Name:   saga.CommandStart,
 		}).Return(nil)
@@ -140,13 +291,16 @@ func TestSaga_ProcessMessage(t *testing.T) {
 			Services: saga.SampleWorkflow,
 		}
 
-		storage := NewMockStorer(ctrl)
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[2].Name, Version: 5}, nil)
 		storage.EXPECT().
-			UpdateStatus(gomock.Any(), sagaID, saga.StatusCompleted).
+			UpdateStatus(gomock.Any(), sagaID, 5, saga.StatusCompleted, gomock.Any()).
 			Return(nil)
 
 		sender := NewMockSender(ctrl)
-		sender.EXPECT().Send(gomock.Any()).Times(0)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Times(0)
 		workflow.Services[2].Sender = sender
 
 		s := saga.New(workflow, storage)
@@ -168,13 +322,16 @@ func TestSaga_ProcessMessage(t *testing.T) {
 			Services: saga.SampleWorkflow,
 		}
 
-		storage := NewMockStorer(ctrl)
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[0].Name, Version: 1}, nil)
 		storage.EXPECT().
-			UpdateStatus(gomock.Any(), sagaID, saga.StatusError).
+			UpdateStatus(gomock.Any(), sagaID, 1, saga.StatusError, gomock.Any()).
 			Return(nil)
 
 		sender := NewMockSender(ctrl)
-		sender.EXPECT().Send(gomock.Any()).Times(0)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Times(0)
 		workflow.Services[0].Sender = sender
 
 		s := saga.New(workflow, storage)
@@ -187,4 +344,823 @@ func TestSaga_ProcessMessage(t *testing.T) {
 
 		require.ErrorContains(t, err, fmt.Sprintf("response error %s", sagaID))
 	})
+	t.Run("response with a valid payload", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: append([]saga.Service(nil), saga.SampleWorkflow...),
+		}
+		workflow.Services[0].Schema = jsonschema.MustCompileString("service1.json", testSchema)
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[0].Name, Version: 3}, nil)
+		storage.EXPECT().
+			UpdateService(gomock.Any(), sagaID, 3, workflow.Services[1].Name, gomock.Any()).
+			Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), queue.Command{
+			ID:      saga.CommandID(sagaID, workflow.Services[1].Name, saga.CommandStart, 0),
+			SagaID:  sagaID,
+			Name:    saga.CommandStart,
+			Payload: json.RawMessage(`{"order_id": 42}`),
+		}).Return(nil)
+		workflow.Services[1].Sender = sender
+
+		s := saga.New(workflow, storage)
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[0].Name,
+			Status:  saga.StatusWorkDone,
+			Payload: json.RawMessage(`{"order_id": 42}`),
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("response with an invalid payload", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: append([]saga.Service(nil), saga.SampleWorkflow...),
+		}
+		workflow.Services[0].Schema = jsonschema.MustCompileString("service1.json", testSchema)
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().UpdateService(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Times(0)
+		workflow.Services[1].Sender = sender
+
+		s := saga.New(workflow, storage)
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[0].Name,
+			Status:  saga.StatusWorkDone,
+			Payload: []byte(`{"order_id": "42"}`),
+		})
+		assert.ErrorIs(t, err, queue.ErrRejected)
+		assert.ErrorContains(t, err, "order_id")
+	})
+
+	t.Run("stale response of the last service is ignored", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().
+			GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[1].Name, Version: 4}, nil)
+
+		s := saga.New(workflow, storage)
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[2].Name,
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("response of an unknown service", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := newStorage(ctrl)
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage)
+
+		for _, status := range []string{saga.StatusWorkDone, saga.StatusError} {
+			err := s.ProcessMessage(context.Background(), queue.Response{
+				SagaID:  uuid.New(),
+				Service: "service9",
+				Status:  status,
+			})
+			assert.ErrorIs(t, err, queue.ErrRejected)
+		}
+	})
+
+	t.Run("response with an unknown status", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		s := saga.New(workflow, storage)
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  uuid.New(),
+			Service: workflow.Services[0].Name,
+			Status:  "unknown",
+		})
+		assert.ErrorIs(t, err, queue.ErrRejected)
+	})
+}
+
+func TestSaga_Audit(t *testing.T) {
+	t.Run("saga start is recorded", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Name:     saga.SampleWorkflowName,
+			Services: append([]saga.Service(nil), saga.SampleWorkflow...),
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().InsertSaga(gomock.Any(), sagaID, workflow.Name, workflow.Services[0].Name, saga.StatusStarted, gomock.Any(), gomock.Nil(), 0, gomock.Any()).Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+		workflow.Services[0].Sender = sender
+
+		var event audit.Event
+		sink := NewMockAuditSink(ctrl)
+		sink.EXPECT().Record(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, e audit.Event) error {
+			event = e
+			return nil
+		})
+
+		s := saga.New(workflow, storage, saga.WithAudit(sink))
+
+		ctx := requestid.NewContext(context.Background(), "request-1")
+		require.NoError(t, s.Start(ctx, sagaID, nil, nil))
+
+		assert.Equal(t, sagaID, event.SagaID)
+		assert.Equal(t, saga.SampleWorkflowName, event.Workflow)
+		assert.Equal(t, workflow.Services[0].Name, event.Step)
+		assert.Empty(t, event.FromStatus)
+		assert.Equal(t, saga.StatusStarted, event.ToStatus)
+		assert.Equal(t, "api", event.TriggeredBy)
+		assert.Equal(t, "request-1", event.RequestID)
+		assert.False(t, event.Time.IsZero())
+	})
+
+	t.Run("saga completion is recorded", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[2].Name, Version: 5}, nil)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 5, saga.StatusCompleted, gomock.Any()).Return(nil)
+
+		sink := NewMockAuditSink(ctrl)
+		sink.EXPECT().Record(gomock.Any(), auditEvent{
+			step: workflow.Services[2].Name, from: saga.StatusStarted, to: saga.StatusCompleted, triggeredBy: "service:service3",
+		}).Return(nil)
+
+		s := saga.New(workflow, storage, saga.WithAudit(sink))
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[2].Name,
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("failed audit is left to the outbox relay", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := NewMockStorer(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[2].Name, Version: 1}, nil)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 1, saga.StatusCompleted, outboxEntry{
+			step: workflow.Services[2].Name, from: saga.StatusStarted, to: saga.StatusCompleted, triggeredBy: "service:service3",
+		}).Return(nil)
+		storage.EXPECT().DeleteOutbox(gomock.Any(), gomock.Any()).Times(0)
+
+		sink := NewMockAuditSink(ctrl)
+		sink.EXPECT().Record(gomock.Any(), gomock.Any()).Return(errors.New("audit error"))
+
+		s := saga.New(workflow, storage, saga.WithAudit(sink))
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[2].Name,
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
 }
+
+func TestSaga_Notify(t *testing.T) {
+	t.Run("completed saga is notified", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[2].Name, Version: 5}, nil)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 5, saga.StatusCompleted, gomock.Any()).Return(nil)
+
+		notifier := NewMockNotifier(ctrl)
+		notifier.EXPECT().Notify(gomock.Any(), sagaID, saga.StatusCompleted).Return(nil)
+
+		s := saga.New(workflow, storage, saga.WithNotifier(notifier))
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[2].Name,
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("failed saga is notified", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[1].Name, Version: 3}, nil)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 3, saga.StatusError, gomock.Any()).Return(nil)
+
+		notifier := NewMockNotifier(ctrl)
+		notifier.EXPECT().Notify(gomock.Any(), sagaID, saga.StatusError).Return(nil)
+
+		s := saga.New(workflow, storage, saga.WithNotifier(notifier))
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[1].Name,
+			Status:  saga.StatusError,
+		})
+		require.ErrorContains(t, err, fmt.Sprintf("response error %s", sagaID))
+	})
+
+	t.Run("retried saga is notified of its final outcome", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: append([]saga.Service(nil), saga.SampleWorkflow...),
+		}
+		last := workflow.Services[2].Name
+
+		storage := newStorage(ctrl)
+		failed := storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: last, Version: 3}, nil)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 3, saga.StatusError, gomock.Any()).Return(nil)
+		retried := storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusError, Service: last, Version: 4}, nil).After(failed)
+		storage.EXPECT().RetrySaga(gomock.Any(), sagaID, 4, last, gomock.Any()).Return(nil)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: last, Retries: 1, Version: 5}, nil).After(retried)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 5, saga.StatusCompleted, gomock.Any()).Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+		workflow.Services[2].Sender = sender
+
+		notifier := NewMockNotifier(ctrl)
+		gomock.InOrder(
+			notifier.EXPECT().Notify(gomock.Any(), sagaID, saga.StatusError).Return(nil),
+			notifier.EXPECT().Notify(gomock.Any(), sagaID, saga.StatusCompleted).Return(nil),
+		)
+
+		s := saga.New(workflow, storage, saga.WithNotifier(notifier))
+
+		err := s.ProcessMessage(context.Background(), queue.Response{SagaID: sagaID, Service: last, Status: saga.StatusError})
+		require.ErrorContains(t, err, fmt.Sprintf("response error %s", sagaID))
+		require.NoError(t, s.Retry(context.Background(), sagaID))
+		err = s.ProcessMessage(context.Background(), queue.Response{SagaID: sagaID, Service: last, Status: saga.StatusWorkDone})
+		require.NoError(t, err)
+	})
+
+	t.Run("saga step is not notified", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: append([]saga.Service(nil), saga.SampleWorkflow...),
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[0].Name, Version: 1}, nil)
+		storage.EXPECT().UpdateService(gomock.Any(), sagaID, 1, workflow.Services[1].Name, gomock.Any()).Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+		workflow.Services[1].Sender = sender
+
+		s := saga.New(workflow, storage, saga.WithNotifier(NewMockNotifier(ctrl)))
+
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[0].Name,
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestSaga_Cancel(t *testing.T) {
+	t.Run("running saga is cancelled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[1].Name, Version: 3}, nil)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 3, saga.StatusCancelled, gomock.Any()).Return(nil)
+
+		sink := NewMockAuditSink(ctrl)
+		sink.EXPECT().Record(gomock.Any(), auditEvent{
+			step: workflow.Services[1].Name, from: saga.StatusStarted, to: saga.StatusCancelled, triggeredBy: "api",
+		}).Return(nil)
+
+		notifier := NewMockNotifier(ctrl)
+		notifier.EXPECT().Notify(gomock.Any(), sagaID, saga.StatusCancelled).Return(nil)
+
+		s := saga.New(workflow, storage, saga.WithAudit(sink), saga.WithNotifier(notifier))
+		require.NoError(t, s.Cancel(context.Background(), sagaID))
+	})
+
+	t.Run("finished saga is not cancelled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusCompleted, Service: "service3"}, nil)
+
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage)
+		assert.ErrorIs(t, s.Cancel(context.Background(), sagaID), saga.ErrWrongStatus)
+	})
+
+	t.Run("unknown saga", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), gomock.Any()).Return(database.Saga{}, database.ErrDBNotFound)
+
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage)
+		assert.ErrorIs(t, s.Cancel(context.Background(), uuid.New()), database.ErrDBNotFound)
+	})
+
+	t.Run("response to a cancelled saga is ignored", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusCancelled, Service: workflow.Services[2].Name}, nil)
+
+		notifier := NewMockNotifier(ctrl)
+		notifier.EXPECT().Notify(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		s := saga.New(workflow, storage, saga.WithNotifier(notifier))
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[2].Name,
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestSaga_Retry(t *testing.T) {
+	t.Run("failed step is started again", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		payload := json.RawMessage(`{"order_id": 42}`)
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusError, Service: workflow.Services[1].Name, Payload: payload, Version: 3}, nil)
+		storage.EXPECT().RetrySaga(gomock.Any(), sagaID, 3, workflow.Services[1].Name, outboxEntry{
+			step: workflow.Services[1].Name, from: saga.StatusError, to: saga.StatusStarted, triggeredBy: "api",
+		}).Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, msg any) error {
+			cmd := msg.(queue.Command)
+			assert.Equal(t, sagaID, cmd.SagaID)
+			assert.Equal(t, saga.CommandStart, cmd.Name)
+			assert.Equal(t, payload, cmd.Payload)
+			assert.Equal(t, saga.CommandID(sagaID, workflow.Services[1].Name, saga.CommandStart, 1), cmd.ID)
+			assert.NotEqual(t, saga.CommandID(sagaID, workflow.Services[1].Name, saga.CommandStart, 0), cmd.ID)
+			return nil
+		})
+		workflow.Services = append([]saga.Service(nil), workflow.Services...)
+		workflow.Services[1].Sender = sender
+
+		s := saga.New(workflow, storage)
+		require.NoError(t, s.Retry(context.Background(), sagaID))
+	})
+
+	t.Run("next step of a retried saga gets a new command ID", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: append([]saga.Service(nil), saga.SampleWorkflow...),
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[0].Name, Version: 7, Retries: 2}, nil)
+		storage.EXPECT().UpdateService(gomock.Any(), sagaID, 7, workflow.Services[1].Name, gomock.Any()).Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, msg any) error {
+			assert.Equal(t, saga.CommandID(sagaID, workflow.Services[1].Name, saga.CommandStart, 2), msg.(queue.Command).ID)
+			return nil
+		})
+		workflow.Services[1].Sender = sender
+
+		s := saga.New(workflow, storage)
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[0].Name,
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("saga is started again from the step", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: append([]saga.Service(nil), saga.SampleWorkflow...),
+		}
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusError, Service: workflow.Services[2].Name, Version: 5}, nil)
+		storage.EXPECT().RetrySaga(gomock.Any(), sagaID, 5, workflow.Services[0].Name, gomock.Any()).Return(nil)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+		workflow.Services[0].Sender = sender
+
+		s := saga.New(workflow, storage)
+		require.NoError(t, s.RetryFrom(context.Background(), sagaID, workflow.Services[0].Name))
+	})
+
+	t.Run("unknown step", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusError, Service: "service1"}, nil)
+
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage)
+		assert.ErrorIs(t, s.RetryFrom(context.Background(), sagaID, "service9"), saga.ErrServiceNotFound)
+	})
+
+	t.Run("running saga is not retried", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: "service1"}, nil)
+
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage)
+		assert.ErrorIs(t, s.Retry(context.Background(), sagaID), saga.ErrWrongStatus)
+	})
+}
+
+func TestSaga_Conflicts(t *testing.T) {
+	t.Run("error doesn't overwrite a concurrent completion", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: saga.SampleWorkflow,
+		}
+
+		storage := newStorage(ctrl)
+		gomock.InOrder(
+			storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+				Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[2].Name, Version: 5}, nil),
+			storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 5, saga.StatusError, gomock.Any()).
+				Return(&database.ConflictError{SagaID: sagaID, Version: 5, Current: 6}),
+			storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+				Return(database.Saga{ID: sagaID, Status: saga.StatusCompleted, Service: workflow.Services[2].Name, Version: 6}, nil),
+		)
+
+		notifier := NewMockNotifier(ctrl)
+		notifier.EXPECT().Notify(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		s := saga.New(workflow, storage, saga.WithNotifier(notifier))
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[2].Name,
+			Status:  saga.StatusError,
+		})
+		require.ErrorContains(t, err, fmt.Sprintf("response error %s", sagaID))
+	})
+
+	t.Run("step is moved after a concurrent change", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		workflow := saga.Workflow{
+			Services: append([]saga.Service(nil), saga.SampleWorkflow...),
+		}
+
+		storage := newStorage(ctrl)
+		gomock.InOrder(
+			storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+				Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[0].Name, Version: 1}, nil),
+			storage.EXPECT().UpdateService(gomock.Any(), sagaID, 1, workflow.Services[1].Name, gomock.Any()).
+				Return(&database.ConflictError{SagaID: sagaID, Version: 1, Current: 2}),
+			storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+				Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: workflow.Services[0].Name, Version: 2}, nil),
+			storage.EXPECT().UpdateService(gomock.Any(), sagaID, 2, workflow.Services[1].Name, gomock.Any()).Return(nil),
+		)
+
+		sender := NewMockSender(ctrl)
+		sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+		workflow.Services[1].Sender = sender
+
+		s := saga.New(workflow, storage)
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  sagaID,
+			Service: workflow.Services[0].Name,
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("transition gives up after attempts", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: "service2", Version: 1}, nil).Times(3)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 1, saga.StatusCancelled, gomock.Any()).
+			Return(&database.ConflictError{SagaID: sagaID, Version: 1, Current: 2}).Times(3)
+
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage)
+
+		var conflict *database.ConflictError
+		assert.ErrorAs(t, s.Cancel(context.Background(), sagaID), &conflict)
+	})
+
+	t.Run("response to an unknown saga is ignored", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), gomock.Any()).Return(database.Saga{}, database.ErrDBNotFound)
+
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage)
+		err := s.ProcessMessage(context.Background(), queue.Response{
+			SagaID:  uuid.New(),
+			Service: "service1",
+			Status:  saga.StatusWorkDone,
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		legal    bool
+	}{
+		{saga.StatusStarted, saga.StatusCompleted, true},
+		{saga.StatusStarted, saga.StatusError, true},
+		{saga.StatusStarted, saga.StatusCompensated, true},
+		{saga.StatusStarted, saga.StatusCancelled, true},
+		{saga.StatusError, saga.StatusStarted, true},
+		{saga.StatusError, saga.StatusCompensated, true},
+		{saga.StatusStarted, saga.StatusStarted, false},
+		{saga.StatusStarted, saga.StatusWorkDone, false},
+		{saga.StatusError, saga.StatusCancelled, false},
+		{saga.StatusCompleted, saga.StatusError, false},
+		{saga.StatusCompensated, saga.StatusStarted, false},
+		{saga.StatusCancelled, saga.StatusCompleted, false},
+		{"unknown", saga.StatusStarted, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			assert.Equal(t, tt.legal, saga.CanTransition(tt.from, tt.to))
+		})
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	var terminal []string
+	for _, status := range saga.Statuses() {
+		if saga.IsTerminal(status) {
+			terminal = append(terminal, status)
+		}
+	}
+
+	assert.Equal(t, []string{saga.StatusCompleted, saga.StatusCompensated, saga.StatusCancelled}, terminal)
+	assert.False(t, saga.IsTerminal(saga.StatusWorkDone))
+}
+
+func TestSaga_IllegalTransitions(t *testing.T) {
+	t.Run("failed saga is not cancelled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusError, Service: "service2"}, nil)
+		storage.EXPECT().UpdateStatus(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage)
+		err := s.Cancel(context.Background(), sagaID)
+		assert.ErrorIs(t, err, saga.ErrIllegalTransition)
+		assert.ErrorIs(t, err, saga.ErrWrongStatus)
+	})
+
+	t.Run("transition rejected by the database", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		storage := newStorage(ctrl)
+		storage.EXPECT().GetSaga(gomock.Any(), sagaID).
+			Return(database.Saga{ID: sagaID, Status: saga.StatusStarted, Service: "service1", Version: 2}, nil)
+		storage.EXPECT().UpdateStatus(gomock.Any(), sagaID, 2, saga.StatusCancelled, gomock.Any()).
+			Return(fmt.Errorf("%w: saga can't move from completed to cancelled", database.ErrDBIllegalTransition))
+
+		notifier := NewMockNotifier(ctrl)
+		notifier.EXPECT().Notify(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage, saga.WithNotifier(notifier))
+		assert.ErrorIs(t, s.Cancel(context.Background(), sagaID), saga.ErrIllegalTransition)
+	})
+}
+
+func TestSaga_RunOutbox(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	delivered := database.OutboxEntry{Event: audit.Event{ID: uuid.New(), SagaID: uuid.New(), ToStatus: saga.StatusCompleted}}
+	failed := database.OutboxEntry{Event: audit.Event{ID: uuid.New(), SagaID: uuid.New(), ToStatus: saga.StatusStarted}}
+
+	storage := NewMockStorer(ctrl)
+	storage.EXPECT().ClaimOutbox(gomock.Any(), 10, time.Minute).Return([]database.OutboxEntry{delivered, failed}, nil)
+	storage.EXPECT().ClaimOutbox(gomock.Any(), 10, time.Minute).Return(nil, nil).AnyTimes()
+	storage.EXPECT().DeleteOutbox(gomock.Any(), delivered.ID).DoAndReturn(func(context.Context, uuid.UUID) error {
+		cancel()
+		return nil
+	})
+
+	sink := NewMockAuditSink(ctrl)
+	sink.EXPECT().Record(gomock.Any(), delivered.Event).Return(nil)
+	sink.EXPECT().Record(gomock.Any(), failed.Event).Return(errors.New("audit error"))
+
+	notifier := NewMockNotifier(ctrl)
+	notifier.EXPECT().Notify(gomock.Any(), delivered.SagaID, saga.StatusCompleted).Return(nil)
+
+	s := saga.New(saga.Workflow{Services: saga.SampleWorkflow}, storage, saga.WithAudit(sink), saga.WithNotifier(notifier))
+	err := s.RunOutbox(ctx, saga.OutboxConfig{Interval: time.Millisecond, BatchSize: 10, Lease: time.Minute})
+	require.NoError(t, err)
+}
+
+func TestQueueSpecs(t *testing.T) {
+	workflows := []saga.Workflow{
+		{Name: "first", Services: saga.SampleWorkflow[:2]},
+		{Name: "second", Services: saga.SampleWorkflow[1:]},
+	}
+
+	specs := saga.QueueSpecs(workflows, saga.QueueConfig{
+		Responses:         saga.QueueName,
+		VisibilityTimeout: time.Minute,
+		MaxReceiveCount:   5,
+	})
+
+	var names []string
+	for _, s := range specs {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{
+		"responses-dlq", "responses",
+		"commands1-dlq", "commands1",
+		"commands2-dlq", "commands2",
+		"commands3-dlq", "commands3",
+	}, names)
+	assert.Equal(t, queue.Spec{Name: "commands2", VisibilityTimeout: time.Minute, DeadLetter: "commands2-dlq", MaxReceiveCount: 5}, specs[5])
+}
+
+// newStorage returns a storage mock which accepts removal of delivered outbox entries.
+func newStorage(ctrl *gomock.Controller) *MockStorer {
+	storage := NewMockStorer(ctrl)
+	storage.EXPECT().DeleteOutbox(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	return storage
+}
+
+// auditEvent matches an audit event by its transition.
+type auditEvent struct {
+	step, from, to, triggeredBy string
+}
+
+func (m auditEvent) Matches(x any) bool {
+	e, ok := x.(audit.Event)
+	return ok && e.Step == m.step && e.FromStatus == m.from && e.ToStatus == m.to && e.TriggeredBy == m.triggeredBy
+}
+
+func (m auditEvent) String() string {
+	return fmt.Sprintf("is audit event of step %s from %q to %q by %s", m.step, m.from, m.to, m.triggeredBy)
+}
+
+// outboxEntry matches an outbox entry by the transition of its event.
+type outboxEntry auditEvent
+
+func (m outboxEntry) Matches(x any) bool {
+	e, ok := x.(*database.OutboxEntry)
+	return ok && auditEvent(m).Matches(e.Event)
+}
+
+func (m outboxEntry) String() string {
+	return auditEvent(m).String()
+}
+
+// publisher records published state changes.
+type publisher struct {
+	events []audit.Event
+}
+
+func (p *publisher) Publish(_ context.Context, e audit.Event) {
+	p.events = append(p.events, e)
+}
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"order_id": {"type": "integer"}
+	},
+	"required": ["order_id"]
+}`
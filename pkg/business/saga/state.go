@@ -0,0 +1,77 @@
+package saga
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// ErrIllegalTransition is returned when a saga can't move from its status to the requested one.
+var ErrIllegalTransition = fmt.Errorf("%w: illegal transition", ErrWrongStatus)
+
+// transitions are the statuses a saga can move to from every status. A saga is inserted as started.
+// The done status of responses isn't a status of sagas, a saga moves to the next service in the started status.
+// The same state machine is enforced in the database by the trigger saga_status_transition.
+var transitions = map[string][]string{
+	StatusStarted:     {StatusCompleted, StatusError, StatusCompensated, StatusCancelled},
+	StatusError:       {StatusStarted, StatusCompensated},
+	StatusCompleted:   nil,
+	StatusCompensated: nil,
+	StatusCancelled:   nil,
+}
+
+// Statuses returns all statuses of sagas.
+func Statuses() []string {
+	return []string{StatusStarted, StatusError, StatusCompleted, StatusCompensated, StatusCancelled}
+}
+
+// CanTransition reports whether a saga can move from the status to the next one.
+func CanTransition(from, to string) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsTerminal reports whether a saga in the status is finished and can't move to another status.
+func IsTerminal(status string) bool {
+	next, ok := transitions[status]
+	return ok && len(next) == 0
+}
+
+// checkTransition returns ErrIllegalTransition and logs it if the saga can't move from its state to the status.
+func (s Saga) checkTransition(state database.Saga, to string) error {
+	if CanTransition(state.Status, to) {
+		return nil
+	}
+
+	return s.illegalTransition(state, to, "rejected")
+}
+
+// changeStatus moves the saga from its state to the status with the update. A transition rejected
+// by the database is reported as ErrIllegalTransition as well.
+func (s Saga) changeStatus(state database.Saga, to string, update func() error) error {
+	if err := s.checkTransition(state, to); err != nil {
+		return err
+	}
+
+	err := update()
+	if errors.Is(err, database.ErrDBIllegalTransition) {
+		return fmt.Errorf("%w: %v", s.illegalTransition(state, to, "rejected by database"), err)
+	}
+
+	return err
+}
+
+// illegalTransition counts and logs the transition of the saga and returns ErrIllegalTransition.
+func (s Saga) illegalTransition(state database.Saga, to, reason string) error {
+	illegalTransitions.WithLabelValues(s.workflow.Name).Inc()
+	s.log.Warnw("saga", "status", "illegal transition "+reason, "saga_id", state.ID, "workflow", s.workflow.Name,
+		"from", state.Status, "to", to)
+
+	return fmt.Errorf("%w: saga %s from %s to %s", ErrIllegalTransition, state.ID, state.Status, to)
+}
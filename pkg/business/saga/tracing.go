@@ -0,0 +1,36 @@
+package saga
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/illyasch/saga-service/pkg/business/saga"
+
+// Set of span attributes of a saga.
+const (
+	attributeSagaID   = attribute.Key("saga.id")
+	attributeWorkflow = attribute.Key("saga.workflow")
+	attributeService  = attribute.Key("saga.service")
+	attributeStatus   = attribute.Key("saga.status")
+)
+
+// startSpan starts a span of a saga transition.
+func (s Saga) startSpan(ctx context.Context, name string, sagaID uuid.UUID, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attributeSagaID.String(sagaID.String()), attributeWorkflow.String(s.workflow.Name))
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
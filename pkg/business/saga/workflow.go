@@ -2,36 +2,83 @@ package saga
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 
 	"github.com/illyasch/saga-service/pkg/data/queue"
 )
 
 // Workflow represents a saga workflow with a list of services in order of their sequential execution.
+// The name of the workflow labels its metrics.
 type Workflow struct {
+	Name     string
 	Services []Service
 }
 
+// SampleWorkflowName is the name of the sample workflow.
+const SampleWorkflowName = "sample"
+
 var SampleWorkflow = []Service{
 	{
-		"service1", "commands1", nil,
+		Name: "service1", Topic: "commands1",
 	},
 	{
-		"service2", "commands2", nil,
+		Name: "service2", Topic: "commands2",
 	},
 	{
-		"service3", "commands3", nil,
+		Name: "service3", Topic: "commands3",
 	},
 }
 
+// LoadSchemas compiles the schemas of responses of the services from the directory. The schema of a service
+// is in the file named after the service with the .json extension, responses of services without one are
+// not validated. A file of an unknown service is an error, so a misnamed schema isn't silently ignored.
+func LoadSchemas(dir string, services []Service) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read schemas: %w", err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+
+		name := strings.TrimSuffix(f.Name(), ".json")
+		i := -1
+		for j := range services {
+			if services[j].Name == name {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return fmt.Errorf("schema %s of unknown service %q", f.Name(), name)
+		}
+
+		schema, err := jsonschema.Compile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return fmt.Errorf("compile schema %s: %w", f.Name(), err)
+		}
+		services[i].Schema = schema
+	}
+
+	return nil
+}
+
 // NewWorkflowWithSQS initializes a new workflow with a SQS queues for each service.
-func NewWorkflowWithSQS(services []Service, awsSQS *sqs.SQS) (Workflow, error) {
-	w := Workflow{Services: services}
+// The options are applied to the sender of every service.
+func NewWorkflowWithSQS(name string, services []Service, awsSQS *sqs.SQS, opts ...queue.SenderOption) (Workflow, error) {
+	w := Workflow{Name: name, Services: services}
 
 	var err error
 	for i := range services {
-		services[i].Sender, err = queue.NewSender(awsSQS, services[i].Topic)
+		services[i].Sender, err = queue.NewSender(awsSQS, services[i].Topic, opts...)
 		if err != nil {
 			return w, fmt.Errorf("new sender(%s): %w", services[i].Topic, err)
 		}
@@ -39,3 +86,71 @@ func NewWorkflowWithSQS(services []Service, awsSQS *sqs.SQS) (Workflow, error) {
 
 	return w, nil
 }
+
+// NewWorkflowWithBatchSQS initializes a new workflow with a SQS queues for each service. Commands sent to a service
+// concurrently are collected into batches for up to the interval. The returned batch senders have to be run while
+// the workflow is used.
+func NewWorkflowWithBatchSQS(name string, services []Service, awsSQS *sqs.SQS, interval time.Duration, opts ...queue.SenderOption) (Workflow, []*queue.BatchSender, error) {
+	w := Workflow{Name: name, Services: services}
+
+	batches := make([]*queue.BatchSender, 0, len(services))
+	for i := range services {
+		sender, err := queue.NewSender(awsSQS, services[i].Topic, opts...)
+		if err != nil {
+			return w, nil, fmt.Errorf("new sender(%s): %w", services[i].Topic, err)
+		}
+
+		batch := queue.NewBatchSender(sender, interval)
+		services[i].Sender = batch
+		batches = append(batches, batch)
+	}
+
+	return w, batches, nil
+}
+
+// QueueConfig is the properties of queues used by workflows.
+type QueueConfig struct {
+	// Responses is the queue of responses of the services.
+	Responses string
+	// DeadLetter is the dead-letter queue of responses. It's derived from the name of the responses queue if it's empty.
+	DeadLetter        string
+	VisibilityTimeout time.Duration
+	RetentionPeriod   time.Duration
+	MaxReceiveCount   int
+}
+
+// QueueSpecs returns specs of the command queues of the services of the workflows and the responses queue.
+// Every queue has a dead-letter queue, which comes before it.
+func QueueSpecs(workflows []Workflow, cfg QueueConfig) []queue.Spec {
+	names := []string{cfg.Responses}
+	deadLetters := map[string]string{cfg.Responses: cfg.DeadLetter}
+	for _, w := range workflows {
+		for _, s := range w.Services {
+			if _, ok := deadLetters[s.Topic]; ok {
+				continue
+			}
+			names = append(names, s.Topic)
+			deadLetters[s.Topic] = ""
+		}
+	}
+
+	specs := make([]queue.Spec, 0, 2*len(names))
+	for _, name := range names {
+		deadLetter := deadLetters[name]
+		if deadLetter == "" {
+			deadLetter = queue.DeadLetterName(name)
+		}
+		specs = append(specs,
+			queue.Spec{Name: deadLetter, RetentionPeriod: cfg.RetentionPeriod},
+			queue.Spec{
+				Name:              name,
+				VisibilityTimeout: cfg.VisibilityTimeout,
+				RetentionPeriod:   cfg.RetentionPeriod,
+				DeadLetter:        deadLetter,
+				MaxReceiveCount:   cfg.MaxReceiveCount,
+			},
+		)
+	}
+
+	return specs
+}
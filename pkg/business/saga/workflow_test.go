@@ -0,0 +1,59 @@
+package saga_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/business/saga"
+)
+
+func TestLoadSchemas(t *testing.T) {
+	services := func() []saga.Service {
+		return []saga.Service{{Name: "service1"}, {Name: "service2"}}
+	}
+	write := func(t *testing.T, dir, name, schema string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(schema), 0o600))
+	}
+
+	t.Run("schemas of services", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "service1.json", testSchema)
+		write(t, dir, "README.md", "not a schema")
+
+		s := services()
+		require.NoError(t, saga.LoadSchemas(dir, s))
+		require.NotNil(t, s[0].Schema)
+		assert.NoError(t, s[0].Schema.Validate(map[string]any{"order_id": 42.0}))
+		assert.Error(t, s[0].Schema.Validate(map[string]any{}))
+		assert.Nil(t, s[1].Schema)
+	})
+
+	t.Run("invalid schema", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "service1.json", `{"type": 42}`)
+
+		assert.Error(t, saga.LoadSchemas(dir, services()))
+	})
+
+	t.Run("malformed schema", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "service1.json", `{"type":`)
+
+		assert.Error(t, saga.LoadSchemas(dir, services()))
+	})
+
+	t.Run("schema of unknown service", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "service4.json", testSchema)
+
+		assert.Error(t, saga.LoadSchemas(dir, services()))
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		assert.Error(t, saga.LoadSchemas(filepath.Join(t.TempDir(), "schemas"), services()))
+	})
+}
@@ -0,0 +1,13 @@
+package stuck
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// stuckSagas is the number of stuck sagas per step found by the last check.
+var stuckSagas = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "saga",
+	Name:      "stuck_sagas",
+	Help:      "Number of sagas stuck in a step per service.",
+}, []string{"service"})
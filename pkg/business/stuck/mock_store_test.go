@@ -0,0 +1,52 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/illyasch/saga-service/pkg/business/stuck (interfaces: Store)
+
+// Package stuck_test is a generated GoMock package.
+package stuck_test
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	database "github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// MockStore is a mock of Store interface.
+type MockStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockStoreMockRecorder
+}
+
+// MockStoreMockRecorder is the mock recorder for MockStore.
+type MockStoreMockRecorder struct {
+	mock *MockStore
+}
+
+// NewMockStore creates a new mock instance.
+func NewMockStore(ctrl *gomock.Controller) *MockStore {
+	mock := &MockStore{ctrl: ctrl}
+	mock.recorder = &MockStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStore) EXPECT() *MockStoreMockRecorder {
+	return m.recorder
+}
+
+// StaleSagas mocks base method.
+func (m *MockStore) StaleSagas(arg0 context.Context, arg1 string, arg2 time.Duration, arg3 int) ([]database.Saga, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StaleSagas", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]database.Saga)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StaleSagas indicates an expected call of StaleSagas.
+func (mr *MockStoreMockRecorder) StaleSagas(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StaleSagas", reflect.TypeOf((*MockStore)(nil).StaleSagas), arg0, arg1, arg2, arg3)
+}
@@ -0,0 +1,85 @@
+package stuck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/webhook"
+)
+
+// LogNotifier writes alerts to the log.
+type LogNotifier struct {
+	log *zap.SugaredLogger
+}
+
+// NewLogNotifier constructs a LogNotifier writing to the log.
+func NewLogNotifier(log *zap.SugaredLogger) LogNotifier {
+	return LogNotifier{log: log}
+}
+
+// Notify writes the alert to the log.
+func (n LogNotifier) Notify(_ context.Context, a Alert) error {
+	n.log.Warnw("stuck", "status", "saga is stuck", "saga_id", a.SagaID, "service", a.Service,
+		"saga_status", a.Status, "since", a.Since)
+
+	return nil
+}
+
+// WebhookNotifier posts alerts as JSON to a URL. With a secret the requests are signed
+// like webhooks of saga outcomes.
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookNotifier constructs a WebhookNotifier posting to the URL.
+func NewWebhookNotifier(alertURL, secret string, timeout time.Duration) (WebhookNotifier, error) {
+	u, err := url.Parse(alertURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return WebhookNotifier{}, fmt.Errorf("%w %q", webhook.ErrInvalidURL, alertURL)
+	}
+
+	return WebhookNotifier{url: alertURL, secret: secret, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Notify posts the alert to the URL.
+func (n WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("json marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(webhook.HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(webhook.HeaderSignature, webhook.Sign(n.secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+// Package stuck detects sagas whose current step has not progressed within its threshold and
+// alerts about them. Every stuck saga is alerted once, until it progresses or the repeat interval passes.
+package stuck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// Store interface abstracts querying of sagas which were not updated for a while.
+type Store interface {
+	StaleSagas(context.Context, string, time.Duration, int) ([]database.Saga, error)
+}
+
+// Notifier interface abstracts sending of alerts about stuck sagas.
+type Notifier interface {
+	Notify(context.Context, Alert) error
+}
+
+// Config is the properties of the detection. Thresholds override the default Threshold per step.
+// Repeat is the interval of repeated alerts about a saga which is still stuck, zero disables them.
+type Config struct {
+	Interval   time.Duration
+	Status     string
+	Threshold  time.Duration
+	Thresholds map[string]time.Duration
+	Repeat     time.Duration
+	BatchSize  int
+}
+
+// Alert is a saga stuck in a step.
+type Alert struct {
+	SagaID  uuid.UUID `json:"saga_id"`
+	Service string    `json:"service"`
+	Status  string    `json:"status"`
+	Since   time.Time `json:"since"`
+}
+
+// alerted is the step of a saga which was alerted and when.
+type alerted struct {
+	service string
+	since   time.Time
+	at      time.Time
+}
+
+// Detector periodically checks for stuck sagas. Alerted sagas are kept in memory, so every
+// instance of the service alerts about a stuck saga independently.
+type Detector struct {
+	store    Store
+	notifier Notifier
+	cfg      Config
+	log      *zap.SugaredLogger
+	alerted  map[uuid.UUID]alerted
+}
+
+// NewDetector constructs a Detector of stuck sagas in the store which alerts with the notifier.
+func NewDetector(store Store, notifier Notifier, cfg Config, log *zap.SugaredLogger) *Detector {
+	return &Detector{
+		store:    store,
+		notifier: notifier,
+		cfg:      cfg,
+		log:      log,
+		alerted:  make(map[uuid.UUID]alerted),
+	}
+}
+
+// Run checks for stuck sagas every interval until the context is cancelled.
+func (d *Detector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.check(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// check finds stuck sagas, updates the gauge and alerts about sagas which were not alerted yet.
+func (d *Detector) check(ctx context.Context) {
+	sagas, err := d.store.StaleSagas(ctx, d.cfg.Status, d.minThreshold(), d.cfg.BatchSize)
+	if err != nil {
+		d.log.Errorw("stuck", "ERROR", fmt.Errorf("stale sagas: %w", err))
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[uuid.UUID]bool, len(sagas))
+	counts := make(map[string]int)
+	for _, s := range sagas {
+		if now.Sub(s.DateUpdated) < d.threshold(s.Service) {
+			continue
+		}
+		seen[s.ID] = true
+		counts[s.Service]++
+
+		// A saga is alerted again only if it moved to another step or the repeat interval passed.
+		if a, ok := d.alerted[s.ID]; ok && a.service == s.Service && a.since.Equal(s.DateUpdated) &&
+			(d.cfg.Repeat == 0 || now.Sub(a.at) < d.cfg.Repeat) {
+			continue
+		}
+
+		alert := Alert{SagaID: s.ID, Service: s.Service, Status: s.Status, Since: s.DateUpdated}
+		if err := d.notifier.Notify(ctx, alert); err != nil {
+			// The saga is not marked as alerted, so the alert is retried on the next check.
+			d.log.Errorw("stuck", "ERROR", fmt.Errorf("notify saga %s: %w", s.ID, err))
+			continue
+		}
+		d.alerted[s.ID] = alerted{service: s.Service, since: s.DateUpdated, at: now}
+	}
+
+	// Sagas which progressed are forgotten.
+	for id := range d.alerted {
+		if !seen[id] {
+			delete(d.alerted, id)
+		}
+	}
+
+	stuckSagas.Reset()
+	for service, n := range counts {
+		stuckSagas.WithLabelValues(service).Set(float64(n))
+	}
+	if len(seen) > 0 {
+		d.log.Warnw("stuck", "status", "stuck sagas found", "count", len(seen))
+	}
+}
+
+// threshold returns the threshold of the step.
+func (d *Detector) threshold(service string) time.Duration {
+	if t, ok := d.cfg.Thresholds[service]; ok {
+		return t
+	}
+
+	return d.cfg.Threshold
+}
+
+// minThreshold returns the shortest threshold of all steps.
+func (d *Detector) minThreshold() time.Duration {
+	min := d.cfg.Threshold
+	for _, t := range d.cfg.Thresholds {
+		if t < min {
+			min = t
+		}
+	}
+
+	return min
+}
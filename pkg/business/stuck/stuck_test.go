@@ -0,0 +1,184 @@
+//go:generate mockgen -destination=mock_store_test.go -package=stuck_test github.com/illyasch/saga-service/pkg/business/stuck Store
+package stuck_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/stuck"
+	"github.com/illyasch/saga-service/pkg/business/webhook"
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+var testConfig = stuck.Config{
+	Interval:   5 * time.Millisecond,
+	Status:     "started",
+	Threshold:  time.Minute,
+	Thresholds: map[string]time.Duration{"service1": 10 * time.Minute},
+	BatchSize:  100,
+}
+
+// recorder is a notifier recording alerts. It fails while err is set.
+type recorder struct {
+	mu     sync.Mutex
+	alerts []stuck.Alert
+	err    error
+}
+
+func (r *recorder) Notify(_ context.Context, a stuck.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func (r *recorder) recorded() []stuck.Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]stuck.Alert(nil), r.alerts...)
+}
+
+func TestDetector_Run(t *testing.T) {
+	t.Run("thresholds per step", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		slow := database.Saga{ID: uuid.New(), Status: "started", Service: "service1", DateUpdated: time.Now().Add(-5 * time.Minute)}
+		stuckSaga := database.Saga{ID: uuid.New(), Status: "started", Service: "service2", DateUpdated: time.Now().Add(-5 * time.Minute)}
+
+		store := NewMockStore(ctrl)
+		store.EXPECT().StaleSagas(gomock.Any(), "started", time.Minute, 100).Return([]database.Saga{slow, stuckSaga}, nil).MinTimes(1)
+		n := &recorder{}
+
+		runDetector(t, store, n, testConfig)
+		assert.Equal(t, []stuck.Alert{{SagaID: stuckSaga.ID, Service: "service2", Status: "started", Since: stuckSaga.DateUpdated}}, n.recorded())
+	})
+
+	t.Run("saga is alerted once per step", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s := database.Saga{ID: uuid.New(), Status: "started", Service: "service2", DateUpdated: time.Now().Add(-time.Hour)}
+		next := s
+		next.Service, next.DateUpdated = "service3", time.Now().Add(-30*time.Minute)
+
+		var calls int
+		store := NewMockStore(ctrl)
+		store.EXPECT().StaleSagas(gomock.Any(), "started", time.Minute, 100).DoAndReturn(
+			func(context.Context, string, time.Duration, int) ([]database.Saga, error) {
+				calls++
+				if calls < 5 {
+					return []database.Saga{s}, nil
+				}
+				return []database.Saga{next}, nil
+			}).MinTimes(6)
+		n := &recorder{}
+
+		runDetector(t, store, n, testConfig)
+		alerts := n.recorded()
+		require.Len(t, alerts, 2)
+		assert.Equal(t, "service2", alerts[0].Service)
+		assert.Equal(t, "service3", alerts[1].Service)
+	})
+
+	t.Run("alert is repeated after the interval", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s := database.Saga{ID: uuid.New(), Status: "started", Service: "service2", DateUpdated: time.Now().Add(-time.Hour)}
+		store := NewMockStore(ctrl)
+		store.EXPECT().StaleSagas(gomock.Any(), "started", time.Minute, 100).Return([]database.Saga{s}, nil).MinTimes(1)
+		n := &recorder{}
+
+		cfg := testConfig
+		cfg.Repeat = time.Nanosecond
+		runDetector(t, store, n, cfg)
+		assert.Greater(t, len(n.recorded()), 1)
+	})
+
+	t.Run("failed alert is retried", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s := database.Saga{ID: uuid.New(), Status: "started", Service: "service2", DateUpdated: time.Now().Add(-time.Hour)}
+		store := NewMockStore(ctrl)
+		store.EXPECT().StaleSagas(gomock.Any(), "started", time.Minute, 100).Return([]database.Saga{s}, nil).MinTimes(1)
+		n := &recorder{err: errors.New("unavailable")}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.NoError(t, stuck.NewDetector(store, n, testConfig, zap.NewNop().Sugar()).Run(ctx))
+		}()
+		time.Sleep(5 * testConfig.Interval)
+		n.mu.Lock()
+		n.err = nil
+		n.mu.Unlock()
+		time.Sleep(5 * testConfig.Interval)
+		cancel()
+		<-done
+
+		assert.Len(t, n.recorded(), 1)
+	})
+}
+
+// runDetector runs the detector for a number of checks.
+func runDetector(t *testing.T, store stuck.Store, n stuck.Notifier, cfg stuck.Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, stuck.NewDetector(store, n, cfg, zap.NewNop().Sugar()).Run(ctx))
+	}()
+
+	time.Sleep(20 * cfg.Interval)
+	cancel()
+	<-done
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	alert := stuck.Alert{SagaID: uuid.New(), Service: "service2", Status: "started", Since: time.Now().UTC().Truncate(time.Second)}
+
+	received := make(chan stuck.Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		ts, err := strconv.ParseInt(r.Header.Get(webhook.HeaderTimestamp), 10, 64)
+		require.NoError(t, err)
+		assert.Equal(t, webhook.Sign("secret", ts, body), r.Header.Get(webhook.HeaderSignature))
+
+		var a stuck.Alert
+		require.NoError(t, json.Unmarshal(body, &a))
+		received <- a
+	}))
+	defer server.Close()
+
+	n, err := stuck.NewWebhookNotifier(server.URL, "secret", time.Second)
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), alert))
+	assert.Equal(t, alert, <-received)
+
+	_, err = stuck.NewWebhookNotifier("example.com", "", time.Second)
+	assert.ErrorIs(t, err, webhook.ErrInvalidURL)
+}
@@ -0,0 +1,110 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/illyasch/saga-service/pkg/business/webhook (interfaces: Store)
+
+// Package webhook_test is a generated GoMock package.
+package webhook_test
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	uuid "github.com/google/uuid"
+	database "github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// MockStore is a mock of Store interface.
+type MockStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockStoreMockRecorder
+}
+
+// MockStoreMockRecorder is the mock recorder for MockStore.
+type MockStoreMockRecorder struct {
+	mock *MockStore
+}
+
+// NewMockStore creates a new mock instance.
+func NewMockStore(ctrl *gomock.Controller) *MockStore {
+	mock := &MockStore{ctrl: ctrl}
+	mock.recorder = &MockStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStore) EXPECT() *MockStoreMockRecorder {
+	return m.recorder
+}
+
+// ClaimWebhooks mocks base method.
+func (m *MockStore) ClaimWebhooks(arg0 context.Context, arg1 int, arg2 time.Duration) ([]database.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimWebhooks", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]database.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimWebhooks indicates an expected call of ClaimWebhooks.
+func (mr *MockStoreMockRecorder) ClaimWebhooks(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimWebhooks", reflect.TypeOf((*MockStore)(nil).ClaimWebhooks), arg0, arg1, arg2)
+}
+
+// GetWebhook mocks base method.
+func (m *MockStore) GetWebhook(arg0 context.Context, arg1 uuid.UUID) (database.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhook", arg0, arg1)
+	ret0, _ := ret[0].(database.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhook indicates an expected call of GetWebhook.
+func (mr *MockStoreMockRecorder) GetWebhook(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhook", reflect.TypeOf((*MockStore)(nil).GetWebhook), arg0, arg1)
+}
+
+// MarkWebhookDelivered mocks base method.
+func (m *MockStore) MarkWebhookDelivered(arg0 context.Context, arg1 uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkWebhookDelivered", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkWebhookDelivered indicates an expected call of MarkWebhookDelivered.
+func (mr *MockStoreMockRecorder) MarkWebhookDelivered(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkWebhookDelivered", reflect.TypeOf((*MockStore)(nil).MarkWebhookDelivered), arg0, arg1)
+}
+
+// RetryWebhook mocks base method.
+func (m *MockStore) RetryWebhook(arg0 context.Context, arg1 uuid.UUID, arg2 string, arg3 *time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetryWebhook", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RetryWebhook indicates an expected call of RetryWebhook.
+func (mr *MockStoreMockRecorder) RetryWebhook(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryWebhook", reflect.TypeOf((*MockStore)(nil).RetryWebhook), arg0, arg1, arg2, arg3)
+}
+
+// ScheduleWebhook mocks base method.
+func (m *MockStore) ScheduleWebhook(arg0 context.Context, arg1 uuid.UUID, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ScheduleWebhook", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ScheduleWebhook indicates an expected call of ScheduleWebhook.
+func (mr *MockStoreMockRecorder) ScheduleWebhook(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScheduleWebhook", reflect.TypeOf((*MockStore)(nil).ScheduleWebhook), arg0, arg1, arg2)
+}
@@ -0,0 +1,242 @@
+// Package webhook delivers outcomes of sagas to callback URLs of their callers.
+// Deliveries are persisted, so they survive restarts and are retried with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// Set of headers of a webhook request.
+const (
+	HeaderTimestamp = "X-Saga-Timestamp"
+	HeaderSignature = "X-Saga-Signature"
+)
+
+// ErrInvalidURL is returned when a callback URL is not an absolute HTTP(S) URL or points to
+// a loopback, link-local or private address.
+var ErrInvalidURL = errors.New("invalid callback url")
+
+// errBlockedAddress is returned when a callback host resolves to an address webhooks aren't sent to.
+var errBlockedAddress = errors.New("blocked callback address")
+
+// Store interface abstracts persistence of webhook deliveries.
+type Store interface {
+	ScheduleWebhook(context.Context, uuid.UUID, string) error
+	ClaimWebhooks(context.Context, int, time.Duration) ([]database.Webhook, error)
+	MarkWebhookDelivered(context.Context, uuid.UUID) error
+	RetryWebhook(context.Context, uuid.UUID, string, *time.Time) error
+	GetWebhook(context.Context, uuid.UUID) (database.Webhook, error)
+}
+
+// Config is the properties of webhook deliveries. AllowPrivate allows callbacks to loopback, link-local
+// and private addresses, which are refused by default so callers can't reach internal services.
+type Config struct {
+	Interval     time.Duration
+	Timeout      time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	AllowPrivate bool
+}
+
+// Event is the body of a webhook request.
+type Event struct {
+	SagaID uuid.UUID `json:"saga_id"`
+	Status string    `json:"status"`
+}
+
+// Dispatcher validates callbacks of sagas and delivers the saga outcomes to them.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	cfg    Config
+	log    *zap.SugaredLogger
+}
+
+// NewDispatcher constructs a Dispatcher with the store of deliveries.
+func NewDispatcher(store Store, cfg Config, log *zap.SugaredLogger) Dispatcher {
+	// Hosts are checked again when connecting, as a registered name can resolve to another address later.
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blocked(ip) {
+				return fmt.Errorf("%w %s", errBlockedAddress, address)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return Dispatcher{
+		store:  store,
+		client: &http.Client{Timeout: cfg.Timeout, Transport: transport},
+		cfg:    cfg,
+		log:    log,
+	}
+}
+
+// Callback validates the callback URL and returns the callback to store with a new saga. The secret
+// is optional and is used to sign the webhook.
+func (d Dispatcher) Callback(callbackURL, secret string) (*database.Webhook, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, fmt.Errorf("%w %q", ErrInvalidURL, callbackURL)
+	}
+
+	if !d.cfg.AllowPrivate {
+		host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return nil, fmt.Errorf("%w %q: loopback host", ErrInvalidURL, callbackURL)
+		}
+		if ip := net.ParseIP(host); ip != nil && blocked(ip) {
+			return nil, fmt.Errorf("%w %q: blocked address", ErrInvalidURL, callbackURL)
+		}
+	}
+
+	return &database.Webhook{URL: callbackURL, Secret: secret, State: database.WebhookWaiting}, nil
+}
+
+// Notify schedules delivery of the terminal status of the saga to its callback, if any.
+func (d Dispatcher) Notify(ctx context.Context, sagaID uuid.UUID, status string) error {
+	return d.store.ScheduleWebhook(ctx, sagaID, status)
+}
+
+// Delivery returns the state of the webhook of the saga.
+func (d Dispatcher) Delivery(ctx context.Context, sagaID uuid.UUID) (database.Webhook, error) {
+	return d.store.GetWebhook(ctx, sagaID)
+}
+
+// Run delivers due webhooks every interval until the context is cancelled.
+func (d Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.dispatch(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// dispatch delivers claimed webhooks and records the results.
+func (d Dispatcher) dispatch(ctx context.Context) {
+	// The lease covers delivery of the whole batch.
+	webhooks, err := d.store.ClaimWebhooks(ctx, d.cfg.BatchSize, time.Duration(d.cfg.BatchSize+1)*d.cfg.Timeout)
+	if err != nil {
+		d.log.Errorw("webhook", "ERROR", fmt.Errorf("claim webhooks: %w", err))
+		return
+	}
+
+	for _, w := range webhooks {
+		if err := d.deliver(ctx, w); err != nil {
+			var next *time.Time
+			if w.Attempts+1 < d.cfg.MaxAttempts {
+				t := time.Now().Add(d.backoff(w.Attempts))
+				next = &t
+			}
+			d.log.Warnw("webhook", "WARNING", fmt.Errorf("deliver saga %s: %w", w.SagaID, err), "attempt", w.Attempts+1, "final", next == nil)
+
+			if err := d.store.RetryWebhook(ctx, w.SagaID, err.Error(), next); err != nil {
+				d.log.Errorw("webhook", "ERROR", fmt.Errorf("retry webhook: %w", err))
+			}
+			continue
+		}
+
+		if err := d.store.MarkWebhookDelivered(ctx, w.SagaID); err != nil {
+			d.log.Errorw("webhook", "ERROR", fmt.Errorf("mark webhook delivered: %w", err))
+		}
+	}
+}
+
+// deliver posts the saga outcome to the callback URL.
+func (d Dispatcher) deliver(ctx context.Context, w database.Webhook) error {
+	body, err := json.Marshal(Event{SagaID: w.SagaID, Status: w.SagaStatus})
+	if err != nil {
+		return fmt.Errorf("json marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.MinBackoff
+	for i := 0; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+
+	return delay
+}
+
+// blocked reports whether webhooks can't be sent to the address.
+func blocked(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// Sign returns the signature of the webhook body sent at the timestamp. Receivers compute
+// the HMAC-SHA256 of "<timestamp>.<body>" with the secret and compare it with the signature.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
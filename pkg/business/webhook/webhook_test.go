@@ -0,0 +1,215 @@
+//go:generate mockgen -destination=mock_store_test.go -package=webhook_test github.com/illyasch/saga-service/pkg/business/webhook Store
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/webhook"
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+var testConfig = webhook.Config{
+	Interval:    10 * time.Millisecond,
+	Timeout:     time.Second,
+	BatchSize:   10,
+	MaxAttempts: 3,
+	MinBackoff:  time.Second,
+	MaxBackoff:  time.Minute,
+	// Test servers listen on the loopback interface.
+	AllowPrivate: true,
+}
+
+func TestDispatcher_Callback(t *testing.T) {
+	cfg := testConfig
+	cfg.AllowPrivate = false
+	d := webhook.NewDispatcher(NewMockStore(gomock.NewController(t)), cfg, zap.NewNop().Sugar())
+
+	callback, err := d.Callback("https://example.com/hook", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, &database.Webhook{URL: "https://example.com/hook", Secret: "secret", State: database.WebhookWaiting}, callback)
+
+	for _, u := range []string{
+		"example.com/hook", "ftp://example.com", "http://", ":",
+		"http://localhost:8080/hook", "http://127.0.0.1/hook", "http://[::1]/hook", "http://0.0.0.0/hook",
+		"http://10.0.0.1/hook", "http://192.168.1.1/hook", "http://172.16.0.1/hook", "http://[fd00::1]/hook",
+		"http://169.254.169.254/latest/meta-data", "http://[fe80::1]/hook",
+	} {
+		_, err := d.Callback(u, "")
+		assert.ErrorIs(t, err, webhook.ErrInvalidURL, u)
+	}
+
+	allowed := webhook.NewDispatcher(NewMockStore(gomock.NewController(t)), testConfig, zap.NewNop().Sugar())
+	_, err = allowed.Callback("http://127.0.0.1/hook", "")
+	assert.NoError(t, err)
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	t.Run("signed delivery", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		received := make(chan webhook.Event, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			timestamp, err := strconv.ParseInt(r.Header.Get(webhook.HeaderTimestamp), 10, 64)
+			require.NoError(t, err)
+			assert.Equal(t, webhook.Sign("secret", timestamp, body), r.Header.Get(webhook.HeaderSignature))
+
+			var e webhook.Event
+			require.NoError(t, json.Unmarshal(body, &e))
+			received <- e
+		}))
+		defer server.Close()
+
+		done := make(chan struct{})
+		store := NewMockStore(ctrl)
+		claim(store, database.Webhook{SagaID: sagaID, URL: server.URL, Secret: "secret", SagaStatus: "completed"})
+		store.EXPECT().MarkWebhookDelivered(gomock.Any(), sagaID).DoAndReturn(func(context.Context, uuid.UUID) error {
+			close(done)
+			return nil
+		})
+
+		run(t, webhook.NewDispatcher(store, testConfig, zap.NewNop().Sugar()), done)
+		assert.Equal(t, webhook.Event{SagaID: sagaID, Status: "completed"}, <-received)
+	})
+
+	t.Run("outcome after a retry is delivered again", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		received := make(chan webhook.Event, 2)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var e webhook.Event
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+			received <- e
+		}))
+		defer server.Close()
+
+		// The retry of the failed saga resets the delivered webhook, so its final outcome is scheduled again.
+		sagaID := uuid.New()
+		done := make(chan struct{})
+		store := NewMockStore(ctrl)
+		failed := store.EXPECT().ClaimWebhooks(gomock.Any(), testConfig.BatchSize, gomock.Any()).
+			Return([]database.Webhook{{SagaID: sagaID, URL: server.URL, SagaStatus: "error"}}, nil)
+		delivered := store.EXPECT().MarkWebhookDelivered(gomock.Any(), sagaID).Return(nil).After(failed)
+		completed := store.EXPECT().ClaimWebhooks(gomock.Any(), testConfig.BatchSize, gomock.Any()).
+			Return([]database.Webhook{{SagaID: sagaID, URL: server.URL, SagaStatus: "completed"}}, nil).After(delivered)
+		store.EXPECT().MarkWebhookDelivered(gomock.Any(), sagaID).After(completed).DoAndReturn(func(context.Context, uuid.UUID) error {
+			close(done)
+			return nil
+		})
+		store.EXPECT().ClaimWebhooks(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).After(completed).AnyTimes()
+
+		run(t, webhook.NewDispatcher(store, testConfig, zap.NewNop().Sugar()), done)
+		assert.Equal(t, webhook.Event{SagaID: sagaID, Status: "error"}, <-received)
+		assert.Equal(t, webhook.Event{SagaID: sagaID, Status: "completed"}, <-received)
+	})
+
+	t.Run("failed delivery is retried with backoff", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		sagaID := uuid.New()
+		done := make(chan struct{})
+		store := NewMockStore(ctrl)
+		claim(store, database.Webhook{SagaID: sagaID, URL: server.URL, SagaStatus: "error", Attempts: 1})
+		store.EXPECT().RetryWebhook(gomock.Any(), sagaID, "unexpected status 503", gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ uuid.UUID, _ string, next *time.Time) error {
+				// The second failed attempt waits twice the minimal backoff.
+				require.NotNil(t, next)
+				assert.WithinDuration(t, time.Now().Add(2*time.Second), *next, time.Second)
+				close(done)
+				return nil
+			})
+
+		run(t, webhook.NewDispatcher(store, testConfig, zap.NewNop().Sugar()), done)
+	})
+
+	t.Run("delivery to a private address is refused", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		requested := make(chan struct{}, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested <- struct{}{}
+		}))
+		defer server.Close()
+
+		sagaID := uuid.New()
+		done := make(chan struct{})
+		store := NewMockStore(ctrl)
+		claim(store, database.Webhook{SagaID: sagaID, URL: server.URL, SagaStatus: "completed"})
+		store.EXPECT().RetryWebhook(gomock.Any(), sagaID, gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ uuid.UUID, lastError string, _ *time.Time) error {
+				assert.Contains(t, lastError, "blocked callback address")
+				close(done)
+				return nil
+			})
+
+		cfg := testConfig
+		cfg.AllowPrivate = false
+		run(t, webhook.NewDispatcher(store, cfg, zap.NewNop().Sugar()), done)
+		assert.Empty(t, requested)
+	})
+
+	t.Run("last failed delivery is not retried", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		sagaID := uuid.New()
+		done := make(chan struct{})
+		store := NewMockStore(ctrl)
+		claim(store, database.Webhook{SagaID: sagaID, URL: "http://127.0.0.1:1", SagaStatus: "error", Attempts: 2})
+		store.EXPECT().RetryWebhook(gomock.Any(), sagaID, gomock.Any(), gomock.Nil()).
+			DoAndReturn(func(context.Context, uuid.UUID, string, *time.Time) error {
+				close(done)
+				return nil
+			})
+
+		run(t, webhook.NewDispatcher(store, testConfig, zap.NewNop().Sugar()), done)
+	})
+}
+
+// claim makes the store return the webhook on the first claim and nothing after.
+func claim(store *MockStore, w database.Webhook) {
+	first := store.EXPECT().ClaimWebhooks(gomock.Any(), testConfig.BatchSize, gomock.Any()).Return([]database.Webhook{w}, nil)
+	store.EXPECT().ClaimWebhooks(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).After(first).AnyTimes()
+}
+
+// run runs the dispatcher until done is closed.
+func run(t *testing.T, d webhook.Dispatcher, done chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- d.Run(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("webhook is not dispatched")
+	}
+	cancel()
+	require.NoError(t, <-result)
+}
@@ -0,0 +1,238 @@
+// Package client is a Go client of the saga-service HTTP API. The API is described by the OpenAPI
+// document served at /openapi.json.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Set of errors matching responses of the API with errors.Is.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("saga not found")
+	ErrConflict        = errors.New("saga conflict")
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// Error is an error response of the API.
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is how long to wait before the next request if the rate limit is exceeded.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("saga api: %d %s", e.StatusCode, e.Message)
+}
+
+// Is matches the error with the error of its status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusTooManyRequests:
+		return target == ErrTooManyRequests
+	}
+
+	return false
+}
+
+// Saga is the state of a saga.
+type Saga struct {
+	ID          uuid.UUID `json:"id"`
+	Status      string    `json:"status"`
+	Service     string    `json:"service"`
+	DateCreated time.Time `json:"date_created"`
+	DateUpdated time.Time `json:"date_updated"`
+	Webhook     *Webhook  `json:"webhook,omitempty"`
+}
+
+// Webhook is the state of the delivery of a saga outcome to its callback.
+type Webhook struct {
+	URL           string     `json:"url"`
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttempt   *time.Time `json:"next_attempt,omitempty"`
+	DateDelivered *time.Time `json:"date_delivered,omitempty"`
+}
+
+// StartRequest is a saga to start. Payload, CallbackURL and CallbackSecret are optional.
+type StartRequest struct {
+	SagaID         uuid.UUID
+	Payload        json.RawMessage
+	CallbackURL    string
+	CallbackSecret string
+}
+
+// Client calls the API of a saga-service.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	apiKey  string
+	token   string
+}
+
+// Option configures optional behaviour of a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the Client send requests with the HTTP client.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.http = c
+	}
+}
+
+// WithAPIKey makes the Client authenticate with the static API key.
+func WithAPIKey(key string) Option {
+	return func(cl *Client) {
+		cl.apiKey = key
+	}
+}
+
+// WithToken makes the Client authenticate with the JWT bearer token.
+func WithToken(token string) Option {
+	return func(cl *Client) {
+		cl.token = token
+	}
+}
+
+// New constructs a Client of the API at the base URL, e.g. http://localhost:3000.
+func New(baseURL string, opts ...Option) *Client {
+	c := Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// Start starts the saga. It returns ErrConflict if a saga with the ID already exists.
+func (c *Client) Start(ctx context.Context, r StartRequest) error {
+	form := url.Values{"saga_id": {r.SagaID.String()}}
+	if len(r.Payload) > 0 {
+		form.Set("payload", string(r.Payload))
+	}
+	if r.CallbackURL != "" {
+		form.Set("callback_url", r.CallbackURL)
+		form.Set("callback_secret", r.CallbackSecret)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/start", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.do(req, nil)
+}
+
+// Get returns the state of the saga.
+func (c *Client) Get(ctx context.Context, sagaID uuid.UUID) (Saga, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/sagas/"+sagaID.String(), nil)
+	if err != nil {
+		return Saga{}, err
+	}
+
+	var s Saga
+	if err := c.do(req, &s); err != nil {
+		return Saga{}, err
+	}
+
+	return s, nil
+}
+
+// Cancel cancels the running saga. It requires the saga:manage scope and returns ErrForbidden without it.
+func (c *Client) Cancel(ctx context.Context, sagaID uuid.UUID) error {
+	req, err := c.newRequest(ctx, http.MethodPost, "/sagas/"+sagaID.String()+"/cancel", nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, nil)
+}
+
+// Retry starts the failed step of the saga again. It requires the saga:manage scope and returns ErrForbidden
+// without it.
+func (c *Client) Retry(ctx context.Context, sagaID uuid.UUID) error {
+	req, err := c.newRequest(ctx, http.MethodPost, "/sagas/"+sagaID.String()+"/retry", nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, nil)
+}
+
+// newRequest constructs an authenticated request to the path of the API.
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("saga api: new request: %w", err)
+	}
+	switch {
+	case c.apiKey != "":
+		req.Header.Set("X-API-Key", c.apiKey)
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return req, nil
+}
+
+// do sends the request and decodes the JSON response into out, if it is not nil.
+func (c *Client) do(req *http.Request, out any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("saga api: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error != "" {
+			apiErr.Message = body.Error
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return &apiErr
+	}
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("saga api: decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/client"
+)
+
+func TestClient(t *testing.T) {
+	sagaID := uuid.New()
+	created := time.Now().UTC().Truncate(time.Second)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "key1", r.Header.Get("X-API-Key"))
+		assert.Equal(t, sagaID.String(), r.FormValue("saga_id"))
+		assert.JSONEq(t, `{"order_id": 42}`, r.FormValue("payload"))
+		assert.Equal(t, "https://example.com/hook", r.FormValue("callback_url"))
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/sagas/"+sagaID.String(), func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id": sagaID, "status": "started", "service": "service1", "date_created": created, "date_updated": created,
+		})
+	})
+	mux.HandleFunc("/sagas/"+sagaID.String()+"/cancel", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error": "wrong saga status"}`))
+	})
+	mux.HandleFunc("/sagas/"+sagaID.String()+"/retry", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error": "Too Many Requests"}`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": "saga not found"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := client.New(server.URL+"/", client.WithAPIKey("key1"))
+	ctx := context.Background()
+
+	err := c.Start(ctx, client.StartRequest{
+		SagaID:      sagaID,
+		Payload:     json.RawMessage(`{"order_id": 42}`),
+		CallbackURL: "https://example.com/hook",
+	})
+	require.NoError(t, err)
+
+	s, err := c.Get(ctx, sagaID)
+	require.NoError(t, err)
+	assert.Equal(t, client.Saga{ID: sagaID, Status: "started", Service: "service1", DateCreated: created, DateUpdated: created}, s)
+
+	_, err = c.Get(ctx, uuid.New())
+	assert.ErrorIs(t, err, client.ErrNotFound)
+
+	err = c.Cancel(ctx, sagaID)
+	assert.ErrorIs(t, err, client.ErrConflict)
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "wrong saga status", apiErr.Message)
+
+	err = c.Retry(ctx, sagaID)
+	assert.ErrorIs(t, err, client.ErrTooManyRequests)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 3*time.Second, apiErr.RetryAfter)
+}
+
+func TestClient_Token(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token1", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	require.NoError(t, client.New(server.URL, client.WithToken("token1")).Retry(context.Background(), uuid.New()))
+}
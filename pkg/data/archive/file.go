@@ -0,0 +1,89 @@
+// Package archive exports finished sagas to compressed JSON-lines files.
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+// File appends sagas to a gzip compressed file as JSON lines.
+type File struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFile opens the file for appending sagas. The file is created if it doesn't exist.
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open archive file: %w", err)
+	}
+
+	return &File{file: f}, nil
+}
+
+// Write appends the sagas to the file as a gzip member and syncs the file, so that the sagas can be deleted
+// from the database afterwards. The sagas written before stay readable if a later write is interrupted.
+func (f *File) Write(sagas []database.ArchivedSaga) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	zw := gzip.NewWriter(f.file)
+	enc := json.NewEncoder(zw)
+	for _, saga := range sagas {
+		if err := enc.Encode(saga); err != nil {
+			return fmt.Errorf("write saga %s: %w", saga.ID, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("write archive file: %w", err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("sync archive file: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// ReadFile returns sagas from a file written by a File in order of their writing.
+func ReadFile(path string) ([]database.ArchivedSaga, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open archive file: %w", err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("read archive file: %w", err)
+	}
+	defer zr.Close()
+
+	var sagas []database.ArchivedSaga
+	dec := json.NewDecoder(zr)
+	for {
+		var saga database.ArchivedSaga
+		if err := dec.Decode(&saga); err != nil {
+			if errors.Is(err, io.EOF) {
+				return sagas, nil
+			}
+			return nil, fmt.Errorf("saga %d: %w", len(sagas)+1, err)
+		}
+		sagas = append(sagas, saga)
+	}
+}
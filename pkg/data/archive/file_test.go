@@ -0,0 +1,61 @@
+package archive_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/data/archive"
+	"github.com/illyasch/saga-service/pkg/data/audit"
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sagas.jsonl.gz")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	newSaga := func(status string) database.ArchivedSaga {
+		id := uuid.New()
+		return database.ArchivedSaga{
+			ID:          id,
+			Workflow:    "sample",
+			Status:      status,
+			Service:     "service3",
+			Payload:     []byte(`{"order":1}`),
+			DateCreated: now.Add(-time.Hour),
+			DateUpdated: now,
+			History: []audit.Event{
+				{SagaID: id, Workflow: "sample", Step: "service1", ToStatus: "started", TriggeredBy: "api", Time: now.Add(-time.Hour)},
+				{SagaID: id, Workflow: "sample", Step: "service3", FromStatus: "started", ToStatus: status, TriggeredBy: "service:service3", Time: now},
+			},
+		}
+	}
+	sagas := []database.ArchivedSaga{newSaga("completed"), newSaga("cancelled"), newSaga("compensated")}
+
+	file, err := archive.NewFile(path)
+	require.NoError(t, err)
+	require.NoError(t, file.Write(sagas[:2]))
+	require.NoError(t, file.Close())
+
+	// The file is appended by a new writer.
+	file, err = archive.NewFile(path)
+	require.NoError(t, err)
+	require.NoError(t, file.Write(sagas[2:]))
+	require.NoError(t, file.Close())
+
+	got, err := archive.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, sagas, got)
+
+	t.Run("not compressed", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("{}\n"), 0o600))
+
+		_, err := archive.ReadFile(path)
+		assert.Error(t, err)
+	})
+}
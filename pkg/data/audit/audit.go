@@ -0,0 +1,25 @@
+// Package audit provides an append-only record of saga state changes.
+package audit
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a record of one state change of a saga. Step is the service the saga moved to,
+// or the service which finished the saga. TriggeredBy names the actor that caused the change.
+// ID identifies the change, so sinks record a change delivered again only once.
+// Seq orders the changes of all sagas, it's assigned when the change is stored.
+type Event struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	Seq         uint64    `json:"seq,omitempty" db:"seq"`
+	SagaID      uuid.UUID `json:"saga_id" db:"saga_id"`
+	Workflow    string    `json:"workflow" db:"workflow"`
+	Step        string    `json:"step" db:"step"`
+	FromStatus  string    `json:"from_status,omitempty" db:"from_status"`
+	ToStatus    string    `json:"to_status" db:"to_status"`
+	TriggeredBy string    `json:"triggered_by" db:"triggered_by"`
+	RequestID   string    `json:"request_id,omitempty" db:"request_id"`
+	Time        time.Time `json:"time" db:"date_created"`
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// FileSink appends events to a file as JSON lines. An event delivered again is appended again
+// and ReadFile drops the copy.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens the file for appending events. The file is created if it doesn't exist.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+
+	return &FileSink{file: f}, nil
+}
+
+// Record appends the event to the file.
+func (s *FileSink) Record(_ context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("json marshal: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write audit file: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// ReadFile returns events of the saga from a file written by a FileSink in order of their recording.
+// Events recorded again are skipped.
+func ReadFile(path string, sagaID uuid.UUID) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	seen := make(map[uuid.UUID]bool)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if e.SagaID != sagaID {
+			continue
+		}
+		if e.ID != uuid.Nil {
+			if seen[e.ID] {
+				continue
+			}
+			seen[e.ID] = true
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit file: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,63 @@
+package audit_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/data/audit"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sagaID, otherID := uuid.New(), uuid.New()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	events := []audit.Event{
+		{ID: uuid.New(), SagaID: sagaID, Workflow: "sample", Step: "service1", ToStatus: "started", TriggeredBy: "api", RequestID: "r1", Time: now},
+		{ID: uuid.New(), SagaID: otherID, Workflow: "sample", Step: "service1", ToStatus: "started", TriggeredBy: "api", Time: now},
+		{ID: uuid.New(), SagaID: sagaID, Workflow: "sample", Step: "service2", FromStatus: "started", ToStatus: "started", TriggeredBy: "service:service1", Time: now},
+	}
+
+	sink, err := audit.NewFileSink(path)
+	require.NoError(t, err)
+	for _, e := range events[:2] {
+		require.NoError(t, sink.Record(context.Background(), e))
+	}
+	require.NoError(t, sink.Close())
+
+	// The file is appended by a new sink.
+	sink, err = audit.NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Record(context.Background(), events[2]))
+	require.NoError(t, sink.Close())
+
+	trail, err := audit.ReadFile(path, sagaID)
+	require.NoError(t, err)
+	assert.Equal(t, []audit.Event{events[0], events[2]}, trail)
+
+	t.Run("event delivered again", func(t *testing.T) {
+		// The outbox relay delivers the event again if its entry wasn't removed after the first delivery.
+		sink, err := audit.NewFileSink(path)
+		require.NoError(t, err)
+		require.NoError(t, sink.Record(context.Background(), events[0]))
+		require.NoError(t, sink.Close())
+
+		trail, err := audit.ReadFile(path, sagaID)
+		require.NoError(t, err)
+		assert.Equal(t, []audit.Event{events[0], events[2]}, trail)
+	})
+
+	t.Run("malformed file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("{}\nnot json\n"), 0o600))
+
+		_, err := audit.ReadFile(path, sagaID)
+		assert.ErrorContains(t, err, "line 2")
+	})
+}
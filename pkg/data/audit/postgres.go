@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// PostgresSink appends events to the saga_audit table. The table rejects updates and deletes.
+type PostgresSink struct {
+	db *sqlx.DB
+}
+
+// NewPostgresSink constructs a PostgresSink writing to the database.
+func NewPostgresSink(db *sqlx.DB) PostgresSink {
+	return PostgresSink{db: db}
+}
+
+// Record appends the event to the table. An event recorded already is skipped.
+func (s PostgresSink) Record(ctx context.Context, e Event) error {
+	const query = `INSERT INTO saga_audit(entry_id, saga_id, workflow, step, from_status, to_status, triggered_by, request_id, date_created)
+					VALUES (:id, :saga_id, :workflow, :step, :from_status, :to_status, :triggered_by, :request_id, :date_created)
+					ON CONFLICT (entry_id) DO NOTHING`
+
+	if _, err := s.db.NamedExecContext(ctx, query, e); err != nil {
+		return fmt.Errorf("query %s: %w", query, err)
+	}
+
+	return nil
+}
+
+// Trail returns events of the saga in order of their recording.
+func (s PostgresSink) Trail(ctx context.Context, sagaID uuid.UUID) ([]Event, error) {
+	const query = `SELECT COALESCE(entry_id, '00000000-0000-0000-0000-000000000000') AS id, saga_id, workflow, step,
+						from_status, to_status, triggered_by, request_id, date_created
+					FROM saga_audit WHERE saga_id = $1 ORDER BY saga_audit.id`
+
+	var events []Event
+	if err := s.db.SelectContext(ctx, &events, query, sagaID); err != nil {
+		return nil, fmt.Errorf("query %s: %w", query, err)
+	}
+
+	return events, nil
+}
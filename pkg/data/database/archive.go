@@ -0,0 +1,179 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/illyasch/saga-service/pkg/data/audit"
+)
+
+// ArchiveFilter selects finished sagas in the status which were not updated for the given duration.
+type ArchiveFilter struct {
+	Status string
+	// Workflow selects sagas of the workflow, sagas of all workflows are selected if it's empty.
+	Workflow string
+	// ExceptWorkflows excludes sagas of the workflows, e.g. the ones with their own retention.
+	ExceptWorkflows []string
+	OlderThan       time.Duration
+}
+
+// ArchivedSaga is a finished saga with its audit trail. The payload is kept as stored, so it's
+// encrypted if the storage encrypts payloads.
+type ArchivedSaga struct {
+	ID          uuid.UUID     `json:"id" db:"id"`
+	Workflow    string        `json:"workflow" db:"workflow"`
+	Status      string        `json:"status" db:"status"`
+	Service     string        `json:"service" db:"service"`
+	Payload     []byte        `json:"payload,omitempty" db:"payload"`
+	DateCreated time.Time     `json:"date_created" db:"date_created"`
+	DateUpdated time.Time     `json:"date_updated" db:"date_updated"`
+	History     []audit.Event `json:"history" db:"-"`
+}
+
+// ArchiveSagas moves up to limit sagas matching the filter, the least recently updated first, with their
+// audit trail to the archive tables and deletes their webhooks in one statement. Sagas locked by other
+// transactions are skipped. It returns the number of archived sagas.
+func (s Storage) ArchiveSagas(ctx context.Context, f ArchiveFilter, limit int) (int, error) {
+	defer observe("archive_sagas", time.Now())
+
+	where, args := archiveWhere(f)
+	args = append(args, limit)
+	query := `WITH moved AS (
+			DELETE FROM sagas WHERE id IN (
+				SELECT id FROM sagas WHERE ` + where + `
+				ORDER BY COALESCE(date_updated, date_created) LIMIT $` + fmt.Sprint(len(args)) + ` FOR UPDATE SKIP LOCKED)
+			RETURNING id, status, service, workflow, payload, date_created, date_updated
+		), archived AS (
+			INSERT INTO sagas_archive (id, status, service, workflow, payload, date_created, date_updated, date_archived)
+			SELECT id, status::TEXT, service, workflow, payload, date_created, date_updated, NOW() FROM moved
+		), hooks AS (
+			DELETE FROM webhooks WHERE saga_id IN (SELECT id FROM moved)
+		), trail AS (
+			DELETE FROM saga_audit WHERE saga_id IN (SELECT id FROM moved)
+			RETURNING id, entry_id, saga_id, workflow, step, from_status, to_status, triggered_by, request_id, date_created
+		), archived_trail AS (
+			INSERT INTO saga_audit_archive (id, entry_id, saga_id, workflow, step, from_status, to_status, triggered_by, request_id,
+				date_created)
+			SELECT id, entry_id, saga_id, workflow, step, from_status, to_status, triggered_by, request_id, date_created FROM trail
+		)
+		SELECT COUNT(*) FROM moved`
+
+	var archived int
+	err := s.archiving(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &archived, query, args...); err != nil {
+			return fmt.Errorf("query %s: %w", query, err)
+		}
+		return nil
+	})
+
+	return archived, err
+}
+
+// ExportSagas passes up to limit sagas matching the filter, the least recently updated first, with their audit
+// trail to export and deletes them with their audit trail and webhooks when it succeeds. The sagas stay locked
+// until they're deleted, so export has to be short. It returns the number of exported sagas.
+func (s Storage) ExportSagas(ctx context.Context, f ArchiveFilter, limit int, export func([]ArchivedSaga) error) (int, error) {
+	defer observe("export_sagas", time.Now())
+
+	where, args := archiveWhere(f)
+	args = append(args, limit)
+	selectQuery := `SELECT id, status, COALESCE(service, '') AS service, workflow, payload, date_created,
+					COALESCE(date_updated, date_created) AS date_updated
+				FROM sagas WHERE ` + where + `
+				ORDER BY COALESCE(date_updated, date_created) LIMIT $` + fmt.Sprint(len(args)) + ` FOR UPDATE SKIP LOCKED`
+	const trailQuery = `SELECT COALESCE(entry_id, '00000000-0000-0000-0000-000000000000') AS id, saga_id, workflow, step,
+						from_status, to_status, triggered_by, request_id, date_created
+					FROM saga_audit WHERE saga_id = ANY($1::UUID[]) ORDER BY saga_audit.id`
+	const deleteQuery = `WITH deleted AS (
+			DELETE FROM sagas WHERE id = ANY($1::UUID[]) RETURNING id
+		), hooks AS (
+			DELETE FROM webhooks WHERE saga_id IN (SELECT id FROM deleted)
+		)
+		DELETE FROM saga_audit WHERE saga_id IN (SELECT id FROM deleted)`
+
+	var sagas []ArchivedSaga
+	err := s.archiving(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.SelectContext(ctx, &sagas, selectQuery, args...); err != nil {
+			return fmt.Errorf("query %s: %w", selectQuery, err)
+		}
+		if len(sagas) == 0 {
+			return nil
+		}
+
+		ids := make(pq.StringArray, 0, len(sagas))
+		index := make(map[uuid.UUID]int, len(sagas))
+		for i, saga := range sagas {
+			ids = append(ids, saga.ID.String())
+			index[saga.ID] = i
+		}
+
+		var events []audit.Event
+		if err := tx.SelectContext(ctx, &events, trailQuery, ids); err != nil {
+			return fmt.Errorf("query %s: %w", trailQuery, err)
+		}
+		for _, e := range events {
+			sagas[index[e.SagaID]].History = append(sagas[index[e.SagaID]].History, e)
+		}
+
+		if err := export(sagas); err != nil {
+			return fmt.Errorf("export sagas: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, deleteQuery, ids); err != nil {
+			return fmt.Errorf("query %s: %w", deleteQuery, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sagas), nil
+}
+
+// archiving runs the function in a transaction which is allowed to delete the audit trail.
+func (s Storage) archiving(ctx context.Context, fn func(*sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tran: %w", err)
+	}
+
+	// The trigger of the audit tables rejects deletes unless the setting is on.
+	const query = `SET LOCAL saga.archiving = 'on'`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("query %s: %w", query, err)
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tran: %w", err)
+	}
+
+	return nil
+}
+
+// archiveWhere returns the condition of the filter and its arguments.
+func archiveWhere(f ArchiveFilter) (string, []any) {
+	args := []any{f.Status, f.OlderThan.Milliseconds()}
+	where := `status::TEXT = $1 AND COALESCE(date_updated, date_created) < NOW() - $2 * INTERVAL '1 millisecond'`
+	if f.Workflow != "" {
+		args = append(args, f.Workflow)
+		where += fmt.Sprintf(" AND workflow = $%d", len(args))
+	}
+	if len(f.ExceptWorkflows) > 0 {
+		args = append(args, pq.Array(f.ExceptWorkflows))
+		where += fmt.Sprintf(" AND workflow <> ALL($%d)", len(args))
+	}
+
+	return where, args
+}
@@ -17,12 +17,19 @@ import (
 
 // lib/pq errorCodeNames
 // https://github.com/lib/pq/blob/master/error.go#L178
-const uniqueViolation = "23505"
+const (
+	uniqueViolation = "23505"
+	checkViolation  = "23514"
+)
 
 // Set of error variables for CRUD operations.
 var (
 	ErrDBNotFound        = errors.New("not found")
 	ErrDBDuplicatedEntry = errors.New("duplicated entry")
+	// ErrDBIllegalTransition is returned when the database rejects a status of a saga it can't move to.
+	ErrDBIllegalTransition = errors.New("illegal status transition")
+	// ErrDBLimitExceeded is returned when a saga isn't stored as too many sagas are in its status.
+	ErrDBLimitExceeded = errors.New("limit exceeded")
 )
 
 // Config is the required properties to use the database.
@@ -38,6 +45,18 @@ type Config struct {
 
 // Open knows how to open a database connection based on the configuration.
 func Open(cfg Config) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", ConnString(cfg))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+
+	return db, nil
+}
+
+// ConnString returns the connection string of the database based on the configuration.
+func ConnString(cfg Config) string {
 	sslMode := "require"
 	if cfg.DisableTLS {
 		sslMode = "disable"
@@ -55,14 +74,7 @@ func Open(cfg Config) (*sqlx.DB, error) {
 		RawQuery: q.Encode(),
 	}
 
-	db, err := sqlx.Open("postgres", u.String())
-	if err != nil {
-		return nil, err
-	}
-	db.SetMaxIdleConns(cfg.MaxIdleConns)
-	db.SetMaxOpenConns(cfg.MaxOpenConns)
-
-	return db, nil
+	return u.String()
 }
 
 // StatusCheck returns nil if it can successfully talk to the database. It
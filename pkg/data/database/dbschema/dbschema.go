@@ -2,9 +2,12 @@
 package dbschema
 
 import (
+	"bufio"
 	"context"
 	_ "embed" // Calls init function.
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/ardanlabs/darwin"
 	"github.com/jmoiron/sqlx"
@@ -35,10 +38,58 @@ func Migrate(ctx context.Context, db *sqlx.DB) error {
 		return fmt.Errorf("construct darwin driver: %w", err)
 	}
 
-	d := darwin.New(driver, darwin.ParseMigrations(schemaDoc))
+	if err := repairChecksums(ctx, db); err != nil {
+		return fmt.Errorf("repair checksums: %w", err)
+	}
+
+	d := darwin.New(driver, Migrations())
 	return d.Migrate()
 }
 
+// Migrations returns the migrations of the schema.
+func Migrations() []darwin.Migration {
+	return parseMigrations(schemaDoc)
+}
+
+// parseMigrations splits the document into migrations like darwin.ParseMigrations, which panics on lines
+// of 5 characters like BEGIN of function bodies. The scripts are kept byte for byte, so their checksums
+// match the ones recorded by darwin.
+func parseMigrations(doc string) []darwin.Migration {
+	var migrations []darwin.Migration
+	var migration darwin.Migration
+	var script strings.Builder
+
+	scanner := bufio.NewScanner(strings.NewReader(doc))
+	for scanner.Scan() {
+		line := scanner.Text()
+		lower := strings.ToLower(line)
+
+		switch {
+		case strings.HasPrefix(lower, "-- ver") || strings.HasPrefix(lower, "--ver"):
+			migration.Script = script.String()
+			migrations = append(migrations, migration)
+			migration = darwin.Migration{}
+			script.Reset()
+
+			version, err := strconv.ParseFloat(strings.TrimSpace(line[strings.Index(line, ":")+1:]), 64)
+			if err != nil {
+				return nil
+			}
+			migration.Version = version
+
+		case strings.HasPrefix(lower, "-- des") || strings.HasPrefix(lower, "--des"):
+			migration.Description = strings.TrimSpace(line[strings.Index(line, ":")+1:])
+
+		default:
+			script.WriteString(line + "\n")
+		}
+	}
+	migration.Script = script.String()
+	migrations = append(migrations, migration)
+
+	return migrations[1:]
+}
+
 // Seed runs the set of seed-data queries against db. The queries are run in a
 // transaction and rolled back if any fail.
 func Seed(ctx context.Context, db *sqlx.DB) error {
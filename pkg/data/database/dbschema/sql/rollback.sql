@@ -0,0 +1,74 @@
+-- Version: 1.1
+-- Description: Drop type SAGA_STATUS
+DROP TYPE SAGA_STATUS;
+
+-- Version: 1.2
+-- Description: Drop table sagas
+DROP TABLE sagas;
+
+-- Version: 1.3
+-- Description: Remove payload from sagas
+ALTER TABLE sagas DROP COLUMN payload;
+
+-- Version: 1.4
+-- Description: Remove date of the last update from sagas
+ALTER TABLE sagas DROP COLUMN date_updated;
+
+-- Version: 1.5
+-- Description: Drop table saga_audit
+DROP TRIGGER saga_audit_immutable ON saga_audit;
+DROP FUNCTION saga_audit_immutable();
+DROP TABLE saga_audit;
+
+-- Version: 1.6
+-- Description: Remove terminal statuses compensated and cancelled, fails if sagas have them
+ALTER TYPE SAGA_STATUS RENAME TO saga_status_old;
+CREATE TYPE SAGA_STATUS AS ENUM ('started', 'completed', 'error');
+ALTER TABLE sagas ALTER COLUMN status TYPE SAGA_STATUS USING status::TEXT::SAGA_STATUS;
+DROP TYPE saga_status_old;
+
+-- Version: 1.7
+-- Description: Drop table webhooks
+DROP TABLE webhooks;
+
+-- Version: 1.8
+-- Description: Drop index of sagas by status and date of the last update
+DROP INDEX sagas_status_date_updated_idx;
+
+-- Version: 1.9
+-- Description: Remove workflow from sagas and drop archive tables of finished sagas
+DROP TABLE saga_audit_archive;
+DROP TABLE sagas_archive;
+CREATE OR REPLACE FUNCTION saga_audit_immutable() RETURNS TRIGGER AS $$
+    BEGIN
+        RAISE EXCEPTION 'saga_audit is append-only';
+    END;
+$$ LANGUAGE plpgsql;
+ALTER TABLE sagas DROP COLUMN workflow;
+
+-- Version: 2.1
+-- Description: Remove version of sagas
+ALTER TABLE sagas DROP COLUMN version;
+
+-- Version: 2.2
+-- Description: Allow any transition of saga statuses
+DROP TRIGGER saga_status_transition ON sagas;
+DROP FUNCTION saga_status_transition();
+
+-- Version: 2.3
+-- Description: Drop table saga_outbox
+DROP TABLE saga_outbox;
+
+-- Version: 2.4
+-- Description: Remove number of retries from sagas
+ALTER TABLE sagas DROP COLUMN retries;
+
+-- Version: 2.5
+-- Description: Remove sequence numbers of saga state changes from saga_outbox
+ALTER TABLE saga_outbox DROP COLUMN seq;
+
+-- Version: 2.6
+-- Description: Remove IDs of recorded state changes from saga_audit
+ALTER TABLE saga_audit_archive DROP COLUMN entry_id;
+DROP INDEX saga_audit_entry_id_idx;
+ALTER TABLE saga_audit DROP COLUMN entry_id;
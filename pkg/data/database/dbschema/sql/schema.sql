@@ -10,3 +10,156 @@ CREATE TABLE sagas (
     service TEXT,
     date_created  TIMESTAMP
 );
+
+-- Version: 1.3
+-- Description: Add payload to sagas
+ALTER TABLE sagas ADD COLUMN payload BYTEA;
+
+-- Version: 1.4
+-- Description: Add date of the last update to sagas
+ALTER TABLE sagas ADD COLUMN date_updated TIMESTAMP;
+UPDATE sagas SET date_updated = date_created;
+
+-- Version: 1.5
+-- Description: Create append-only table saga_audit
+CREATE TABLE saga_audit (
+    id BIGSERIAL PRIMARY KEY,
+    saga_id UUID NOT NULL,
+    workflow TEXT NOT NULL,
+    step TEXT NOT NULL,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    triggered_by TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    date_created TIMESTAMP NOT NULL
+);
+CREATE INDEX saga_audit_saga_id_idx ON saga_audit (saga_id, id);
+CREATE FUNCTION saga_audit_immutable() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'saga_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER saga_audit_immutable BEFORE UPDATE OR DELETE ON saga_audit
+    FOR EACH ROW EXECUTE PROCEDURE saga_audit_immutable();
+
+-- Version: 1.6
+-- Description: Add terminal statuses compensated and cancelled
+ALTER TYPE SAGA_STATUS ADD VALUE 'compensated';
+ALTER TYPE SAGA_STATUS ADD VALUE 'cancelled';
+
+-- Version: 1.7
+-- Description: Create table webhooks with deliveries of saga outcomes
+CREATE TABLE webhooks (
+    saga_id UUID PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    state TEXT NOT NULL,
+    saga_status TEXT NOT NULL DEFAULT '',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    date_created TIMESTAMP NOT NULL,
+    date_delivered TIMESTAMP
+);
+CREATE INDEX webhooks_pending_idx ON webhooks (next_attempt) WHERE state = 'pending';
+
+-- Version: 1.8
+-- Description: Add index of sagas by status and date of the last update
+CREATE INDEX sagas_status_date_updated_idx ON sagas (status, (COALESCE(date_updated, date_created)));
+
+-- Version: 1.9
+-- Description: Add workflow to sagas and create archive tables of finished sagas
+ALTER TABLE sagas ADD COLUMN workflow TEXT NOT NULL DEFAULT '';
+UPDATE sagas SET workflow = trail.workflow
+    FROM (SELECT DISTINCT ON (saga_id) saga_id, workflow FROM saga_audit ORDER BY saga_id, id) trail
+    WHERE trail.saga_id = sagas.id;
+CREATE TABLE sagas_archive (
+    id UUID PRIMARY KEY,
+    status TEXT NOT NULL,
+    service TEXT,
+    workflow TEXT NOT NULL,
+    payload BYTEA,
+    date_created TIMESTAMP,
+    date_updated TIMESTAMP,
+    date_archived TIMESTAMP NOT NULL
+);
+CREATE TABLE saga_audit_archive (
+    id BIGINT PRIMARY KEY,
+    saga_id UUID NOT NULL,
+    workflow TEXT NOT NULL,
+    step TEXT NOT NULL,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    triggered_by TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    date_created TIMESTAMP NOT NULL
+);
+CREATE INDEX saga_audit_archive_saga_id_idx ON saga_audit_archive (saga_id, id);
+CREATE OR REPLACE FUNCTION saga_audit_immutable() RETURNS TRIGGER AS $$
+    BEGIN
+        IF TG_OP = 'DELETE' AND current_setting('saga.archiving', true) = 'on' THEN
+            RETURN OLD;
+        END IF;
+        RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+    END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER saga_audit_archive_immutable BEFORE UPDATE OR DELETE ON saga_audit_archive
+    FOR EACH ROW EXECUTE PROCEDURE saga_audit_immutable();
+
+-- Version: 2.1
+-- Description: Add version of sagas for optimistic concurrency
+ALTER TABLE sagas ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+-- Version: 2.2
+-- Description: Reject illegal transitions of saga statuses
+CREATE FUNCTION saga_status_transition() RETURNS TRIGGER AS $$
+    BEGIN
+        IF TG_OP = 'INSERT' THEN
+            IF NEW.status = 'started' THEN
+                RETURN NEW;
+            END IF;
+            RAISE EXCEPTION 'saga % can''t be inserted as %', NEW.id, NEW.status
+                USING ERRCODE = 'check_violation';
+        END IF;
+        IF NEW.status = OLD.status
+            OR (OLD.status = 'started' AND NEW.status IN ('completed', 'error', 'compensated', 'cancelled'))
+            OR (OLD.status = 'error' AND NEW.status IN ('started', 'compensated')) THEN
+            RETURN NEW;
+        END IF;
+        RAISE EXCEPTION 'saga % can''t move from % to %', NEW.id, OLD.status, NEW.status
+            USING ERRCODE = 'check_violation';
+    END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER saga_status_transition BEFORE INSERT OR UPDATE OF status ON sagas
+    FOR EACH ROW EXECUTE PROCEDURE saga_status_transition();
+
+-- Version: 2.3
+-- Description: Create table saga_outbox with state changes waiting for delivery
+CREATE TABLE saga_outbox (
+    id UUID PRIMARY KEY,
+    saga_id UUID NOT NULL,
+    workflow TEXT NOT NULL,
+    step TEXT NOT NULL,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    triggered_by TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    date_created TIMESTAMP NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMP NOT NULL
+);
+CREATE INDEX saga_outbox_next_attempt_idx ON saga_outbox (next_attempt);
+
+-- Version: 2.4
+-- Description: Add number of retries to sagas
+ALTER TABLE sagas ADD COLUMN retries INT NOT NULL DEFAULT 0;
+
+-- Version: 2.5
+-- Description: Add sequence numbers of saga state changes to saga_outbox
+ALTER TABLE saga_outbox ADD COLUMN seq BIGSERIAL;
+
+-- Version: 2.6
+-- Description: Add IDs of recorded state changes to saga_audit, so a change delivered again is recorded once
+ALTER TABLE saga_audit ADD COLUMN entry_id UUID;
+CREATE UNIQUE INDEX saga_audit_entry_id_idx ON saga_audit (entry_id);
+ALTER TABLE saga_audit_archive ADD COLUMN entry_id UUID;
@@ -0,0 +1,318 @@
+package dbschema
+
+import (
+	"context"
+	_ "embed" // Calls init function.
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ardanlabs/darwin"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/illyasch/saga-service/pkg/data/database"
+)
+
+//go:embed sql/rollback.sql
+var rollbackDoc string
+
+// Set of states of migrations.
+const (
+	StateApplied = "applied"
+	StatePending = "pending"
+	// StateIgnored is a migration older than the last applied one which isn't applied. Migrate doesn't apply it.
+	StateIgnored = "ignored"
+	// StateChanged is an applied migration whose script was changed after it was applied.
+	StateChanged = "changed"
+	// StateUnknown is an applied migration missing in the binary, the database schema is ahead of the binary.
+	StateUnknown = "unknown"
+)
+
+var (
+	// ErrSchemaBehind is returned when migrations of the binary aren't applied to the database.
+	ErrSchemaBehind = errors.New("database schema is behind the binary")
+
+	// ErrSchemaChanged is returned when scripts of applied migrations differ from the binary.
+	ErrSchemaChanged = errors.New("applied migrations differ from the binary")
+)
+
+// MigrationStatus is the state of a migration in the database.
+type MigrationStatus struct {
+	Version     float64    `json:"version"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+// Status returns the states of the migrations of the binary and of the migrations applied to the database.
+func Status(ctx context.Context, db *sqlx.DB) ([]MigrationStatus, error) {
+	applied, err := records(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return Statuses(applied), nil
+}
+
+// Statuses returns the states of the migrations given the records of the applied ones, ordered by version.
+// Checksums of applied migrations are compared with the scripts of the binary.
+func Statuses(applied []darwin.MigrationRecord) []MigrationStatus {
+	byVersion := make(map[float64]darwin.MigrationRecord, len(applied))
+	var last float64
+	for _, record := range applied {
+		byVersion[record.Version] = record
+		if record.Version > last {
+			last = record.Version
+		}
+	}
+
+	var statuses []MigrationStatus
+	known := make(map[float64]bool)
+	for _, migration := range Migrations() {
+		known[migration.Version] = true
+
+		status := MigrationStatus{Version: migration.Version, Description: migration.Description, State: StatePending}
+		record, ok := byVersion[migration.Version]
+		switch {
+		case ok && !checksumMatches(record.Checksum, migration):
+			status.State = StateChanged
+		case ok:
+			status.State = StateApplied
+		case migration.Version < last:
+			status.State = StateIgnored
+		}
+		if ok {
+			appliedAt := record.AppliedAt.UTC()
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, record := range applied {
+		if known[record.Version] {
+			continue
+		}
+		appliedAt := record.AppliedAt.UTC()
+		statuses = append(statuses, MigrationStatus{
+			Version:     record.Version,
+			Description: record.Description,
+			State:       StateUnknown,
+			AppliedAt:   &appliedAt,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses
+}
+
+// Check returns an error if the database schema is behind the binary or applied migrations differ from it.
+// A schema ahead of the binary is accepted, so that the previous version keeps running while a new one is deployed.
+func Check(ctx context.Context, db *sqlx.DB) error {
+	statuses, err := Status(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	return CheckStatuses(statuses)
+}
+
+// CheckStatuses returns an error if any migration of the binary isn't applied or differs from the applied one.
+func CheckStatuses(statuses []MigrationStatus) error {
+	var behind, changed []string
+	for _, status := range statuses {
+		switch status.State {
+		case StatePending, StateIgnored:
+			behind = append(behind, fmt.Sprintf("%g", status.Version))
+		case StateChanged:
+			changed = append(changed, fmt.Sprintf("%g", status.Version))
+		}
+	}
+
+	if len(behind) > 0 {
+		return fmt.Errorf("%w: migrations %s aren't applied", ErrSchemaBehind, strings.Join(behind, ", "))
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("%w: migrations %s", ErrSchemaChanged, strings.Join(changed, ", "))
+	}
+
+	return nil
+}
+
+// Pending returns the migrations Migrate would apply, ordered by version.
+func Pending(ctx context.Context, db *sqlx.DB) ([]darwin.Migration, error) {
+	applied, err := records(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	last := -1.0
+	for _, record := range applied {
+		if record.Version > last {
+			last = record.Version
+		}
+	}
+
+	var pending []darwin.Migration
+	for _, migration := range Migrations() {
+		if migration.Version > last {
+			pending = append(pending, migration)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	return pending, nil
+}
+
+// Rollbacks returns the down migrations reverting the applied migrations newer than the version, the newest first.
+func Rollbacks(ctx context.Context, db *sqlx.DB, version float64) ([]darwin.Migration, error) {
+	applied, err := records(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	downs := make(map[float64]darwin.Migration)
+	for _, migration := range parseMigrations(rollbackDoc) {
+		downs[migration.Version] = migration
+	}
+
+	sort.Slice(applied, func(i, j int) bool { return applied[i].Version > applied[j].Version })
+	var rollbacks []darwin.Migration
+	for _, record := range applied {
+		if record.Version <= version {
+			break
+		}
+		down, ok := downs[record.Version]
+		if !ok {
+			return nil, fmt.Errorf("migration %g has no down migration", record.Version)
+		}
+		rollbacks = append(rollbacks, down)
+	}
+
+	return rollbacks, nil
+}
+
+// Down reverts the applied migrations newer than the version and returns the applied down migrations.
+// Every down migration is applied in a transaction with the removal of the record of its migration.
+func Down(ctx context.Context, db *sqlx.DB, version float64) ([]darwin.Migration, error) {
+	if err := database.StatusCheck(ctx, db); err != nil {
+		return nil, fmt.Errorf("status check database: %w", err)
+	}
+
+	rollbacks, err := Rollbacks(ctx, db, version)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `DELETE FROM darwin_migrations WHERE version = $1::REAL`
+	for i, down := range rollbacks {
+		tx, err := db.BeginTxx(ctx, nil)
+		if err != nil {
+			return rollbacks[:i], fmt.Errorf("begin tran: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, down.Script); err != nil {
+			_ = tx.Rollback()
+			return rollbacks[:i], fmt.Errorf("roll back migration %g: %w", down.Version, err)
+		}
+		if _, err := tx.ExecContext(ctx, q, down.Version); err != nil {
+			_ = tx.Rollback()
+			return rollbacks[:i], fmt.Errorf("query %s: %w", q, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return rollbacks[:i], fmt.Errorf("commit tran: %w", err)
+		}
+	}
+
+	return rollbacks, nil
+}
+
+// ValidateRollbacks returns an error if a migration has no down migration or a down migration has no migration.
+func ValidateRollbacks() error {
+	ups := make(map[float64]bool)
+	for _, migration := range Migrations() {
+		ups[migration.Version] = true
+	}
+
+	downs := make(map[float64]bool)
+	for _, down := range parseMigrations(rollbackDoc) {
+		if !ups[down.Version] {
+			return fmt.Errorf("down migration %g has no migration", down.Version)
+		}
+		downs[down.Version] = true
+	}
+
+	for version := range ups {
+		if !downs[version] {
+			return fmt.Errorf("migration %g has no down migration", version)
+		}
+	}
+
+	return nil
+}
+
+// checksumMatches reports whether the checksum recorded by darwin is the one of the migration. Darwin keeps the blank
+// line separating a migration from the next one in its script, so a migration applied while it was the last one has
+// the checksum of the script without the trailing blank line.
+func checksumMatches(checksum string, migration darwin.Migration) bool {
+	script := strings.TrimRight(migration.Script, "\n") + "\n"
+	for _, s := range []string{migration.Script, script, script + "\n"} {
+		if checksum == (darwin.Migration{Script: s}).Checksum() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// repairChecksums replaces the recorded checksums of applied migrations which differ from the binary only in
+// the trailing blank line, so that darwin doesn't reject them as changed.
+func repairChecksums(ctx context.Context, db *sqlx.DB) error {
+	applied, err := records(ctx, db)
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[float64]darwin.MigrationRecord, len(applied))
+	for _, record := range applied {
+		byVersion[record.Version] = record
+	}
+
+	const q = `UPDATE darwin_migrations SET checksum = $1 WHERE version = $2::REAL`
+	for _, migration := range Migrations() {
+		record, ok := byVersion[migration.Version]
+		if !ok || record.Checksum == migration.Checksum() || !checksumMatches(record.Checksum, migration) {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, q, migration.Checksum(), migration.Version); err != nil {
+			return fmt.Errorf("query %s: %w", q, err)
+		}
+	}
+
+	return nil
+}
+
+// records returns the applied migrations, none if no migration was applied.
+func records(ctx context.Context, db *sqlx.DB) ([]darwin.MigrationRecord, error) {
+	const q = `SELECT to_regclass('darwin_migrations') IS NOT NULL`
+	var exists bool
+	if err := db.GetContext(ctx, &exists, q); err != nil {
+		return nil, fmt.Errorf("query %s: %w", q, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	driver, err := darwin.NewGenericDriver(db.DB, darwin.PostgresDialect{})
+	if err != nil {
+		return nil, fmt.Errorf("construct darwin driver: %w", err)
+	}
+
+	applied, err := driver.All()
+	if err != nil {
+		return nil, fmt.Errorf("query migrations: %w", err)
+	}
+
+	return applied, nil
+}
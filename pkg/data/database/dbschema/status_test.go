@@ -0,0 +1,122 @@
+package dbschema_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/darwin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/data/database/dbschema"
+)
+
+func TestValidateRollbacks(t *testing.T) {
+	require.NoError(t, dbschema.ValidateRollbacks())
+}
+
+// TestMigrationsUnchanged fails when a migration is changed after it was released, which makes darwin reject databases
+// it was applied to. Add the checksum of a new migration here. The checksums are of the scripts without the blank lines
+// separating them from the next migration.
+func TestMigrationsUnchanged(t *testing.T) {
+	checksums := map[float64]string{
+		1.1: "1bfb697e2f7d20fbf2a913caf55ee618",
+		1.2: "d77f05a609715a74bb972050f3120507",
+		1.3: "b365436cc0821b75d8cfef0b822ab2ce",
+		1.4: "a84e2bdd6b93cb65c26dcc31c09ccbb6",
+		1.5: "a8f0c1f818b2ad824f9e10fc43594d43",
+		1.6: "553d552ef56c2cb0935ecc4416b22666",
+		1.7: "5366d94d210ba1097c58b29858c19364",
+		1.8: "80bfc44d7755bed16c2b728294165983",
+		1.9: "a7132c416a3688ab3af9cdbd07316c51",
+		2.1: "053865e36c4fbdc75343c5bdef4feb12",
+		2.2: "9d49a6b576d251b8e82536e8b655d43f",
+		2.3: "4bdd0b969ac4c37b4dbbb8ff003acce7",
+		2.4: "45f9c377dea357ed88c548d9114e8bb6",
+		2.5: "cfc1462d6532fe2ae610fe394325cf1b",
+		2.6: "f3c98b84ccdaecffbf4d828647111f16",
+	}
+
+	migrations := dbschema.Migrations()
+	require.Equal(t, len(checksums), len(migrations), "checksums of migrations")
+	for _, m := range migrations {
+		script := darwin.Migration{Script: strings.TrimRight(m.Script, "\n") + "\n"}
+		assert.Equal(t, checksums[m.Version], script.Checksum(), "migration %g", m.Version)
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	migrations := dbschema.Migrations()
+	require.Greater(t, len(migrations), 3)
+
+	appliedAt := time.Date(2022, 7, 1, 10, 0, 0, 0, time.UTC)
+	record := func(m darwin.Migration) darwin.MigrationRecord {
+		return darwin.MigrationRecord{Version: m.Version, Description: m.Description, Checksum: m.Checksum(), AppliedAt: appliedAt}
+	}
+	var all []darwin.MigrationRecord
+	for _, m := range migrations {
+		all = append(all, record(m))
+	}
+	states := func(statuses []dbschema.MigrationStatus) map[float64]string {
+		got := make(map[float64]string)
+		for _, s := range statuses {
+			got[s.Version] = s.State
+		}
+		return got
+	}
+
+	t.Run("up to date", func(t *testing.T) {
+		statuses := dbschema.Statuses(all)
+		require.Len(t, statuses, len(migrations))
+		for _, s := range statuses {
+			assert.Equal(t, dbschema.StateApplied, s.State)
+			require.NotNil(t, s.AppliedAt)
+			assert.Equal(t, appliedAt, *s.AppliedAt)
+		}
+		assert.NoError(t, dbschema.CheckStatuses(statuses))
+	})
+
+	t.Run("empty database", func(t *testing.T) {
+		statuses := dbschema.Statuses(nil)
+		for _, s := range statuses {
+			assert.Equal(t, dbschema.StatePending, s.State)
+			assert.Nil(t, s.AppliedAt)
+		}
+		assert.ErrorIs(t, dbschema.CheckStatuses(statuses), dbschema.ErrSchemaBehind)
+	})
+
+	t.Run("behind", func(t *testing.T) {
+		applied := append([]darwin.MigrationRecord{all[0]}, all[2:len(all)-1]...)
+		got := states(dbschema.Statuses(applied))
+		assert.Equal(t, dbschema.StateApplied, got[migrations[0].Version])
+		assert.Equal(t, dbschema.StateIgnored, got[migrations[1].Version])
+		assert.Equal(t, dbschema.StatePending, got[migrations[len(migrations)-1].Version])
+		assert.ErrorIs(t, dbschema.CheckStatuses(dbschema.Statuses(applied)), dbschema.ErrSchemaBehind)
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		applied := append([]darwin.MigrationRecord{}, all...)
+		applied[1].Checksum = "0123"
+		assert.Equal(t, dbschema.StateChanged, states(dbschema.Statuses(applied))[migrations[1].Version])
+		assert.ErrorIs(t, dbschema.CheckStatuses(dbschema.Statuses(applied)), dbschema.ErrSchemaChanged)
+	})
+
+	t.Run("applied as the last migration", func(t *testing.T) {
+		applied := append([]darwin.MigrationRecord{}, all...)
+		script := strings.TrimRight(migrations[1].Script, "\n") + "\n"
+		require.NotEqual(t, script, migrations[1].Script)
+		applied[1].Checksum = darwin.Migration{Script: script}.Checksum()
+		assert.Equal(t, dbschema.StateApplied, states(dbschema.Statuses(applied))[migrations[1].Version])
+		assert.NoError(t, dbschema.CheckStatuses(dbschema.Statuses(applied)))
+	})
+
+	t.Run("ahead", func(t *testing.T) {
+		applied := append(append([]darwin.MigrationRecord{}, all...), darwin.MigrationRecord{Version: 99.1, Description: "future", AppliedAt: appliedAt})
+		statuses := dbschema.Statuses(applied)
+		last := statuses[len(statuses)-1]
+		assert.Equal(t, 99.1, last.Version)
+		assert.Equal(t, dbschema.StateUnknown, last.State)
+		assert.NoError(t, dbschema.CheckStatuses(statuses))
+	})
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// queryDuration measures latency of the Storage queries.
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "saga",
+	Subsystem: "db",
+	Name:      "query_duration_seconds",
+	Help:      "Latency of database queries.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"query"})
+
+// observe records the latency of the query started at the given time.
+func observe(query string, started time.Time) {
+	queryDuration.WithLabelValues(query).Observe(time.Since(started).Seconds())
+}
+
+// StatusCollector is a prometheus collector of the number of sagas per status.
+// The sagas are counted in the database on every scrape.
+type StatusCollector struct {
+	storage Storage
+	desc    *prometheus.Desc
+}
+
+// NewStatusCollector constructs a StatusCollector counting sagas in the storage.
+func NewStatusCollector(storage Storage) StatusCollector {
+	return StatusCollector{
+		storage: storage,
+		desc:    prometheus.NewDesc("saga_sagas", "Number of sagas per status.", []string{"status"}, nil),
+	}
+}
+
+// Describe sends the description of the collected metric.
+func (c StatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect counts sagas per status and sends the counts as gauges.
+func (c StatusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	counts, err := c.storage.CountByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), status)
+	}
+}
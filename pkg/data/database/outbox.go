@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/illyasch/saga-service/pkg/data/audit"
+)
+
+// outboxDelay is the time the caller changing a saga has to deliver the outbox entry of the change itself,
+// before ClaimOutbox returns the entry to a relay.
+const outboxDelay = 30 * time.Second
+
+// OutboxEntry is a state change of a saga stored with the change and delivered to the audit sink
+// and to the callback of the saga after the change is committed.
+// The ID of the entry is the ID of its event.
+type OutboxEntry struct {
+	audit.Event
+	Attempts int `db:"attempts"`
+}
+
+// ClaimOutbox returns up to limit entries which weren't delivered in time, the oldest first. The claimed
+// entries are postponed by the lease, so other instances don't deliver them concurrently.
+func (s Storage) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxEntry, error) {
+	defer observe("claim_outbox", time.Now())
+
+	const query = `WITH claimed AS (
+				UPDATE saga_outbox SET next_attempt = NOW() + $1 * INTERVAL '1 millisecond', attempts = attempts + 1
+				WHERE id IN (
+					SELECT id FROM saga_outbox WHERE next_attempt <= NOW()
+					ORDER BY date_created LIMIT $2 FOR UPDATE SKIP LOCKED
+				)
+				RETURNING id, seq, saga_id, workflow, step, from_status, to_status, triggered_by, request_id, date_created, attempts
+			)
+			SELECT * FROM claimed ORDER BY date_created`
+
+	var entries []OutboxEntry
+	if err := s.db.SelectContext(ctx, &entries, query, lease.Milliseconds(), limit); err != nil {
+		return nil, fmt.Errorf("query %s: %w", query, err)
+	}
+
+	return entries, nil
+}
+
+// DeleteOutbox removes the delivered entry.
+func (s Storage) DeleteOutbox(ctx context.Context, id uuid.UUID) error {
+	defer observe("delete_outbox", time.Now())
+
+	const query = `DELETE FROM saga_outbox WHERE id = $1`
+
+	if _, err := s.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("query %s: %w", query, err)
+	}
+
+	return nil
+}
+
+// outboxInsert returns the statement storing the entry for every saga returned by the changed common table
+// expression and returning the sequence number of the entry. The arguments of the entry start at the placeholder n.
+func outboxInsert(n int) string {
+	return fmt.Sprintf(`INSERT INTO saga_outbox (id, saga_id, workflow, step, from_status, to_status, triggered_by,
+					request_id, date_created, next_attempt)
+				SELECT $%d, id, $%d, $%d, $%d, $%d, $%d, $%d, $%d, NOW() + $%d * INTERVAL '1 millisecond' FROM changed
+				RETURNING seq`,
+		n, n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8)
+}
+
+// outboxArgs returns the arguments of outboxInsert.
+func outboxArgs(e OutboxEntry) []any {
+	return []any{e.ID, e.Workflow, e.Step, e.FromStatus, e.ToStatus, e.TriggeredBy, e.RequestID, e.Time,
+		outboxDelay.Milliseconds()}
+}
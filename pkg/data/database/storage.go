@@ -3,58 +3,524 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// Cipher encrypts and decrypts sensitive columns.
+type Cipher interface {
+	Encrypt([]byte) ([]byte, error)
+	Decrypt([]byte) ([]byte, error)
+	IsEncrypted([]byte) bool
+}
+
+// Saga is a state of a saga stored in the database.
+type Saga struct {
+	ID          uuid.UUID       `db:"id"`
+	Status      string          `db:"status"`
+	Service     string          `db:"service"`
+	Workflow    string          `db:"workflow"`
+	Payload     json.RawMessage `db:"payload"`
+	DateCreated time.Time       `db:"date_created"`
+	DateUpdated time.Time       `db:"date_updated"`
+	// Version is incremented on every update of the saga.
+	Version int `db:"version"`
+	// Retries is the number of times the saga was retried after a failure.
+	Retries int `db:"retries"`
+}
+
+// ConflictError is returned when a saga is updated in a version which isn't current anymore,
+// since the saga was changed after it was read.
+type ConflictError struct {
+	SagaID  uuid.UUID
+	Version int
+	Current int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("saga %s was changed concurrently: version %d, current %d", e.SagaID, e.Version, e.Current)
+}
+
 type Storage struct {
-	db *sqlx.DB
+	db     *sqlx.DB
+	cipher Cipher
+}
+
+// StorageOption configures optional behaviour of a Storage.
+type StorageOption func(*Storage)
+
+// WithEncryption makes the Storage encrypt saga payloads at rest.
+func WithEncryption(c Cipher) StorageOption {
+	return func(s *Storage) {
+		s.cipher = c
+	}
 }
 
-func NewStorage(db *sqlx.DB) Storage {
-	return Storage{
+func NewStorage(db *sqlx.DB, opts ...StorageOption) Storage {
+	s := Storage{
 		db: db,
 	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return s
 }
 
-func (s Storage) InsertSaga(ctx context.Context, sagaID uuid.UUID, service, status string) error {
-	// To keep the operation idempotent we do nothing if the saga has been already started.
-	const query = `INSERT INTO sagas(id, status, service, date_created) VALUES ($1, $2, $3, NOW()) 
-                	ON CONFLICT(id) DO NOTHING`
+// startLockClass is the first key of advisory locks serializing starts of sagas of a workflow.
+const startLockClass = 1
+
+// InsertSaga stores the new saga with its callback, if any, and the outbox entry of its start, and sets
+// the sequence number of the entry. It returns ErrDBDuplicatedEntry if the saga has been already started.
+// If maxRunning is set, it returns ErrDBLimitExceeded if that many sagas of the workflow are in the status.
+func (s Storage) InsertSaga(ctx context.Context, sagaID uuid.UUID, workflow, service, status string, payload json.RawMessage,
+	callback *Webhook, maxRunning int, entry *OutboxEntry) error {
+	defer observe("insert_saga", time.Now())
+
+	// A callback left by a start rejected before the saga was stored belongs to no saga and is replaced.
+	query := `WITH changed AS (
+				INSERT INTO sagas(id, status, service, workflow, payload, date_created, date_updated)
+				VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+				ON CONFLICT(id) DO NOTHING RETURNING id
+			), callback AS (
+				INSERT INTO webhooks(saga_id, url, secret, state, date_created)
+				SELECT id, $6, $7, $8, NOW() FROM changed WHERE $6 <> ''
+				ON CONFLICT(saga_id) DO UPDATE SET url = EXCLUDED.url, secret = EXCLUDED.secret, state = EXCLUDED.state,
+					saga_status = '', attempts = 0, next_attempt = NULL, last_error = '',
+					date_created = EXCLUDED.date_created, date_delivered = NULL
+			)
+			` + outboxInsert(9)
+
+	data, err := s.encrypt(payload)
+	if err != nil {
+		return err
+	}
+
+	var url, secret string
+	if callback != nil {
+		url = callback.URL
+		if secret, err = s.encryptSecret(callback.Secret); err != nil {
+			return err
+		}
+	}
+	args := append([]any{sagaID, status, service, workflow, data, url, secret, WebhookWaiting}, outboxArgs(*entry)...)
+
+	if maxRunning <= 0 {
+		return insertSaga(ctx, s.db, query, args, entry)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tran: %w", err)
+	}
+
+	// Starts of the workflow wait for each other until the end of the transaction, so the count includes
+	// the sagas of all earlier starts and concurrent starts can't exceed the limit together.
+	const lockQuery = `SELECT pg_advisory_xact_lock($1, hashtext($2))`
+	if _, err := tx.ExecContext(ctx, lockQuery, startLockClass, workflow); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("query %s: %w", lockQuery, err)
+	}
 
-	if _, err := s.db.ExecContext(ctx, query, sagaID, status, service); err != nil {
-		return fmt.Errorf("query %s: %w", query, err)
+	const countQuery = `SELECT COUNT(*) FROM sagas WHERE workflow = $1 AND status = $2`
+	var count int
+	if err := tx.GetContext(ctx, &count, countQuery, workflow, status); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("query %s: %w", countQuery, err)
+	}
+	if count >= maxRunning {
+		_ = tx.Rollback()
+		return fmt.Errorf("%w: %d sagas of workflow %s are %s", ErrDBLimitExceeded, count, workflow, status)
+	}
+
+	if err := insertSaga(ctx, tx, query, args, entry); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tran: %w", err)
 	}
 
 	return nil
 }
 
-func (s Storage) UpdateStatus(ctx context.Context, sagaID uuid.UUID, status string) error {
-	const query = `UPDATE sagas SET status = $1 WHERE id = $2`
-	res, err := s.db.ExecContext(ctx, query, status, sagaID)
+// insertSaga runs the query of InsertSaga with the database or the transaction.
+func insertSaga(ctx context.Context, q sqlx.QueryerContext, query string, args []any, entry *OutboxEntry) error {
+	err := q.QueryRowxContext(ctx, query, args...).Scan(&entry.Seq)
+	if err == sql.ErrNoRows {
+		return ErrDBDuplicatedEntry
+	}
 	if err != nil {
-		return fmt.Errorf("query %s: %w", query, err)
+		return queryError(query, err)
 	}
 
-	affected, err := res.RowsAffected()
+	return nil
+}
+
+// UpdateStatus sets the status of the saga with the outbox entry of the change if it's still in the version
+// it was read in and increments the version. It returns a *ConflictError if the saga was changed since then.
+func (s Storage) UpdateStatus(ctx context.Context, sagaID uuid.UUID, version int, status string, entry *OutboxEntry) error {
+	defer observe("update_status", time.Now())
+
+	query := `WITH changed AS (
+				UPDATE sagas SET status = $1, version = version + 1, date_updated = NOW() WHERE id = $2 AND version = $3
+				RETURNING id
+			)
+			` + outboxInsert(4)
+
+	return s.update(ctx, query, sagaID, version, status, entry)
+}
+
+// UpdateService sets the current service of the saga with the outbox entry of the change if it's still in the
+// version it was read in and increments the version. It returns a *ConflictError if the saga was changed since then.
+func (s Storage) UpdateService(ctx context.Context, sagaID uuid.UUID, version int, service string, entry *OutboxEntry) error {
+	defer observe("update_service", time.Now())
+
+	query := `WITH changed AS (
+				UPDATE sagas SET service = $1, version = version + 1, date_updated = NOW() WHERE id = $2 AND version = $3
+				RETURNING id
+			)
+			` + outboxInsert(4)
+
+	return s.update(ctx, query, sagaID, version, service, entry)
+}
+
+// RetrySaga starts the failed saga again from the service with the outbox entry of the change if it's still
+// in the version it was read in and increments the version and the retries. The callback of the saga, if any,
+// waits for the next outcome again. It returns a *ConflictError if the saga was changed since then.
+func (s Storage) RetrySaga(ctx context.Context, sagaID uuid.UUID, version int, service string, entry *OutboxEntry) error {
+	defer observe("retry_saga", time.Now())
+
+	query := `WITH changed AS (
+				UPDATE sagas SET status = 'started', service = $1, retries = retries + 1, version = version + 1,
+					date_updated = NOW()
+				WHERE id = $2 AND version = $3
+				RETURNING id
+			), callback AS (
+				UPDATE webhooks SET state = 'waiting', saga_status = '', attempts = 0, next_attempt = NULL,
+					last_error = '', date_delivered = NULL
+				WHERE saga_id IN (SELECT id FROM changed)
+			)
+			` + outboxInsert(4)
+
+	return s.update(ctx, query, sagaID, version, service, entry)
+}
+
+// update runs the compare-and-swap query setting the value of the saga in the version and storing the outbox
+// entry, and sets the sequence number of the entry. It returns ErrDBNotFound if the saga doesn't exist and
+// a *ConflictError if it's in another version.
+func (s Storage) update(ctx context.Context, query string, sagaID uuid.UUID, version int, value string, entry *OutboxEntry) error {
+	args := append([]any{value, sagaID, version}, outboxArgs(*entry)...)
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&entry.Seq)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return queryError(query, err)
+	}
+
+	const versionQuery = `SELECT version FROM sagas WHERE id = $1`
+	var current int
+	if err := s.db.GetContext(ctx, &current, versionQuery, sagaID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrDBNotFound
+		}
+		return fmt.Errorf("query %s: %w", versionQuery, err)
+	}
+
+	return &ConflictError{SagaID: sagaID, Version: version, Current: current}
+}
+
+// queryError wraps the error of the query. A status rejected by the trigger saga_status_transition
+// is reported as ErrDBIllegalTransition.
+func queryError(query string, err error) error {
+	if pqerr, ok := err.(*pq.Error); ok && pqerr.Code == checkViolation {
+		return fmt.Errorf("%w: %s", ErrDBIllegalTransition, pqerr.Message)
+	}
+
+	return fmt.Errorf("query %s: %w", query, err)
+}
+
+// GetSaga returns the state of the saga with the decrypted payload.
+func (s Storage) GetSaga(ctx context.Context, sagaID uuid.UUID) (Saga, error) {
+	defer observe("get_saga", time.Now())
+
+	const query = `SELECT id, status, COALESCE(service, '') AS service, workflow, payload, date_created,
+					COALESCE(date_updated, date_created) AS date_updated, version, retries
+				FROM sagas WHERE id = $1`
+
+	var saga Saga
+	if err := s.db.GetContext(ctx, &saga, query, sagaID); err != nil {
+		if err == sql.ErrNoRows {
+			return Saga{}, ErrDBNotFound
+		}
+		return Saga{}, fmt.Errorf("query %s: %w", query, err)
+	}
+
+	payload, err := s.decrypt(saga.Payload)
 	if err != nil {
-		return fmt.Errorf("affected rows: %w", err)
+		return Saga{}, err
 	}
-	if affected == 0 {
-		return sql.ErrNoRows
+	saga.Payload = payload
+
+	return saga, nil
+}
+
+// StaleSagas returns up to limit sagas in the status which were not updated for the given duration,
+// the least recently updated first. Payloads are not returned.
+func (s Storage) StaleSagas(ctx context.Context, status string, olderThan time.Duration, limit int) ([]Saga, error) {
+	defer observe("stale_sagas", time.Now())
+
+	const query = `SELECT id, status, COALESCE(service, '') AS service, workflow, date_created,
+					COALESCE(date_updated, date_created) AS date_updated, version
+				FROM sagas WHERE status = $1 AND COALESCE(date_updated, date_created) < NOW() - $2 * INTERVAL '1 millisecond'
+				ORDER BY COALESCE(date_updated, date_created) LIMIT $3`
+
+	var sagas []Saga
+	if err := s.db.SelectContext(ctx, &sagas, query, status, olderThan.Milliseconds(), limit); err != nil {
+		return nil, fmt.Errorf("query %s: %w", query, err)
 	}
 
-	return nil
+	return sagas, nil
 }
 
-func (s Storage) UpdateService(ctx context.Context, sagaID uuid.UUID, prev, next string) error {
-	// Updates the service in the saga to next only if it is set to the previous service in DB.
-	const query = `UPDATE sagas SET service = $1 WHERE id = $2 AND service = $3`
-	if _, err := s.db.ExecContext(ctx, query, next, sagaID, prev); err != nil {
-		return fmt.Errorf("query %s: %w", query, err)
+// CountByStatus returns the number of sagas per status.
+func (s Storage) CountByStatus(ctx context.Context) (map[string]int, error) {
+	defer observe("count_by_status", time.Now())
+
+	const query = `SELECT status, COUNT(*) AS count FROM sagas GROUP BY status`
+
+	var rows []struct {
+		Status string `db:"status"`
+		Count  int    `db:"count"`
+	}
+	if err := s.db.SelectContext(ctx, &rows, query); err != nil {
+		return nil, fmt.Errorf("query %s: %w", query, err)
 	}
 
-	return nil
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+
+	return counts, nil
+}
+
+// SagaFilter selects sagas by status, current service and age. Empty fields don't filter.
+type SagaFilter struct {
+	Status  string
+	Service string
+	// OlderThan selects sagas created earlier than the duration ago.
+	OlderThan time.Duration
+	Limit     int
+}
+
+// ListSagas returns sagas matching the filter, the most recently created first. Payloads are not returned.
+func (s Storage) ListSagas(ctx context.Context, f SagaFilter) ([]Saga, error) {
+	defer observe("list_sagas", time.Now())
+
+	query := `SELECT id, status, COALESCE(service, '') AS service, workflow, date_created,
+				COALESCE(date_updated, date_created) AS date_updated, version
+			FROM sagas WHERE TRUE`
+	var args []any
+	if f.Status != "" {
+		args = append(args, f.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if f.Service != "" {
+		args = append(args, f.Service)
+		query += fmt.Sprintf(" AND service = $%d", len(args))
+	}
+	if f.OlderThan > 0 {
+		args = append(args, f.OlderThan.Milliseconds())
+		query += fmt.Sprintf(" AND date_created < NOW() - $%d * INTERVAL '1 millisecond'", len(args))
+	}
+	query += " ORDER BY date_created DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	var sagas []Saga
+	if err := s.db.SelectContext(ctx, &sagas, query, args...); err != nil {
+		return nil, fmt.Errorf("query %s: %w", query, err)
+	}
+
+	return sagas, nil
+}
+
+// PurgeSagas deletes sagas in the statuses which were not updated for the given duration, together with
+// their webhooks. The audit trail is kept. Sagas are deleted in batches of the given size, each batch in
+// its own statement, so the table isn't locked for long. The batch size must be positive. It returns the number
+// of deleted sagas.
+func (s Storage) PurgeSagas(ctx context.Context, statuses []string, olderThan time.Duration, batchSize int) (int, error) {
+	defer observe("purge_sagas", time.Now())
+
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("batch size must be positive: %d", batchSize)
+	}
+
+	const query = `WITH purged AS (
+					DELETE FROM sagas WHERE id IN (
+						SELECT id FROM sagas WHERE status::TEXT = ANY($1)
+							AND COALESCE(date_updated, date_created) < NOW() - $2 * INTERVAL '1 millisecond'
+						LIMIT $3)
+					RETURNING id
+				), hooks AS (
+					DELETE FROM webhooks WHERE saga_id IN (SELECT id FROM purged)
+				)
+				SELECT COUNT(*) FROM purged`
+
+	var total int
+	for {
+		var count int
+		err := s.db.GetContext(ctx, &count, query, pq.Array(statuses), olderThan.Milliseconds(), batchSize)
+		if err != nil {
+			return total, fmt.Errorf("query %s: %w", query, err)
+		}
+		total += count
+		if count < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// Reencrypt seals all saga payloads and webhook secrets again with the current key of the cipher. Values stored
+// before the encryption was enabled are sealed too. Values are processed in batches of the given size, each
+// batch in its own transaction. It returns the number of re-encrypted values.
+func (s Storage) Reencrypt(ctx context.Context, batchSize int) (int, error) {
+	if s.cipher == nil {
+		return 0, fmt.Errorf("encryption is not configured")
+	}
+
+	payloads, err := s.reencrypt(ctx, batchSize, "payload of saga",
+		`SELECT id, payload AS value FROM sagas WHERE payload IS NOT NULL AND id > $1 ORDER BY id LIMIT $2 FOR UPDATE`,
+		`UPDATE sagas SET payload = $1 WHERE id = $2`)
+	if err != nil {
+		return payloads, err
+	}
+
+	secrets, err := s.reencrypt(ctx, batchSize, "webhook secret of saga",
+		`SELECT saga_id AS id, secret AS value FROM webhooks WHERE secret <> '' AND saga_id > $1 ORDER BY saga_id LIMIT $2 FOR UPDATE`,
+		`UPDATE webhooks SET secret = convert_from($1, 'UTF8') WHERE saga_id = $2`)
+
+	return payloads + secrets, err
+}
+
+// reencrypt seals the values selected by the query again and stores them with the update query. The select query
+// returns the rows with IDs after the first argument up to the limit in the second argument.
+func (s Storage) reencrypt(ctx context.Context, batchSize int, name, selectQuery, updateQuery string) (int, error) {
+	var total int
+	var last uuid.UUID
+	for {
+		var rows []struct {
+			ID    uuid.UUID `db:"id"`
+			Value []byte    `db:"value"`
+		}
+
+		tx, err := s.db.BeginTxx(ctx, nil)
+		if err != nil {
+			return total, fmt.Errorf("begin tran: %w", err)
+		}
+
+		if err := tx.SelectContext(ctx, &rows, selectQuery, last, batchSize); err != nil {
+			_ = tx.Rollback()
+			return total, fmt.Errorf("query %s: %w", selectQuery, err)
+		}
+		if len(rows) == 0 {
+			return total, tx.Rollback()
+		}
+
+		for _, row := range rows {
+			plaintext := row.Value
+			if s.cipher.IsEncrypted(row.Value) {
+				plaintext, err = s.cipher.Decrypt(row.Value)
+				if err != nil {
+					_ = tx.Rollback()
+					return total, fmt.Errorf("decrypt %s %s: %w", name, row.ID, err)
+				}
+			}
+
+			data, err := s.cipher.Encrypt(plaintext)
+			if err != nil {
+				_ = tx.Rollback()
+				return total, fmt.Errorf("encrypt %s %s: %w", name, row.ID, err)
+			}
+
+			if _, err := tx.ExecContext(ctx, updateQuery, data, row.ID); err != nil {
+				_ = tx.Rollback()
+				return total, fmt.Errorf("query %s: %w", updateQuery, err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return total, fmt.Errorf("commit tran: %w", err)
+		}
+		total += len(rows)
+		last = rows[len(rows)-1].ID
+	}
+}
+
+func (s Storage) encrypt(payload json.RawMessage) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	if s.cipher == nil {
+		return payload, nil
+	}
+
+	data, err := s.cipher.Encrypt(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt payload: %w", err)
+	}
+
+	return data, nil
+}
+
+// decrypt opens the payload. Payloads stored before the encryption was enabled are returned as is.
+func (s Storage) decrypt(data []byte) (json.RawMessage, error) {
+	if len(data) == 0 || s.cipher == nil || !s.cipher.IsEncrypted(data) {
+		return data, nil
+	}
+
+	payload, err := s.cipher.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt payload: %w", err)
+	}
+
+	return payload, nil
+}
+
+// encryptSecret seals the webhook secret. The sealed value is JSON, so it's stored as text.
+func (s Storage) encryptSecret(secret string) (string, error) {
+	if secret == "" || s.cipher == nil {
+		return secret, nil
+	}
+
+	data, err := s.cipher.Encrypt([]byte(secret))
+	if err != nil {
+		return "", fmt.Errorf("encrypt secret: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// decryptSecret opens the webhook secret. Secrets stored before the encryption was enabled are returned as is.
+func (s Storage) decryptSecret(secret string) (string, error) {
+	if secret == "" || s.cipher == nil || !s.cipher.IsEncrypted([]byte(secret)) {
+		return secret, nil
+	}
+
+	data, err := s.cipher.Decrypt([]byte(secret))
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret: %w", err)
+	}
+
+	return string(data), nil
 }
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Set of states of a webhook delivery.
+const (
+	WebhookWaiting   = "waiting"
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// Webhook is a callback of a saga delivered when the saga reaches a terminal status.
+type Webhook struct {
+	SagaID        uuid.UUID    `db:"saga_id"`
+	URL           string       `db:"url"`
+	Secret        string       `db:"secret"`
+	State         string       `db:"state"`
+	SagaStatus    string       `db:"saga_status"`
+	Attempts      int          `db:"attempts"`
+	NextAttempt   sql.NullTime `db:"next_attempt"`
+	LastError     string       `db:"last_error"`
+	DateCreated   time.Time    `db:"date_created"`
+	DateDelivered sql.NullTime `db:"date_delivered"`
+}
+
+// ScheduleWebhook schedules delivery of the saga outcome if the saga has a waiting callback and is still
+// in the status, so an outcome delivered late by the outbox relay doesn't take the place of a later one.
+func (s Storage) ScheduleWebhook(ctx context.Context, sagaID uuid.UUID, status string) error {
+	defer observe("schedule_webhook", time.Now())
+
+	const query = `UPDATE webhooks SET state = $1, saga_status = $2, next_attempt = NOW()
+					WHERE saga_id = $3 AND state = $4 AND EXISTS (SELECT 1 FROM sagas WHERE id = $3 AND status::TEXT = $2)`
+
+	if _, err := s.db.ExecContext(ctx, query, WebhookPending, status, sagaID, WebhookWaiting); err != nil {
+		return fmt.Errorf("query %s: %w", query, err)
+	}
+
+	return nil
+}
+
+// ClaimWebhooks returns up to limit pending webhooks due for delivery. The claimed webhooks are
+// postponed by the lease, so other instances don't deliver them concurrently.
+func (s Storage) ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]Webhook, error) {
+	defer observe("claim_webhooks", time.Now())
+
+	const query = `UPDATE webhooks SET next_attempt = NOW() + $1 * INTERVAL '1 millisecond'
+					WHERE saga_id IN (
+						SELECT saga_id FROM webhooks WHERE state = $2 AND next_attempt <= NOW()
+						ORDER BY next_attempt LIMIT $3 FOR UPDATE SKIP LOCKED
+					)
+					RETURNING saga_id, url, secret, state, saga_status, attempts, next_attempt, last_error, date_created, date_delivered`
+
+	var webhooks []Webhook
+	if err := s.db.SelectContext(ctx, &webhooks, query, lease.Milliseconds(), WebhookPending, limit); err != nil {
+		return nil, fmt.Errorf("query %s: %w", query, err)
+	}
+	for i := range webhooks {
+		secret, err := s.decryptSecret(webhooks[i].Secret)
+		if err != nil {
+			return nil, fmt.Errorf("webhook of saga %s: %w", webhooks[i].SagaID, err)
+		}
+		webhooks[i].Secret = secret
+	}
+
+	return webhooks, nil
+}
+
+// MarkWebhookDelivered records a successful delivery of the webhook. A webhook which was reset by a retry
+// of its saga during the delivery keeps waiting for the next outcome.
+func (s Storage) MarkWebhookDelivered(ctx context.Context, sagaID uuid.UUID) error {
+	defer observe("mark_webhook_delivered", time.Now())
+
+	const query = `UPDATE webhooks SET state = $1, attempts = attempts + 1, last_error = '', next_attempt = NULL,
+					date_delivered = NOW() WHERE saga_id = $2 AND state = $3`
+
+	if _, err := s.db.ExecContext(ctx, query, WebhookDelivered, sagaID, WebhookPending); err != nil {
+		return fmt.Errorf("query %s: %w", query, err)
+	}
+
+	return nil
+}
+
+// RetryWebhook records a failed delivery of the webhook and schedules the next attempt.
+// Without the next attempt the webhook is marked as failed. A webhook reset by a retry of its saga is left as is.
+func (s Storage) RetryWebhook(ctx context.Context, sagaID uuid.UUID, reason string, next *time.Time) error {
+	defer observe("retry_webhook", time.Now())
+
+	state := WebhookPending
+	if next == nil {
+		state = WebhookFailed
+	}
+
+	const query = `UPDATE webhooks SET state = $1, attempts = attempts + 1, last_error = $2, next_attempt = $3
+					WHERE saga_id = $4 AND state = $5`
+
+	if _, err := s.db.ExecContext(ctx, query, state, reason, next, sagaID, WebhookPending); err != nil {
+		return fmt.Errorf("query %s: %w", query, err)
+	}
+
+	return nil
+}
+
+// GetWebhook returns the webhook of the saga.
+func (s Storage) GetWebhook(ctx context.Context, sagaID uuid.UUID) (Webhook, error) {
+	defer observe("get_webhook", time.Now())
+
+	const query = `SELECT saga_id, url, secret, state, saga_status, attempts, next_attempt, last_error, date_created, date_delivered
+					FROM webhooks WHERE saga_id = $1`
+
+	var webhook Webhook
+	if err := s.db.GetContext(ctx, &webhook, query, sagaID); err != nil {
+		if err == sql.ErrNoRows {
+			return Webhook{}, ErrDBNotFound
+		}
+		return Webhook{}, fmt.Errorf("query %s: %w", query, err)
+	}
+
+	secret, err := s.decryptSecret(webhook.Secret)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("webhook of saga %s: %w", sagaID, err)
+	}
+	webhook.Secret = secret
+
+	return webhook, nil
+}
@@ -0,0 +1,116 @@
+// Package events streams status changes of sagas to subscribers. Changes are published to an in-process
+// Broker, and for deployments with multiple instances they are relayed between instances with Postgres
+// LISTEN/NOTIFY.
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/illyasch/saga-service/pkg/data/audit"
+)
+
+// subscriberBuffer is the number of messages a subscriber can fall behind before it is dropped.
+const subscriberBuffer = 64
+
+// Message is a saga status change with its ID. The ID is the sequence number of the change, which is
+// assigned when the change is stored, so it identifies the change on all instances.
+type Message struct {
+	ID    uint64
+	Event audit.Event
+}
+
+// Filter selects status changes for a subscriber. Empty fields match any change.
+type Filter struct {
+	SagaID   uuid.UUID
+	Status   string
+	Workflow string
+}
+
+func (f Filter) match(e audit.Event) bool {
+	return (f.SagaID == uuid.Nil || f.SagaID == e.SagaID) &&
+		(f.Status == "" || f.Status == e.ToStatus) &&
+		(f.Workflow == "" || f.Workflow == e.Workflow)
+}
+
+// Broker is an in-process pub/sub of saga status changes. It keeps recent changes, so a subscriber
+// reconnecting with the ID of the last received message doesn't miss changes.
+type Broker struct {
+	mu          sync.Mutex
+	history     []Message
+	historySize int
+	subs        map[chan Message]Filter
+}
+
+// NewBroker constructs a Broker keeping the given number of recent changes.
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		historySize: historySize,
+		subs:        make(map[chan Message]Filter),
+	}
+}
+
+// Publish sends the change to matching subscribers. A subscriber which is too slow to receive
+// the change is dropped, its channel is closed.
+func (b *Broker) Publish(_ context.Context, e audit.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	m := Message{ID: e.Seq, Event: e}
+	b.history = append(b.history, m)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for ch, f := range b.subs {
+		if !f.match(e) {
+			continue
+		}
+		select {
+		case ch <- m:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving changes matching the filter. If lastID is set, the recent
+// changes published after the message with the ID are received first. Changes committed concurrently
+// can be published out of the order of their IDs, so they are replayed in the order they were published,
+// or by their IDs if the message with the ID is out of the history. The returned function cancels
+// the subscription.
+func (b *Broker) Subscribe(f Filter, lastID uint64) (<-chan Message, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Message, subscriberBuffer)
+	if lastID > 0 {
+		replay := b.history
+		for i, m := range b.history {
+			if m.ID == lastID {
+				replay = b.history[i+1:]
+				lastID = 0
+				break
+			}
+		}
+		for _, m := range replay {
+			if m.ID > lastID && f.match(m.Event) && len(ch) < cap(ch) {
+				ch <- m
+			}
+		}
+	}
+	b.subs[ch] = f
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/data/audit"
+	"github.com/illyasch/saga-service/pkg/data/events"
+)
+
+func TestBroker(t *testing.T) {
+	sagaID, otherID := uuid.New(), uuid.New()
+	started := audit.Event{Seq: 1, SagaID: sagaID, Workflow: "sample", Step: "service1", ToStatus: "started"}
+	other := audit.Event{Seq: 2, SagaID: otherID, Workflow: "other", Step: "service1", ToStatus: "started"}
+	completed := audit.Event{Seq: 3, SagaID: sagaID, Workflow: "sample", Step: "service2", FromStatus: "started", ToStatus: "completed"}
+
+	t.Run("filter", func(t *testing.T) {
+		b := events.NewBroker(10)
+		bySaga, cancelSaga := b.Subscribe(events.Filter{SagaID: sagaID}, 0)
+		defer cancelSaga()
+		byStatus, cancelStatus := b.Subscribe(events.Filter{Status: "completed"}, 0)
+		defer cancelStatus()
+		byWorkflow, cancelWorkflow := b.Subscribe(events.Filter{Workflow: "other"}, 0)
+		defer cancelWorkflow()
+
+		for _, e := range []audit.Event{started, other, completed} {
+			b.Publish(context.Background(), e)
+		}
+
+		assert.Equal(t, []events.Message{{ID: 1, Event: started}, {ID: 3, Event: completed}}, drain(bySaga))
+		assert.Equal(t, []events.Message{{ID: 3, Event: completed}}, drain(byStatus))
+		assert.Equal(t, []events.Message{{ID: 2, Event: other}}, drain(byWorkflow))
+	})
+
+	t.Run("replay after the last ID", func(t *testing.T) {
+		b := events.NewBroker(2)
+		for _, e := range []audit.Event{started, other, completed} {
+			b.Publish(context.Background(), e)
+		}
+
+		ch, cancel := b.Subscribe(events.Filter{}, 1)
+		defer cancel()
+		assert.Equal(t, []events.Message{{ID: 2, Event: other}, {ID: 3, Event: completed}}, drain(ch))
+
+		// The first change is out of the history.
+		ch, cancel = b.Subscribe(events.Filter{SagaID: sagaID}, 0)
+		defer cancel()
+		assert.Empty(t, drain(ch))
+	})
+
+	t.Run("replay in the order of publishing", func(t *testing.T) {
+		b := events.NewBroker(10)
+		// The change 2 is committed after the change 3.
+		for _, e := range []audit.Event{started, completed, other} {
+			b.Publish(context.Background(), e)
+		}
+
+		ch, cancel := b.Subscribe(events.Filter{}, 3)
+		defer cancel()
+		assert.Equal(t, []events.Message{{ID: 2, Event: other}}, drain(ch))
+	})
+
+	t.Run("slow subscriber is dropped", func(t *testing.T) {
+		b := events.NewBroker(10)
+		ch, cancel := b.Subscribe(events.Filter{}, 0)
+		defer cancel()
+
+		for i := 0; i < 100; i++ {
+			b.Publish(context.Background(), started)
+		}
+
+		var n int
+		for range ch {
+			n++
+		}
+		assert.Less(t, n, 100)
+	})
+
+	t.Run("cancel closes the channel", func(t *testing.T) {
+		b := events.NewBroker(10)
+		ch, cancel := b.Subscribe(events.Filter{}, 0)
+		cancel()
+		cancel()
+
+		_, ok := <-ch
+		require.False(t, ok)
+		b.Publish(context.Background(), started)
+	})
+}
+
+// drain returns the messages buffered in the channel.
+func drain(ch <-chan events.Message) []events.Message {
+	var msgs []events.Message
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, m)
+		default:
+			return msgs
+		}
+	}
+}
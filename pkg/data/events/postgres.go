@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/data/audit"
+)
+
+// channel is the Postgres notification channel of saga status changes.
+const channel = "saga_events"
+
+// PostgresPublisher publishes changes to all instances listening to the database.
+type PostgresPublisher struct {
+	db  *sqlx.DB
+	log *zap.SugaredLogger
+}
+
+// NewPostgresPublisher constructs a PostgresPublisher notifying listeners of the database.
+func NewPostgresPublisher(db *sqlx.DB, log *zap.SugaredLogger) PostgresPublisher {
+	return PostgresPublisher{db: db, log: log}
+}
+
+// Publish notifies the listeners about the change. Streaming is best-effort, so failures are only logged.
+func (p PostgresPublisher) Publish(ctx context.Context, e audit.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		p.log.Errorw("events", "ERROR", fmt.Errorf("json marshal: %w", err))
+		return
+	}
+
+	const query = `SELECT pg_notify($1, $2)`
+	if _, err := p.db.ExecContext(ctx, query, channel, string(data)); err != nil {
+		p.log.Errorw("events", "ERROR", fmt.Errorf("query %s: %w", query, err))
+	}
+}
+
+// Listen receives changes published to the database and publishes them to the broker
+// until the context is cancelled.
+func Listen(ctx context.Context, connString string, broker *Broker, log *zap.SugaredLogger) error {
+	l := pq.NewListener(connString, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Errorw("events", "ERROR", fmt.Errorf("listener: %w", err))
+		}
+	})
+	defer l.Close()
+
+	if err := l.Listen(channel); err != nil {
+		return fmt.Errorf("listen %s: %w", channel, err)
+	}
+
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n := <-l.Notify:
+			// A nil notification is sent after the connection is re-established.
+			if n == nil {
+				continue
+			}
+
+			var e audit.Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Errorw("events", "ERROR", fmt.Errorf("json unmarshal: %w", err))
+				continue
+			}
+			broker.Publish(ctx, e)
+
+		case <-ping.C:
+			go func() { _ = l.Ping() }()
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
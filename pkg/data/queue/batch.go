@@ -0,0 +1,158 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of SQS batch requests.
+const (
+	maxBatchEntries = 10
+	maxBatchBytes   = 256 * 1024
+)
+
+// ErrClosed is returned when a message is sent to a BatchSender which is not running anymore.
+var ErrClosed = errors.New("batch sender is closed")
+
+// BatchError reports messages of a batch which were not processed. Errors are indexed as
+// the messages passed to the batch operation.
+type BatchError struct {
+	Errors map[int]error
+}
+
+func (e *BatchError) Error() string {
+	idx := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+
+	msgs := make([]string, 0, len(idx))
+	for _, i := range idx {
+		msgs = append(msgs, fmt.Sprintf("message %d: %s", i, e.Errors[i]))
+	}
+
+	return fmt.Sprintf("%d messages failed: %s", len(idx), strings.Join(msgs, "; "))
+}
+
+// add records an error of the batch entry with the ID.
+func (e BatchError) add(id string, err error) {
+	i, convErr := strconv.Atoi(id)
+	if convErr != nil {
+		return
+	}
+	e.Errors[i] = err
+}
+
+// BatchSender collects messages sent concurrently and sends them to the queue in batches.
+// A batch is sent when it is full, when every caller of Send has added its message to it, or
+// when the flush interval after its first message passes. So a single caller sending messages
+// one by one doesn't wait for the interval.
+type BatchSender struct {
+	sender   *Sender
+	interval time.Duration
+	entries  chan batchEntry
+	done     chan struct{}
+	// senders is the number of calls of Send in progress.
+	senders int64
+}
+
+type batchEntry struct {
+	ctx    context.Context
+	msg    any
+	result chan error
+}
+
+// NewBatchSender constructs a BatchSender sending batches with the sender.
+// The BatchSender sends messages only while its Run method is running.
+func NewBatchSender(s *Sender, interval time.Duration) *BatchSender {
+	return &BatchSender{
+		sender:   s,
+		interval: interval,
+		entries:  make(chan batchEntry),
+		done:     make(chan struct{}),
+	}
+}
+
+// Send method adds a passed message to the current batch and waits until the batch is sent.
+func (b *BatchSender) Send(ctx context.Context, msg any) error {
+	atomic.AddInt64(&b.senders, 1)
+	defer atomic.AddInt64(&b.senders, -1)
+
+	e := batchEntry{ctx: ctx, msg: msg, result: make(chan error, 1)}
+
+	select {
+	case b.entries <- e:
+	case <-b.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-e.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run collects messages into batches and sends them until the context is cancelled.
+// Messages collected at the moment of cancellation are still sent.
+func (b *BatchSender) Run(ctx context.Context) error {
+	defer close(b.done)
+
+	var batch []batchEntry
+	var flush <-chan time.Time
+	for {
+		select {
+		case e := <-b.entries:
+			if len(batch) == 0 {
+				flush = time.After(b.interval)
+			}
+			batch = append(batch, e)
+			if len(batch) == maxBatchEntries || int64(len(batch)) >= atomic.LoadInt64(&b.senders) {
+				b.send(ctx, batch)
+				batch, flush = nil, nil
+			}
+
+		case <-flush:
+			b.send(ctx, batch)
+			batch, flush = nil, nil
+
+		case <-ctx.Done():
+			if len(batch) > 0 {
+				b.send(context.Background(), batch)
+			}
+			return nil
+		}
+	}
+}
+
+// send sends the batch and passes the result of every message to its sender.
+func (b *BatchSender) send(ctx context.Context, batch []batchEntry) {
+	msgs := make([]any, len(batch))
+	traces := make([]context.Context, len(batch))
+	for i := range batch {
+		msgs[i] = batch[i].msg
+		traces[i] = batch[i].ctx
+	}
+
+	err := b.sender.sendBatch(ctx, msgs, traces)
+
+	var batchErr *BatchError
+	for i := range batch {
+		switch {
+		case errors.As(err, &batchErr):
+			batch[i].result <- batchErr.Errors[i]
+		default:
+			batch[i].result <- err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+func TestSender_SendBatch(t *testing.T) {
+	t.Run("messages are sent in chunks", func(t *testing.T) {
+		fake := newFakeSQS(t, "commands")
+		s, err := queue.NewSender(fake.client(), "commands")
+		require.NoError(t, err)
+
+		require.NoError(t, s.SendBatch(context.Background(), commands(25)))
+
+		assert.Len(t, fake.messages("commands"), 25)
+		assert.Equal(t, 3, fake.callCount("SendMessageBatch"))
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		fake := newFakeSQS(t, "commands")
+		fake.failWhen(func(body string) bool { return strings.Contains(body, `"name":"fail"`) })
+		s, err := queue.NewSender(fake.client(), "commands")
+		require.NoError(t, err)
+
+		msgs := commands(12)
+		msgs[3] = queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: "fail"}
+		msgs[11] = queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: "fail"}
+
+		err = s.SendBatch(context.Background(), msgs)
+
+		var batchErr *queue.BatchError
+		require.True(t, errors.As(err, &batchErr))
+		assert.Len(t, batchErr.Errors, 2)
+		assert.Error(t, batchErr.Errors[3])
+		assert.Error(t, batchErr.Errors[11])
+		assert.Len(t, fake.messages("commands"), 10)
+	})
+}
+
+func TestBatchSender(t *testing.T) {
+	t.Run("concurrent messages are sent in batches", func(t *testing.T) {
+		fake := newFakeSQS(t, "commands")
+		fake.failWhen(func(body string) bool { return strings.Contains(body, `"name":"fail"`) })
+		s, err := queue.NewSender(fake.client(), "commands")
+		require.NoError(t, err)
+
+		b := queue.NewBatchSender(s, 50*time.Millisecond)
+		msgs := commands(15)
+		msgs[7] = queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: "fail"}
+
+		errs := make([]error, len(msgs))
+		var wg sync.WaitGroup
+		for i := range msgs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = b.Send(context.Background(), msgs[i])
+			}(i)
+		}
+
+		// All callers wait for the sender before it runs.
+		time.Sleep(20 * time.Millisecond)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- b.Run(ctx)
+		}()
+		wg.Wait()
+
+		for i, err := range errs {
+			if i == 7 {
+				assert.Error(t, err)
+				continue
+			}
+			assert.NoError(t, err, "message %d", i)
+		}
+		assert.Len(t, fake.messages("commands"), 14)
+		assert.Equal(t, 2, fake.callCount("SendMessageBatch"))
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("sequential messages don't wait for the interval", func(t *testing.T) {
+		fake := newFakeSQS(t, "commands")
+		s, err := queue.NewSender(fake.client(), "commands")
+		require.NoError(t, err)
+
+		b := queue.NewBatchSender(s, time.Hour)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- b.Run(ctx)
+		}()
+
+		sendCtx, sendCancel := context.WithTimeout(context.Background(), time.Second)
+		defer sendCancel()
+		for _, msg := range commands(3) {
+			require.NoError(t, b.Send(sendCtx, msg))
+		}
+		assert.Len(t, fake.messages("commands"), 3)
+		assert.Equal(t, 3, fake.callCount("SendMessageBatch"))
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("closed sender", func(t *testing.T) {
+		fake := newFakeSQS(t, "commands")
+		s, err := queue.NewSender(fake.client(), "commands")
+		require.NoError(t, err)
+
+		b := queue.NewBatchSender(s, time.Millisecond)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.NoError(t, b.Run(ctx))
+
+		assert.ErrorIs(t, b.Send(context.Background(), commands(1)[0]), queue.ErrClosed)
+	})
+}
+
+func TestPoll_DeletesInBatches(t *testing.T) {
+	fake := newFakeSQS(t, "responses")
+	for i := 0; i < 5; i++ {
+		fake.push("responses", fmt.Sprintf(`{"saga_id":"%s","service":"service1","status":"done"}`, uuid.New()), nil)
+	}
+
+	target := &processor{}
+	runPoll(t, fake, target, func() bool { return target.processed() == 5 && fake.inflightCount() == 0 })
+
+	assert.Zero(t, fake.callCount("DeleteMessage"))
+	assert.Equal(t, 1, fake.callCount("DeleteMessageBatch"))
+}
+
+func commands(n int) []any {
+	msgs := make([]any, n)
+	for i := range msgs {
+		msgs[i] = queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: "start"}
+	}
+	return msgs
+}
@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Cipher encrypts and decrypts message payloads. The encrypted payload must be valid JSON.
+type Cipher interface {
+	Encrypt([]byte) ([]byte, error)
+	Decrypt([]byte) ([]byte, error)
+	IsEncrypted([]byte) bool
+}
+
+// WithEncryption makes the Sender encrypt payloads of sent messages.
+func WithEncryption(c Cipher) SenderOption {
+	return func(s *Sender) {
+		s.cipher = c
+	}
+}
+
+// WithDecryption makes the Poll decrypt payloads of received messages before processing.
+// Payloads which are not encrypted are passed to the processor as is.
+func WithDecryption(c Cipher) PollOption {
+	return func(o *pollOptions) {
+		o.cipher = c
+	}
+}
+
+// encryptPayload returns a copy of the message with the encrypted payload.
+func encryptPayload(c Cipher, msg any) (any, error) {
+	var err error
+	switch m := msg.(type) {
+	case Command:
+		m.Payload, err = encrypt(c, m.Payload)
+		return m, err
+	case Response:
+		m.Payload, err = encrypt(c, m.Payload)
+		return m, err
+	}
+
+	return msg, nil
+}
+
+// decryptPayload decrypts the payload of the message in place.
+func decryptPayload(c Cipher, msg any) error {
+	var payload *json.RawMessage
+	switch m := msg.(type) {
+	case *Command:
+		payload = &m.Payload
+	case *Response:
+		payload = &m.Payload
+	default:
+		return nil
+	}
+
+	if len(*payload) == 0 || !c.IsEncrypted(*payload) {
+		return nil
+	}
+
+	plaintext, err := c.Decrypt(*payload)
+	if err != nil {
+		return fmt.Errorf("decrypt payload: %w", err)
+	}
+	*payload = plaintext
+
+	return nil
+}
+
+func encrypt(c Cipher, payload json.RawMessage) (json.RawMessage, error) {
+	if len(payload) == 0 {
+		return payload, nil
+	}
+
+	ciphertext, err := c.Encrypt(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt payload: %w", err)
+	}
+
+	return ciphertext, nil
+}
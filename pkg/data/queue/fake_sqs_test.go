@@ -0,0 +1,386 @@
+package queue_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/uuid"
+)
+
+// fakeMessage is a message stored in the fakeSQS.
+type fakeMessage struct {
+	ID              string
+	Body            string
+	GroupID         string
+	DeduplicationID string
+	Attributes      map[string]string
+	receiptHandle   string
+}
+
+// fakeSQS is an in-memory SQS server speaking the query protocol of the AWS SDK.
+type fakeSQS struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	queues   map[string][]fakeMessage
+	inflight map[string]fakeMessage
+	calls    map[string]int
+	fail     func(body string) bool
+	// attributes are attributes of queues set on creation or by SetQueueAttributes.
+	attributes map[string]map[string]string
+}
+
+func newFakeSQS(t *testing.T, queues ...string) *fakeSQS {
+	f := fakeSQS{
+		queues:     make(map[string][]fakeMessage),
+		inflight:   make(map[string]fakeMessage),
+		calls:      make(map[string]int),
+		attributes: make(map[string]map[string]string),
+	}
+	for _, q := range queues {
+		f.queues[q] = nil
+	}
+
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+
+	return &f
+}
+
+// client returns an SQS client connected to the fake server.
+func (f *fakeSQS) client() *sqs.SQS {
+	cfg := aws.NewConfig().
+		WithRegion("us-west-1").
+		WithEndpoint(f.server.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", ""))
+
+	return sqs.New(session.Must(session.NewSession()), cfg)
+}
+
+// failWhen makes batch sends fail for entries with bodies matching the condition.
+func (f *fakeSQS) failWhen(cond func(body string) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fail = cond
+}
+
+// push adds a message to the queue as if it was sent by another service.
+func (f *fakeSQS) push(queue, body string, attrs map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queues[queue] = append(f.queues[queue], fakeMessage{ID: uuid.NewString(), Body: body, Attributes: attrs})
+}
+
+// pushToGroup adds a message of the FIFO message group to the queue as if it was sent by another service.
+func (f *fakeSQS) pushToGroup(queue, group, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queues[queue] = append(f.queues[queue], fakeMessage{ID: uuid.NewString(), Body: body, GroupID: group})
+}
+
+// messages returns messages waiting in the queue.
+func (f *fakeSQS) messages(queue string) []fakeMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]fakeMessage(nil), f.queues[queue]...)
+}
+
+// inflightCount returns the number of received and not deleted messages.
+func (f *fakeSQS) inflightCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.inflight)
+}
+
+// callCount returns how many times the action was called.
+func (f *fakeSQS) callCount(action string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[action]
+}
+
+func (f *fakeSQS) handle(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	action := r.Form.Get("Action")
+	f.calls[action]++
+	queue := path.Base(r.Form.Get("QueueUrl"))
+
+	switch action {
+	case "GetQueueUrl":
+		name := r.Form.Get("QueueName")
+		if _, ok := f.queues[name]; !ok {
+			f.error(w, "AWS.SimpleQueueService.NonExistentQueue")
+			return
+		}
+		f.write(w, action, struct {
+			QueueURL string `xml:"QueueUrl"`
+		}{f.server.URL + "/queue/" + name})
+
+	case "CreateQueue":
+		name := r.Form.Get("QueueName")
+		f.queues[name] = nil
+		f.attributes[name] = formQueueAttributes(r)
+		f.write(w, action, struct {
+			QueueURL string `xml:"QueueUrl"`
+		}{f.server.URL + "/queue/" + name})
+
+	case "SetQueueAttributes":
+		if f.attributes[queue] == nil {
+			f.attributes[queue] = make(map[string]string)
+		}
+		for k, v := range formQueueAttributes(r) {
+			f.attributes[queue][k] = v
+		}
+		f.write(w, action, struct{}{})
+
+	case "GetQueueAttributes":
+		type attribute struct {
+			Name  string
+			Value string
+		}
+		var result struct {
+			Attribute []attribute
+		}
+		result.Attribute = append(result.Attribute, attribute{Name: "QueueArn", Value: "arn:aws:sqs:us-west-1:000000000000:" + queue})
+		for k, v := range f.attributes[queue] {
+			result.Attribute = append(result.Attribute, attribute{Name: k, Value: v})
+		}
+		f.write(w, action, result)
+
+	case "SendMessage":
+		msg := fakeMessage{
+			ID:              uuid.NewString(),
+			Body:            r.Form.Get("MessageBody"),
+			GroupID:         r.Form.Get("MessageGroupId"),
+			DeduplicationID: r.Form.Get("MessageDeduplicationId"),
+			Attributes:      formAttributes(r, "MessageAttribute"),
+		}
+		f.queues[queue] = append(f.queues[queue], msg)
+		f.write(w, action, struct {
+			MD5OfMessageBody string
+			MessageID        string `xml:"MessageId"`
+		}{md5Hex(msg.Body), msg.ID})
+
+	case "SendMessageBatch":
+		type resultEntry struct {
+			ID               string `xml:"Id"`
+			MessageID        string `xml:"MessageId"`
+			MD5OfMessageBody string
+		}
+		var result struct {
+			Successful []resultEntry     `xml:"SendMessageBatchResultEntry"`
+			Failed     []batchErrorEntry `xml:"BatchResultErrorEntry"`
+		}
+
+		for i := 1; ; i++ {
+			prefix := fmt.Sprintf("SendMessageBatchRequestEntry.%d", i)
+			id := r.Form.Get(prefix + ".Id")
+			if id == "" {
+				break
+			}
+			if i > 10 {
+				f.error(w, "AWS.SimpleQueueService.TooManyEntriesInBatchRequest")
+				return
+			}
+
+			msg := fakeMessage{
+				ID:              uuid.NewString(),
+				Body:            r.Form.Get(prefix + ".MessageBody"),
+				GroupID:         r.Form.Get(prefix + ".MessageGroupId"),
+				DeduplicationID: r.Form.Get(prefix + ".MessageDeduplicationId"),
+				Attributes:      formAttributes(r, prefix+".MessageAttribute"),
+			}
+			if f.fail != nil && f.fail(msg.Body) {
+				result.Failed = append(result.Failed, batchErrorEntry{ID: id, Code: "InternalError", Message: "failed", SenderFault: false})
+				continue
+			}
+			f.queues[queue] = append(f.queues[queue], msg)
+			result.Successful = append(result.Successful, resultEntry{ID: id, MessageID: msg.ID, MD5OfMessageBody: md5Hex(msg.Body)})
+		}
+		f.write(w, action, result)
+
+	case "ReceiveMessage":
+		max, _ := strconv.Atoi(r.Form.Get("MaxNumberOfMessages"))
+		if max == 0 {
+			max = 1
+		}
+
+		type attribute struct {
+			Name  string
+			Value struct {
+				StringValue string
+				DataType    string
+			}
+		}
+		type systemAttribute struct {
+			Name  string
+			Value string
+		}
+		type message struct {
+			MessageID        string `xml:"MessageId"`
+			ReceiptHandle    string
+			MD5OfBody        string
+			Body             string
+			Attribute        []systemAttribute
+			MessageAttribute []attribute
+		}
+		var result struct {
+			Message []message
+		}
+
+		for len(f.queues[queue]) > 0 && len(result.Message) < max {
+			msg := f.queues[queue][0]
+			f.queues[queue] = f.queues[queue][1:]
+			msg.receiptHandle = uuid.NewString()
+			f.inflight[msg.receiptHandle] = msg
+
+			m := message{MessageID: msg.ID, ReceiptHandle: msg.receiptHandle, MD5OfBody: md5Hex(msg.Body), Body: msg.Body}
+			if msg.GroupID != "" {
+				m.Attribute = append(m.Attribute, systemAttribute{Name: "MessageGroupId", Value: msg.GroupID})
+			}
+			for k, v := range msg.Attributes {
+				a := attribute{Name: k}
+				a.Value.StringValue = v
+				a.Value.DataType = "String"
+				m.MessageAttribute = append(m.MessageAttribute, a)
+			}
+			result.Message = append(result.Message, m)
+		}
+		if len(result.Message) == 0 {
+			// Emulate a short long polling to keep pollers from spinning.
+			f.mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			f.mu.Lock()
+		}
+		f.write(w, action, result)
+
+	case "DeleteMessage":
+		delete(f.inflight, r.Form.Get("ReceiptHandle"))
+		f.write(w, action, struct{}{})
+
+	case "DeleteMessageBatch":
+		type resultEntry struct {
+			ID string `xml:"Id"`
+		}
+		var result struct {
+			Successful []resultEntry     `xml:"DeleteMessageBatchResultEntry"`
+			Failed     []batchErrorEntry `xml:"BatchResultErrorEntry"`
+		}
+
+		for i := 1; ; i++ {
+			prefix := fmt.Sprintf("DeleteMessageBatchRequestEntry.%d", i)
+			id := r.Form.Get(prefix + ".Id")
+			if id == "" {
+				break
+			}
+			if i > 10 {
+				f.error(w, "AWS.SimpleQueueService.TooManyEntriesInBatchRequest")
+				return
+			}
+
+			handle := r.Form.Get(prefix + ".ReceiptHandle")
+			if _, ok := f.inflight[handle]; !ok {
+				result.Failed = append(result.Failed, batchErrorEntry{ID: id, Code: "ReceiptHandleIsInvalid", Message: "invalid", SenderFault: true})
+				continue
+			}
+			delete(f.inflight, handle)
+			result.Successful = append(result.Successful, resultEntry{ID: id})
+		}
+		f.write(w, action, result)
+
+	default:
+		f.error(w, "InvalidAction")
+	}
+}
+
+// batchErrorEntry is a failed entry of a batch request.
+type batchErrorEntry struct {
+	ID          string `xml:"Id"`
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+// write writes the result of the action in the format of the query protocol.
+func (f *fakeSQS) write(w http.ResponseWriter, action string, result any) {
+	var buf bytes.Buffer
+	buf.WriteString("<" + action + "Response>")
+	if err := xml.NewEncoder(&buf).EncodeElement(result, xml.StartElement{Name: xml.Name{Local: action + "Result"}}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf.WriteString("<ResponseMetadata><RequestId>" + uuid.NewString() + "</RequestId></ResponseMetadata>")
+	buf.WriteString("</" + action + "Response>")
+
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (f *fakeSQS) error(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error>`+
+		`<RequestId>%s</RequestId></ErrorResponse>`, code, code, uuid.NewString())
+}
+
+// formAttributes returns message attributes with string values from the request form.
+func formAttributes(r *http.Request, prefix string) map[string]string {
+	attrs := make(map[string]string)
+	for i := 1; ; i++ {
+		name := r.Form.Get(fmt.Sprintf("%s.%d.Name", prefix, i))
+		if name == "" {
+			return attrs
+		}
+		attrs[name] = r.Form.Get(fmt.Sprintf("%s.%d.Value.StringValue", prefix, i))
+	}
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// queueAttributes returns attributes of the queue.
+func (f *fakeSQS) queueAttributes(queue string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.attributes[queue]
+}
+
+// formQueueAttributes returns queue attributes from the request form.
+func formQueueAttributes(r *http.Request) map[string]string {
+	attrs := make(map[string]string)
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("Attribute.%d", i)
+		name := r.Form.Get(prefix + ".Name")
+		if name == "" {
+			return attrs
+		}
+		attrs[name] = r.Form.Get(prefix + ".Value")
+	}
+}
@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Names of the message attributes carrying a signature of the message body.
+const (
+	attributeSignature      = "Signature"
+	attributeSignatureKeyID = "SignatureKeyId"
+)
+
+// Set of error variables for message signatures.
+var (
+	ErrUnsigned         = errors.New("message is not signed")
+	ErrUnknownKey       = errors.New("unknown signature key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrForeignKey       = errors.New("signature key of another service")
+)
+
+// Keyring holds shared secrets for signing and verifying messages with HMAC-SHA256.
+// Messages are signed with the current key and verified with any key of the keyring,
+// so a new key can be rolled out to all services before it becomes the current one.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+	owners  map[string]string
+}
+
+// KeyringOption configures optional behaviour of a Keyring.
+type KeyringOption func(*Keyring)
+
+// WithOwners maps key ids to names of the services owning them. A keyring with owners verifies only
+// messages signed with an owned key and sent by its owner, so a service can't impersonate another one.
+func WithOwners(owners map[string]string) KeyringOption {
+	return func(k *Keyring) {
+		k.owners = owners
+	}
+}
+
+// NewKeyring constructs a Keyring from key ids mapped to secrets. The current key is used for signing.
+func NewKeyring(current string, keys map[string]string, opts ...KeyringOption) (Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return Keyring{}, fmt.Errorf("%w: %q", ErrUnknownKey, current)
+	}
+
+	k := Keyring{current: current, keys: make(map[string][]byte, len(keys))}
+	for id, secret := range keys {
+		if secret == "" {
+			return Keyring{}, fmt.Errorf("empty secret for key %q", id)
+		}
+		k.keys[id] = []byte(secret)
+	}
+	for _, opt := range opts {
+		opt(&k)
+	}
+
+	for id, owner := range k.owners {
+		if _, ok := k.keys[id]; !ok {
+			return Keyring{}, fmt.Errorf("%w: %q of service %q", ErrUnknownKey, id, owner)
+		}
+		if owner == "" {
+			return Keyring{}, fmt.Errorf("empty owner for key %q", id)
+		}
+	}
+
+	return k, nil
+}
+
+// Sign returns message attributes with a signature of the body made with the current key.
+func (k Keyring) Sign(body string) map[string]*sqs.MessageAttributeValue {
+	return map[string]*sqs.MessageAttributeValue{
+		attributeSignature: {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(k.signature(k.current, body)),
+		},
+		attributeSignatureKeyID: {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(k.current),
+		},
+	}
+}
+
+// Verify checks the signature of the body carried in the message attributes. With owners of keys
+// the body has to be a message of the service owning the key.
+func (k Keyring) Verify(body string, attrs map[string]*sqs.MessageAttributeValue) error {
+	sig, keyID := attrs[attributeSignature], attrs[attributeSignatureKeyID]
+	if sig == nil || sig.StringValue == nil || keyID == nil || keyID.StringValue == nil {
+		return ErrUnsigned
+	}
+
+	if _, ok := k.keys[*keyID.StringValue]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, *keyID.StringValue)
+	}
+
+	expected := k.signature(*keyID.StringValue, body)
+	if !hmac.Equal([]byte(expected), []byte(*sig.StringValue)) {
+		return ErrInvalidSignature
+	}
+
+	if len(k.owners) > 0 {
+		return k.verifyOwner(*keyID.StringValue, body)
+	}
+
+	return nil
+}
+
+// verifyOwner checks that the body is a message of the service owning the key.
+func (k Keyring) verifyOwner(keyID, body string) error {
+	owner, ok := k.owners[keyID]
+	if !ok {
+		return fmt.Errorf("%w: key %q has no owner", ErrForeignKey, keyID)
+	}
+
+	var msg struct {
+		Service string `json:"service"`
+	}
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return fmt.Errorf("%w: unmarshal: %v", ErrForeignKey, err)
+	}
+	if msg.Service != owner {
+		return fmt.Errorf("%w: key %q of service %q signed a message of service %q", ErrForeignKey, keyID, owner, msg.Service)
+	}
+
+	return nil
+}
+
+func (k Keyring) signature(keyID, body string) string {
+	mac := hmac.New(sha256.New, k.keys[keyID])
+	mac.Write([]byte(body))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,86 @@
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+func TestKeyring(t *testing.T) {
+	const body = `{"saga_id":"45b5fbd3-755f-4379-8f07-a58d4a30fa2f","service":"service1","status":"done"}`
+
+	t.Run("signed message is verified", func(t *testing.T) {
+		k, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1"})
+		require.NoError(t, err)
+
+		assert.NoError(t, k.Verify(body, k.Sign(body)))
+	})
+
+	t.Run("tampered message", func(t *testing.T) {
+		k, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1"})
+		require.NoError(t, err)
+
+		attrs := k.Sign(body)
+		assert.ErrorIs(t, k.Verify(body+" ", attrs), queue.ErrInvalidSignature)
+	})
+
+	t.Run("unsigned message", func(t *testing.T) {
+		k, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1"})
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, k.Verify(body, nil), queue.ErrUnsigned)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		old, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1"})
+		require.NoError(t, err)
+		rotated, err := queue.NewKeyring("k2", map[string]string{"k1": "secret1", "k2": "secret2"})
+		require.NoError(t, err)
+
+		// A service with the rotated keyring accepts messages signed with the previous key.
+		assert.NoError(t, rotated.Verify(body, old.Sign(body)))
+		// A service which has not got the new key yet rejects messages signed with it.
+		assert.ErrorIs(t, old.Verify(body, rotated.Sign(body)), queue.ErrUnknownKey)
+	})
+
+	t.Run("secret of another key", func(t *testing.T) {
+		k, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1", "k2": "secret2"})
+		require.NoError(t, err)
+
+		attrs := k.Sign(body)
+		attrs["SignatureKeyId"].StringValue = aws.String("k2")
+		assert.ErrorIs(t, k.Verify(body, attrs), queue.ErrInvalidSignature)
+	})
+
+	t.Run("current key is missing", func(t *testing.T) {
+		_, err := queue.NewKeyring("k2", map[string]string{"k1": "secret1"})
+		assert.ErrorIs(t, err, queue.ErrUnknownKey)
+	})
+
+	t.Run("keys of services", func(t *testing.T) {
+		keys := map[string]string{"saga": "secret", "k1": "secret1", "k2": "secret2"}
+		saga, err := queue.NewKeyring("saga", keys, queue.WithOwners(map[string]string{"k1": "service1", "k2": "service2"}))
+		require.NoError(t, err)
+		service1, err := queue.NewKeyring("k1", keys)
+		require.NoError(t, err)
+		service2, err := queue.NewKeyring("k2", keys)
+		require.NoError(t, err)
+		own, err := queue.NewKeyring("saga", keys)
+		require.NoError(t, err)
+
+		assert.NoError(t, saga.Verify(body, service1.Sign(body)))
+		// A service can't sign responses of another service, even with a valid signature.
+		assert.ErrorIs(t, saga.Verify(body, service2.Sign(body)), queue.ErrForeignKey)
+		// Keys without an owner don't verify any message.
+		assert.ErrorIs(t, saga.Verify(body, own.Sign(body)), queue.ErrForeignKey)
+	})
+
+	t.Run("owner of an unknown key", func(t *testing.T) {
+		_, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1"}, queue.WithOwners(map[string]string{"k2": "service2"}))
+		assert.ErrorIs(t, err, queue.ErrUnknownKey)
+	})
+}
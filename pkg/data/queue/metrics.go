@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of messages handled by pollers and senders labelled by the queue name.
+var (
+	messagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Subsystem: "queue",
+		Name:      "messages_received_total",
+		Help:      "Number of messages received by pollers.",
+	}, []string{"queue"})
+	messagesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Subsystem: "queue",
+		Name:      "messages_processed_total",
+		Help:      "Number of messages processed successfully by pollers.",
+	}, []string{"queue"})
+	messagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Subsystem: "queue",
+		Name:      "messages_failed_total",
+		Help:      "Number of messages failed to be processed and left in the queue.",
+	}, []string{"queue"})
+	messagesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Subsystem: "queue",
+		Name:      "messages_rejected_total",
+		Help:      "Number of messages rejected by pollers.",
+	}, []string{"queue"})
+	messagesDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Subsystem: "queue",
+		Name:      "messages_deleted_total",
+		Help:      "Number of messages deleted from the queue by pollers.",
+	}, []string{"queue"})
+	messagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "saga",
+		Subsystem: "queue",
+		Name:      "messages_sent_total",
+		Help:      "Number of messages sent to the queue.",
+	}, []string{"queue"})
+)
@@ -3,27 +3,70 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/sys/requestid"
 )
 
 type Processor interface {
 	ProcessMessage(context.Context, any) error
 }
 
+// PollOption configures optional behaviour of a Poll.
+type PollOption func(*pollOptions)
+
+type pollOptions struct {
+	deadLetter *Sender
+	keyring    *Keyring
+	strict     bool
+	cipher     Cipher
+}
+
+// WithDeadLetter sets a queue where rejected messages are moved to. Without it rejected messages
+// stay in the incoming queue and are handled by its redrive policy.
+func WithDeadLetter(s *Sender) PollOption {
+	return func(o *pollOptions) {
+		o.deadLetter = s
+	}
+}
+
+// WithVerification makes the Poll verify signatures of received messages against the keyring.
+// In strict mode unsigned or tampered messages are rejected, otherwise they are only logged.
+func WithVerification(k Keyring, strict bool) PollOption {
+	return func(o *pollOptions) {
+		o.keyring = &k
+		o.strict = strict
+	}
+}
+
 type Poll[M Message] struct {
 	incoming *Receiver
 	target   Processor
 	logger   *zap.SugaredLogger
+	opts     pollOptions
 }
 
-func NewPoll[M Message](incoming *Receiver, target Processor, logger *zap.SugaredLogger) (Poll[M], error) {
-	return Poll[M]{incoming: incoming, target: target, logger: logger}, nil
+func NewPoll[M Message](incoming *Receiver, target Processor, logger *zap.SugaredLogger, opts ...PollOption) (Poll[M], error) {
+	p := Poll[M]{incoming: incoming, target: target, logger: logger}
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+
+	return p, nil
 }
 
 // Start polling the incoming queue and calls target's ProcessMessage method for each received message.
-// The message is removed from the queue if the processing was successful.
+// The messages processed successfully are removed from the queue in a batch after every receive.
+// A message which can't be unmarshalled or is rejected by the target is moved to the dead-letter queue.
+// After a message of a FIFO message group is left in the queue, the following messages of the group in
+// the batch are left too, so the group is processed in order when the message is received again.
 // TODO Add concurrency calling ProcessMessage with limiting goroutines number.
 func (p Poll[M]) Start(ctx context.Context) error {
 	for !isDone(ctx) {
@@ -33,34 +76,114 @@ func (p Poll[M]) Start(ctx context.Context) error {
 			continue
 		}
 
+		var done []*string
+		blocked := make(map[string]bool)
 		for _, m := range msgs {
 			if isDone(ctx) {
-				return nil
+				break
 			}
-			var msg M
-
-			err := json.Unmarshal([]byte(*m.Body), &msg)
-			if err != nil {
-				p.logger.Errorw("poll", "ERROR", fmt.Errorf("listener: could not unmarshal response event"))
+			group := aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+			if group != "" && blocked[group] {
 				continue
 			}
-			p.logger.Infow("poll", "INFO", "listener", "message", m.String())
-
-			if err = p.target.ProcessMessage(ctx, msg); err != nil {
-				p.logger.Errorw("poll", "ERROR", fmt.Errorf("listener: processing response: %w", err))
-				p.logger.Infow("poll", "INFO", "listener: poll has stopped", "message", m.String())
-				continue
+			if p.process(ctx, m) {
+				done = append(done, m.ReceiptHandle)
+			} else if group != "" {
+				blocked[group] = true
 			}
+		}
 
-			if err = p.incoming.DeleteMessage(ctx, m.ReceiptHandle); err != nil {
-				p.logger.Errorw("poll", "ERROR", fmt.Errorf("listener: %w", err))
-			}
+		// The messages are deleted even if polling is stopped, otherwise they would be processed again.
+		if err := p.incoming.DeleteMessages(context.Background(), done); err != nil {
+			p.logger.Errorw("poll", "ERROR", fmt.Errorf("listener: %w", err))
 		}
 	}
 
 	return nil
 }
 
+// process handles one message and reports whether the message has to be removed from the queue.
+// The message is processed in a span continuing the trace of its sender and with the ID of
+// the request which caused it, or with the message ID if the request is unknown or its ID isn't valid.
+func (p Poll[M]) process(ctx context.Context, m *sqs.Message) bool {
+	ctx, span := startSpan(extractTrace(ctx, m.MessageAttributes), "queue.process", p.incoming.name, trace.SpanKindConsumer)
+	span.SetAttributes(messageIDAttribute(m))
+	defer span.End()
+
+	id := aws.StringValue(m.MessageId)
+	if attr, ok := m.MessageAttributes[attributeRequestID]; ok && requestid.Valid(aws.StringValue(attr.StringValue)) {
+		id = aws.StringValue(attr.StringValue)
+	}
+	ctx = requestid.NewContext(ctx, id)
+
+	if err := p.verify(m); err != nil {
+		if p.opts.strict {
+			return p.reject(ctx, m, err)
+		}
+		p.logger.Warnw("poll", "WARNING", fmt.Errorf("listener: %w", err), "message", m.String())
+	}
+	var msg M
+
+	err := json.Unmarshal([]byte(*m.Body), &msg)
+	if err != nil {
+		return p.reject(ctx, m, fmt.Errorf("%w: unmarshal: %v", ErrRejected, err))
+	}
+	if p.opts.cipher != nil {
+		if err := decryptPayload(p.opts.cipher, &msg); err != nil {
+			return p.reject(ctx, m, fmt.Errorf("%w: %v", ErrRejected, err))
+		}
+	}
+	p.logger.Infow("poll", "INFO", "listener", "message", m.String())
+
+	if err = p.target.ProcessMessage(ctx, msg); err != nil {
+		if errors.Is(err, ErrRejected) {
+			return p.reject(ctx, m, err)
+		}
+		messagesFailed.WithLabelValues(p.incoming.name).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		p.logger.Errorw("poll", "ERROR", fmt.Errorf("listener: processing response: %w", err))
+		p.logger.Infow("poll", "INFO", "listener: poll has stopped", "message", m.String())
+		return false
+	}
+	messagesProcessed.WithLabelValues(p.incoming.name).Inc()
+
+	return true
+}
+
+// verify checks the signature of the message if the Poll has a keyring.
+func (p Poll[M]) verify(m *sqs.Message) error {
+	if p.opts.keyring == nil {
+		return nil
+	}
+
+	if err := p.opts.keyring.Verify(*m.Body, m.MessageAttributes); err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+
+	return nil
+}
+
+// reject moves the message to the dead-letter queue and reports whether it can be removed from the incoming queue.
+// Without a dead-letter queue the message is left for the redrive policy of the incoming queue.
+func (p Poll[M]) reject(ctx context.Context, m *sqs.Message, reason error) bool {
+	messagesRejected.WithLabelValues(p.incoming.name).Inc()
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(reason)
+	span.SetStatus(codes.Error, reason.Error())
+	p.logger.Errorw("poll", "ERROR", fmt.Errorf("listener: %w", reason), "message", m.String())
+	if p.opts.deadLetter == nil {
+		return false
+	}
+
+	if err := p.opts.deadLetter.Forward(ctx, m, reason.Error()); err != nil {
+		p.logger.Errorw("poll", "ERROR", fmt.Errorf("listener: dead letter: %w", err))
+		return false
+	}
+
+	return true
+}
+
 func isDone(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
@@ -0,0 +1,181 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+// processor records processed messages and returns the configured error.
+type processor struct {
+	mu   sync.Mutex
+	msgs []any
+	err  error
+}
+
+func (p *processor) ProcessMessage(_ context.Context, msg any) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.msgs = append(p.msgs, msg)
+	return p.err
+}
+
+func (p *processor) processed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.msgs)
+}
+
+func TestPoll_Start(t *testing.T) {
+	response := queue.Response{ID: uuid.New(), SagaID: uuid.New(), Service: "service1", Status: "done"}
+	body, err := json.Marshal(response)
+	require.NoError(t, err)
+
+	t.Run("processed message is deleted", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses")
+		fake.push("responses", string(body), nil)
+
+		target := &processor{}
+		runPoll(t, fake, target, func() bool { return target.processed() == 1 && fake.inflightCount() == 0 })
+
+		assert.Equal(t, []any{response}, target.msgs)
+	})
+
+	t.Run("failed message is kept for redelivery", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses", "responses-dlq")
+		fake.push("responses", string(body), nil)
+
+		target := &processor{err: fmt.Errorf("db is down")}
+		runPoll(t, fake, target, func() bool { return target.processed() == 1 }, withDeadLetter(t, fake))
+
+		assert.Equal(t, 1, fake.inflightCount())
+		assert.Empty(t, fake.messages("responses-dlq"))
+	})
+
+	t.Run("messages of a group after a failed one are kept", func(t *testing.T) {
+		first := queue.Response{ID: uuid.New(), SagaID: response.SagaID, Service: "service1", Status: "done"}
+		second := queue.Response{ID: uuid.New(), SagaID: response.SagaID, Service: "service2", Status: "done"}
+		other := queue.Response{ID: uuid.New(), SagaID: uuid.New(), Service: "service1", Status: "done"}
+
+		fake := newFakeSQS(t, "responses.fifo")
+		for _, r := range []queue.Response{first, second} {
+			b, err := json.Marshal(r)
+			require.NoError(t, err)
+			fake.pushToGroup("responses.fifo", r.SagaID.String(), string(b))
+		}
+		b, err := json.Marshal(other)
+		require.NoError(t, err)
+		fake.pushToGroup("responses.fifo", other.SagaID.String(), string(b))
+
+		target := &failingProcessor{fail: first.ID}
+		runPollQueue(t, fake, "responses.fifo", target, func() bool { return target.processed() == 2 && fake.inflightCount() == 2 })
+
+		assert.Equal(t, []any{first, other}, target.msgs)
+	})
+
+	t.Run("rejected message is moved to the dead-letter queue", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses", "responses-dlq")
+		fake.push("responses", string(body), nil)
+
+		target := &processor{err: fmt.Errorf("%w: invalid payload", queue.ErrRejected)}
+		runPoll(t, fake, target, func() bool { return len(fake.messages("responses-dlq")) == 1 }, withDeadLetter(t, fake))
+
+		dlq := fake.messages("responses-dlq")
+		assert.Equal(t, string(body), dlq[0].Body)
+		assert.Contains(t, dlq[0].Attributes["RejectReason"], "invalid payload")
+		assert.Zero(t, fake.inflightCount())
+	})
+
+	t.Run("malformed message is moved to the dead-letter queue", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses", "responses-dlq")
+		fake.push("responses", "{", nil)
+
+		target := &processor{}
+		runPoll(t, fake, target, func() bool { return len(fake.messages("responses-dlq")) == 1 }, withDeadLetter(t, fake))
+
+		assert.Zero(t, target.processed())
+	})
+
+	t.Run("unsigned message in strict mode", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses", "responses-dlq")
+		fake.push("responses", string(body), nil)
+
+		k, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1"})
+		require.NoError(t, err)
+
+		target := &processor{}
+		runPoll(t, fake, target, func() bool { return len(fake.messages("responses-dlq")) == 1 },
+			withDeadLetter(t, fake), queue.WithVerification(k, true))
+
+		assert.Zero(t, target.processed())
+	})
+
+	t.Run("unsigned message in lenient mode", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses")
+		fake.push("responses", string(body), nil)
+
+		k, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1"})
+		require.NoError(t, err)
+
+		target := &processor{}
+		runPoll(t, fake, target, func() bool { return target.processed() == 1 }, queue.WithVerification(k, false))
+	})
+}
+
+func withDeadLetter(t *testing.T, fake *fakeSQS) queue.PollOption {
+	dlq, err := queue.NewSender(fake.client(), "responses-dlq")
+	require.NoError(t, err)
+
+	return queue.WithDeadLetter(dlq)
+}
+
+// failingProcessor records processed messages and fails the response with the ID.
+type failingProcessor struct {
+	processor
+	fail uuid.UUID
+}
+
+func (p *failingProcessor) ProcessMessage(ctx context.Context, msg any) error {
+	_ = p.processor.ProcessMessage(ctx, msg)
+	if msg.(queue.Response).ID == p.fail {
+		return fmt.Errorf("db is down")
+	}
+
+	return nil
+}
+
+// runPoll polls the responses queue until the condition is met.
+func runPoll(t *testing.T, fake *fakeSQS, target queue.Processor, cond func() bool, opts ...queue.PollOption) {
+	runPollQueue(t, fake, "responses", target, cond, opts...)
+}
+
+// runPollQueue polls the queue until the condition is met.
+func runPollQueue(t *testing.T, fake *fakeSQS, name string, target queue.Processor, cond func() bool, opts ...queue.PollOption) {
+	r, err := queue.NewReceiver(fake.client(), name, 10, 0)
+	require.NoError(t, err)
+
+	p, err := queue.NewPoll[queue.Response](&r, target, zap.NewNop().Sugar(), opts...)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.Start(ctx)
+	}()
+
+	assert.Eventually(t, cond, time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+}
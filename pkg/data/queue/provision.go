@@ -0,0 +1,225 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// deadLetterSuffix is appended to names of dead-letter queues.
+const deadLetterSuffix = "-dlq"
+
+// AttributeExists is the attribute of a drift of a missing queue.
+const AttributeExists = "Exists"
+
+// errNoDeadLetter is returned when the dead-letter queue of a spec doesn't exist.
+var errNoDeadLetter = errors.New("dead-letter queue doesn't exist")
+
+// Spec describes a queue and its attributes. Queues with names ending with .fifo are FIFO queues.
+type Spec struct {
+	Name              string
+	VisibilityTimeout time.Duration
+	RetentionPeriod   time.Duration
+	// DeadLetter is the name of the queue which receives messages received more than MaxReceiveCount times.
+	DeadLetter      string
+	MaxReceiveCount int
+}
+
+// Drift is a difference between the spec of a queue and the queue.
+type Drift struct {
+	Queue     string
+	Attribute string
+	Want      string
+	Got       string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: %s is %q, want %q", d.Queue, d.Attribute, d.Got, d.Want)
+}
+
+// DeadLetterName returns the name of the dead-letter queue of the queue. The dead-letter queue of a FIFO queue is FIFO.
+func DeadLetterName(queueName string) string {
+	if isFIFO(queueName) {
+		return strings.TrimSuffix(queueName, fifoSuffix) + deadLetterSuffix + fifoSuffix
+	}
+
+	return queueName + deadLetterSuffix
+}
+
+// redrivePolicy is the RedrivePolicy attribute of a queue.
+type redrivePolicy struct {
+	DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+	MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+}
+
+// Provision creates the queues which don't exist and sets attributes of the existing ones.
+// Dead-letter queues have to be in the specs before the queues using them.
+func Provision(ctx context.Context, svc *sqs.SQS, specs []Spec) error {
+	for _, spec := range specs {
+		attrs, err := attributes(ctx, svc, spec)
+		if err != nil {
+			return err
+		}
+
+		queueURL, err := findQueue(ctx, svc, spec.Name)
+		if err != nil {
+			return err
+		}
+		if queueURL == "" {
+			if isFIFO(spec.Name) {
+				attrs[sqs.QueueAttributeNameFifoQueue] = "true"
+			}
+			_, err := svc.CreateQueueWithContext(ctx, &sqs.CreateQueueInput{
+				QueueName:  aws.String(spec.Name),
+				Attributes: aws.StringMap(attrs),
+			})
+			if err != nil {
+				return fmt.Errorf("create queue %s: %w", spec.Name, err)
+			}
+			continue
+		}
+
+		_, err = svc.SetQueueAttributesWithContext(ctx, &sqs.SetQueueAttributesInput{
+			QueueUrl:   aws.String(queueURL),
+			Attributes: aws.StringMap(attrs),
+		})
+		if err != nil {
+			return fmt.Errorf("set attributes of queue %s: %w", spec.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Verify returns differences between the specs and the queues.
+func Verify(ctx context.Context, svc *sqs.SQS, specs []Spec) ([]Drift, error) {
+	var drifts []Drift
+	for _, spec := range specs {
+		queueURL, err := findQueue(ctx, svc, spec.Name)
+		if err != nil {
+			return nil, err
+		}
+		if queueURL == "" {
+			drifts = append(drifts, Drift{Queue: spec.Name, Attribute: AttributeExists, Want: "true", Got: "false"})
+			continue
+		}
+
+		want, err := attributes(ctx, svc, spec)
+		if err != nil {
+			if errors.Is(err, errNoDeadLetter) {
+				// The redrive policy can't be compared without the dead-letter queue.
+				drifts = append(drifts, Drift{Queue: spec.Name, Attribute: sqs.QueueAttributeNameRedrivePolicy, Want: spec.DeadLetter})
+				continue
+			}
+			return nil, err
+		}
+		got, err := queueAttributes(ctx, svc, queueURL)
+		if err != nil {
+			return nil, fmt.Errorf("queue %s: %w", spec.Name, err)
+		}
+
+		for _, name := range []string{
+			sqs.QueueAttributeNameVisibilityTimeout,
+			sqs.QueueAttributeNameMessageRetentionPeriod,
+			sqs.QueueAttributeNameRedrivePolicy,
+		} {
+			if !sameAttribute(name, want[name], got[name]) {
+				drifts = append(drifts, Drift{Queue: spec.Name, Attribute: name, Want: want[name], Got: got[name]})
+			}
+		}
+		if gotFIFO := got[sqs.QueueAttributeNameFifoQueue] == "true"; gotFIFO != isFIFO(spec.Name) {
+			drifts = append(drifts, Drift{Queue: spec.Name, Attribute: sqs.QueueAttributeNameFifoQueue,
+				Want: strconv.FormatBool(isFIFO(spec.Name)), Got: strconv.FormatBool(gotFIFO)})
+		}
+	}
+
+	return drifts, nil
+}
+
+// attributes returns the settable attributes of the queue of the spec.
+func attributes(ctx context.Context, svc *sqs.SQS, spec Spec) (map[string]string, error) {
+	attrs := make(map[string]string)
+	if spec.VisibilityTimeout > 0 {
+		attrs[sqs.QueueAttributeNameVisibilityTimeout] = strconv.Itoa(int(spec.VisibilityTimeout.Seconds()))
+	}
+	if spec.RetentionPeriod > 0 {
+		attrs[sqs.QueueAttributeNameMessageRetentionPeriod] = strconv.Itoa(int(spec.RetentionPeriod.Seconds()))
+	}
+
+	if spec.DeadLetter != "" {
+		deadLetterURL, err := findQueue(ctx, svc, spec.DeadLetter)
+		if err != nil {
+			return nil, err
+		}
+		if deadLetterURL == "" {
+			return nil, fmt.Errorf("queue %s: %w: %s", spec.Name, errNoDeadLetter, spec.DeadLetter)
+		}
+		deadLetterAttrs, err := queueAttributes(ctx, svc, deadLetterURL)
+		if err != nil {
+			return nil, fmt.Errorf("queue %s: %w", spec.DeadLetter, err)
+		}
+
+		policy, err := json.Marshal(redrivePolicy{
+			DeadLetterTargetArn: deadLetterAttrs[sqs.QueueAttributeNameQueueArn],
+			MaxReceiveCount:     json.Number(strconv.Itoa(spec.MaxReceiveCount)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("json marshal: %w", err)
+		}
+		attrs[sqs.QueueAttributeNameRedrivePolicy] = string(policy)
+	}
+
+	return attrs, nil
+}
+
+// sameAttribute reports whether the wanted value of the attribute is set. Redrive policies are compared
+// by their fields, since the maximal receive count may be returned as a number or a string.
+func sameAttribute(name, want, got string) bool {
+	if want == "" {
+		return true
+	}
+	if name != sqs.QueueAttributeNameRedrivePolicy || got == "" {
+		return want == got
+	}
+
+	var w, g redrivePolicy
+	if json.Unmarshal([]byte(want), &w) != nil || json.Unmarshal([]byte(got), &g) != nil {
+		return want == got
+	}
+
+	return w == g
+}
+
+// findQueue returns the URL of the queue, or an empty string if the queue doesn't exist.
+func findQueue(ctx context.Context, svc *sqs.SQS, queueName string) (string, error) {
+	output, err := svc.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(queueName)})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == sqs.ErrCodeQueueDoesNotExist {
+			return "", nil
+		}
+		return "", fmt.Errorf("get url of queue %s: %w", queueName, err)
+	}
+
+	return aws.StringValue(output.QueueUrl), nil
+}
+
+func queueAttributes(ctx context.Context, svc *sqs.SQS, queueURL string) (map[string]string, error) {
+	output, err := svc.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get attributes: %w", err)
+	}
+
+	return aws.StringValueMap(output.Attributes), nil
+}
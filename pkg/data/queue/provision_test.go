@@ -0,0 +1,53 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+func TestDeadLetterName(t *testing.T) {
+	assert.Equal(t, "responses-dlq", queue.DeadLetterName("responses"))
+	assert.Equal(t, "responses-dlq.fifo", queue.DeadLetterName("responses.fifo"))
+}
+
+func TestProvision(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeSQS(t, "commands1")
+	client := fake.client()
+
+	specs := []queue.Spec{
+		{Name: "commands1-dlq", RetentionPeriod: 14 * 24 * time.Hour},
+		{Name: "commands1", VisibilityTimeout: 30 * time.Second, DeadLetter: "commands1-dlq", MaxReceiveCount: 5},
+		{Name: "responses.fifo", VisibilityTimeout: time.Minute},
+	}
+
+	drifts, err := queue.Verify(ctx, client, specs)
+	require.NoError(t, err)
+	assert.Equal(t, []queue.Drift{
+		{Queue: "commands1-dlq", Attribute: queue.AttributeExists, Want: "true", Got: "false"},
+		{Queue: "commands1", Attribute: "RedrivePolicy", Want: "commands1-dlq"},
+		{Queue: "responses.fifo", Attribute: queue.AttributeExists, Want: "true", Got: "false"},
+	}, drifts)
+
+	require.NoError(t, queue.Provision(ctx, client, specs))
+	assert.Equal(t, "1209600", fake.queueAttributes("commands1-dlq")["MessageRetentionPeriod"])
+	assert.Equal(t, "30", fake.queueAttributes("commands1")["VisibilityTimeout"])
+	assert.JSONEq(t, `{"deadLetterTargetArn": "arn:aws:sqs:us-west-1:000000000000:commands1-dlq", "maxReceiveCount": 5}`,
+		fake.queueAttributes("commands1")["RedrivePolicy"])
+	assert.Equal(t, "true", fake.queueAttributes("responses.fifo")["FifoQueue"])
+
+	drifts, err = queue.Verify(ctx, client, specs)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	specs[1].VisibilityTimeout = time.Minute
+	drifts, err = queue.Verify(ctx, client, specs)
+	require.NoError(t, err)
+	assert.Equal(t, []queue.Drift{{Queue: "commands1", Attribute: "VisibilityTimeout", Want: "60", Got: "30"}}, drifts)
+}
@@ -1,25 +1,63 @@
 package queue
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/google/uuid"
 )
 
+// ErrRejected is returned by a Processor when a message can never be processed successfully.
+// Such messages are moved to the dead-letter queue instead of being redelivered.
+var ErrRejected = errors.New("message rejected")
+
 type Message interface {
 	Command | Response
 }
 
+// fifoSuffix is the suffix of names of SQS FIFO queues.
+const fifoSuffix = ".fifo"
+
+// Command and Response carry an ID of the message. Messages with the same ID are duplicates
+// and FIFO queues drop them. Messages of a saga are ordered in FIFO queues by the saga ID.
 type Command struct {
-	SagaID uuid.UUID `json:"saga_id"`
-	Name   string    `json:"name"`
+	ID      uuid.UUID       `json:"id"`
+	SagaID  uuid.UUID       `json:"saga_id"`
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
 type Response struct {
-	SagaID  uuid.UUID `json:"saga_id"`
-	Service string    `json:"service"`
-	Status  string    `json:"status"`
+	ID      uuid.UUID       `json:"id"`
+	SagaID  uuid.UUID       `json:"saga_id"`
+	Service string          `json:"service"`
+	Status  string          `json:"status"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// MessageID returns an ID of a message derived from the saga ID and the name of the message,
+// e.g. a service and a command. Duplicates of a message sent on redelivery get the same ID.
+func MessageID(sagaID uuid.UUID, name string) uuid.UUID {
+	return uuid.NewSHA1(sagaID, []byte(name))
+}
+
+// groupAndDeduplicationID returns the message group ID and the deduplication ID of a message sent to a FIFO queue.
+func groupAndDeduplicationID(msg any) (string, string, error) {
+	switch m := msg.(type) {
+	case Command:
+		return m.SagaID.String(), m.ID.String(), nil
+	case Response:
+		return m.SagaID.String(), m.ID.String(), nil
+	}
+
+	return "", "", fmt.Errorf("message %T can't be sent to a FIFO queue", msg)
+}
+
+func isFIFO(queueName string) bool {
+	return strings.HasSuffix(queueName, fifoSuffix)
 }
 
 func getQueueURL(svc *sqs.SQS, queueName string) (string, error) {
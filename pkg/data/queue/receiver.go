@@ -3,15 +3,19 @@ package queue
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/uuid"
 )
 
 // Receiver struct holds functionality to receive messages off of an SQS queue.
 type Receiver struct {
 	sqs   *sqs.SQS
+	name  string
 	input *sqs.ReceiveMessageInput
+	fifo  bool
 }
 
 // NewReceiver function returns a configured Receiver with sqs connection, queue name, config, and logger used.
@@ -21,22 +25,38 @@ func NewReceiver(svc *sqs.SQS, name string, maxMessages, waitTime int64) (Receiv
 		return Receiver{}, fmt.Errorf("sqs input: %w", err)
 	}
 
-	return Receiver{
-		sqs: svc,
+	r := Receiver{
+		sqs:  svc,
+		name: name,
 		input: &sqs.ReceiveMessageInput{
-			MaxNumberOfMessages: aws.Int64(maxMessages),
-			QueueUrl:            &queueURL,
-			WaitTimeSeconds:     aws.Int64(waitTime),
+			MaxNumberOfMessages:   aws.Int64(maxMessages),
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+			QueueUrl:              &queueURL,
+			WaitTimeSeconds:       aws.Int64(waitTime),
 		},
-	}, nil
+		fifo: isFIFO(name),
+	}
+	if r.fifo {
+		r.input.AttributeNames = aws.StringSlice([]string{sqs.MessageSystemAttributeNameMessageGroupId})
+	}
+
+	return r, nil
 }
 
-// ReceiveMessages returns slice of messages from the queue.
+// ReceiveMessages returns slice of messages from the queue. Receiving from a FIFO queue
+// after a failed attempt is retried with the same attempt ID, so SQS returns the same
+// messages and the order of messages in a group is kept.
 func (r Receiver) ReceiveMessages(ctx context.Context) ([]*sqs.Message, error) {
+	if r.fifo && r.input.ReceiveRequestAttemptId == nil {
+		r.input.ReceiveRequestAttemptId = aws.String(uuid.NewString())
+	}
+
 	resp, err := r.sqs.ReceiveMessageWithContext(ctx, r.input)
 	if err != nil {
 		return nil, fmt.Errorf("sqs input: receive messages: %w", err)
 	}
+	r.input.ReceiveRequestAttemptId = nil
+	messagesReceived.WithLabelValues(r.name).Add(float64(len(resp.Messages)))
 
 	return resp.Messages, nil
 }
@@ -50,6 +70,50 @@ func (r Receiver) DeleteMessage(ctx context.Context, receiptHandle *string) erro
 	if err != nil {
 		return fmt.Errorf("delete message: %w", err)
 	}
+	messagesDeleted.WithLabelValues(r.name).Inc()
+
+	return nil
+}
 
+// DeleteMessages deletes messages with receipt handles in batches of up to 10 messages.
+// If some messages are not deleted, it returns a BatchError with errors indexed as the receipt handles.
+func (r Receiver) DeleteMessages(ctx context.Context, receiptHandles []*string) error {
+	batchErr := BatchError{Errors: make(map[int]error)}
+
+	for first := 0; first < len(receiptHandles); first += maxBatchEntries {
+		last := first + maxBatchEntries
+		if last > len(receiptHandles) {
+			last = len(receiptHandles)
+		}
+
+		entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, last-first)
+		for i := first; i < last; i++ {
+			entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: receiptHandles[i],
+			})
+		}
+
+		out, err := r.sqs.DeleteMessageBatchWithContext(ctx, &sqs.DeleteMessageBatchInput{
+			Entries:  entries,
+			QueueUrl: r.input.QueueUrl,
+		})
+		if err != nil {
+			for _, e := range entries {
+				batchErr.add(*e.Id, fmt.Errorf("delete message batch: %w", err))
+			}
+			continue
+		}
+
+		for _, f := range out.Failed {
+			batchErr.add(aws.StringValue(f.Id), fmt.Errorf("delete message: %s: %s",
+				aws.StringValue(f.Code), aws.StringValue(f.Message)))
+		}
+		messagesDeleted.WithLabelValues(r.name).Add(float64(len(out.Successful)))
+	}
+
+	if len(batchErr.Errors) > 0 {
+		return &batchErr
+	}
 	return nil
 }
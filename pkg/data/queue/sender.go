@@ -2,43 +2,233 @@ package queue
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/illyasch/saga-service/pkg/sys/requestid"
+)
+
+// Set of names of message attributes.
+const (
+	// attributeRejectReason is the message attribute with a reason why the message was rejected.
+	attributeRejectReason = "RejectReason"
+	// attributeRequestID is the message attribute with the ID of the request which caused the message.
+	attributeRequestID = "RequestId"
 )
 
 // Sender struct holds functionality to send notifications to an SQS queue.
 type Sender struct {
 	sqs      *sqs.SQS
+	name     string
 	queueURL string
+	fifo     bool
+	keyring  *Keyring
+	cipher   Cipher
+}
+
+// SenderOption configures optional behaviour of a Sender.
+type SenderOption func(*Sender)
+
+// WithSigning makes the Sender sign bodies of sent messages with the current key of the keyring.
+func WithSigning(k Keyring) SenderOption {
+	return func(s *Sender) {
+		s.keyring = &k
+	}
 }
 
 // NewSender returns a new Sender instance with SQS connection, queue name, logger, and notification store configured.
-func NewSender(q *sqs.SQS, queueName string) (*Sender, error) {
+func NewSender(q *sqs.SQS, queueName string, opts ...SenderOption) (*Sender, error) {
 	queueURL, err := getQueueURL(q, queueName)
 	if err != nil {
 		return nil, fmt.Errorf("sqs input: %w", err)
 	}
 
-	return &Sender{sqs: q, queueURL: queueURL}, nil
+	s := Sender{sqs: q, name: queueName, queueURL: queueURL, fifo: isFIFO(queueName)}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s, nil
 }
 
-// Send method sends a passed message to the queue.
-func (s Sender) Send(msg interface{}) error {
-	m := bytes.NewBuffer([]byte{})
-	if err := json.NewEncoder(m).Encode(msg); err != nil {
-		return fmt.Errorf("queue: sender: json marshal: %w", err)
+// Send method sends a passed message to the queue. A message sent to a FIFO queue is grouped
+// by its saga ID and deduplicated by its message ID.
+func (s Sender) Send(ctx context.Context, msg interface{}) (err error) {
+	ctx, span := startSpan(ctx, "queue.send", s.name, trace.SpanKindProducer)
+	defer func() { endSpan(span, err) }()
+
+	entry, err := s.entry(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("queue: sender: %w", err)
 	}
 
-	_, err := s.sqs.SendMessage(&sqs.SendMessageInput{
-		MessageBody: aws.String(m.String()),
-		QueueUrl:    &s.queueURL,
+	_, err = s.sqs.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		MessageBody:            entry.MessageBody,
+		MessageAttributes:      entry.MessageAttributes,
+		MessageGroupId:         entry.MessageGroupId,
+		MessageDeduplicationId: entry.MessageDeduplicationId,
+		QueueUrl:               &s.queueURL,
 	})
 	if err != nil {
 		return fmt.Errorf("queue: sender: send message: %w", err)
 	}
+	messagesSent.WithLabelValues(s.name).Inc()
+
+	return nil
+}
+
+// SendBatch method sends passed messages to the queue in batches of up to 10 messages.
+// If some messages are not sent, it returns a BatchError with errors indexed as the messages.
+func (s Sender) SendBatch(ctx context.Context, msgs []any) error {
+	traces := make([]context.Context, len(msgs))
+	for i := range traces {
+		traces[i] = ctx
+	}
+
+	return s.sendBatch(ctx, msgs, traces)
+}
+
+// sendBatch sends the messages in batches. Sending of every message is traced in a span
+// of the trace in the context with the same index.
+func (s Sender) sendBatch(ctx context.Context, msgs []any, traces []context.Context) error {
+	batchErr := BatchError{Errors: make(map[int]error)}
+
+	spans := make([]trace.Span, len(msgs))
+	defer func() {
+		for i := range spans {
+			endSpan(spans[i], batchErr.Errors[i])
+		}
+	}()
+
+	var entries []*sqs.SendMessageBatchRequestEntry
+	var size int
+	for i, msg := range msgs {
+		var msgCtx context.Context
+		msgCtx, spans[i] = startSpan(traces[i], "queue.send", s.name, trace.SpanKindProducer)
+
+		entry, err := s.entry(msgCtx, msg)
+		if err != nil {
+			batchErr.Errors[i] = fmt.Errorf("queue: sender: %w", err)
+			continue
+		}
+		entry.Id = aws.String(strconv.Itoa(i))
+
+		if len(entries) == maxBatchEntries || size+len(*entry.MessageBody) > maxBatchBytes {
+			s.sendEntries(ctx, entries, batchErr)
+			entries, size = nil, 0
+		}
+		entries = append(entries, entry)
+		size += len(*entry.MessageBody)
+	}
+	if len(entries) > 0 {
+		s.sendEntries(ctx, entries, batchErr)
+	}
+
+	if len(batchErr.Errors) > 0 {
+		return &batchErr
+	}
+	return nil
+}
+
+// sendEntries sends one batch and records errors of failed entries by their IDs.
+func (s Sender) sendEntries(ctx context.Context, entries []*sqs.SendMessageBatchRequestEntry, batchErr BatchError) {
+	out, err := s.sqs.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+		Entries:  entries,
+		QueueUrl: &s.queueURL,
+	})
+	if err != nil {
+		for _, e := range entries {
+			batchErr.add(*e.Id, fmt.Errorf("queue: sender: send message batch: %w", err))
+		}
+		return
+	}
+
+	for _, f := range out.Failed {
+		batchErr.add(aws.StringValue(f.Id), fmt.Errorf("queue: sender: send message: %s: %s",
+			aws.StringValue(f.Code), aws.StringValue(f.Message)))
+	}
+	messagesSent.WithLabelValues(s.name).Add(float64(len(out.Successful)))
+}
+
+// entry encrypts, encodes and signs the message. The trace context is attached to the message attributes.
+func (s Sender) entry(ctx context.Context, msg any) (*sqs.SendMessageBatchRequestEntry, error) {
+	if s.cipher != nil {
+		var err error
+		if msg, err = encryptPayload(s.cipher, msg); err != nil {
+			return nil, err
+		}
+	}
+
+	m := bytes.NewBuffer([]byte{})
+	if err := json.NewEncoder(m).Encode(msg); err != nil {
+		return nil, fmt.Errorf("json marshal: %w", err)
+	}
+
+	entry := sqs.SendMessageBatchRequestEntry{
+		MessageBody: aws.String(m.String()),
+	}
+	attrs := make(map[string]*sqs.MessageAttributeValue)
+	if s.keyring != nil {
+		attrs = s.keyring.Sign(m.String())
+	}
+	injectTrace(ctx, attrs)
+	if id := requestid.FromContext(ctx); id != "" {
+		attrs[attributeRequestID] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(id),
+		}
+	}
+	if len(attrs) > 0 {
+		entry.MessageAttributes = attrs
+	}
+	if s.fifo {
+		groupID, deduplicationID, err := groupAndDeduplicationID(msg)
+		if err != nil {
+			return nil, err
+		}
+		entry.MessageGroupId = &groupID
+		entry.MessageDeduplicationId = &deduplicationID
+	}
+
+	return &entry, nil
+}
+
+// Forward method sends a received message to the queue as is, keeping its body and attributes.
+// The reason is attached as a message attribute. It is used to move rejected messages to a dead-letter queue.
+func (s Sender) Forward(ctx context.Context, m *sqs.Message, reason string) error {
+	attrs := make(map[string]*sqs.MessageAttributeValue, len(m.MessageAttributes)+1)
+	for k, v := range m.MessageAttributes {
+		attrs[k] = v
+	}
+	attrs[attributeRejectReason] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(reason),
+	}
+
+	input := sqs.SendMessageInput{
+		MessageBody:       m.Body,
+		MessageAttributes: attrs,
+		QueueUrl:          &s.queueURL,
+	}
+	if s.fifo {
+		groupID := m.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]
+		if groupID == nil {
+			groupID = m.MessageId
+		}
+		input.MessageGroupId = groupID
+		input.MessageDeduplicationId = m.MessageId
+	}
+
+	if _, err := s.sqs.SendMessageWithContext(ctx, &input); err != nil {
+		return fmt.Errorf("queue: sender: forward message: %w", err)
+	}
+	messagesSent.WithLabelValues(s.name).Inc()
 
 	return nil
 }
@@ -1 +1,85 @@
 package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+func TestSender_Send(t *testing.T) {
+	t.Run("standard queue", func(t *testing.T) {
+		fake := newFakeSQS(t, "commands")
+		s, err := queue.NewSender(fake.client(), "commands")
+		require.NoError(t, err)
+
+		cmd := queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: "start"}
+		require.NoError(t, s.Send(context.Background(), cmd))
+
+		msgs := fake.messages("commands")
+		require.Len(t, msgs, 1)
+		assert.Empty(t, msgs[0].GroupID)
+		assert.Empty(t, msgs[0].DeduplicationID)
+
+		var got queue.Command
+		require.NoError(t, json.Unmarshal([]byte(msgs[0].Body), &got))
+		assert.Equal(t, cmd, got)
+	})
+
+	t.Run("FIFO queue", func(t *testing.T) {
+		fake := newFakeSQS(t, "commands.fifo")
+		s, err := queue.NewSender(fake.client(), "commands.fifo")
+		require.NoError(t, err)
+
+		cmd := queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: "start"}
+		require.NoError(t, s.Send(context.Background(), cmd))
+
+		msgs := fake.messages("commands.fifo")
+		require.Len(t, msgs, 1)
+		assert.Equal(t, cmd.SagaID.String(), msgs[0].GroupID)
+		assert.Equal(t, cmd.ID.String(), msgs[0].DeduplicationID)
+	})
+
+	t.Run("FIFO queue with an unknown message", func(t *testing.T) {
+		fake := newFakeSQS(t, "commands.fifo")
+		s, err := queue.NewSender(fake.client(), "commands.fifo")
+		require.NoError(t, err)
+
+		assert.Error(t, s.Send(context.Background(), struct{}{}))
+		assert.Empty(t, fake.messages("commands.fifo"))
+	})
+
+	t.Run("signed message", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses")
+		k, err := queue.NewKeyring("k1", map[string]string{"k1": "secret1"})
+		require.NoError(t, err)
+		s, err := queue.NewSender(fake.client(), "responses", queue.WithSigning(k))
+		require.NoError(t, err)
+
+		require.NoError(t, s.Send(context.Background(), queue.Response{SagaID: uuid.New(), Service: "service1", Status: "done"}))
+
+		msgs := fake.messages("responses")
+		require.Len(t, msgs, 1)
+		assert.Equal(t, "k1", msgs[0].Attributes["SignatureKeyId"])
+		assert.NotEmpty(t, msgs[0].Attributes["Signature"])
+	})
+
+	t.Run("unknown queue", func(t *testing.T) {
+		fake := newFakeSQS(t)
+		_, err := queue.NewSender(fake.client(), "commands")
+		assert.Error(t, err)
+	})
+}
+
+func TestMessageID(t *testing.T) {
+	sagaID := uuid.New()
+
+	assert.Equal(t, queue.MessageID(sagaID, "service1/start"), queue.MessageID(sagaID, "service1/start"))
+	assert.NotEqual(t, queue.MessageID(sagaID, "service1/start"), queue.MessageID(sagaID, "service2/start"))
+	assert.NotEqual(t, queue.MessageID(sagaID, "service1/start"), queue.MessageID(uuid.New(), "service1/start"))
+}
@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/illyasch/saga-service/pkg/data/queue"
+
+// attributeCarrier carries trace context in message attributes.
+type attributeCarrier map[string]*sqs.MessageAttributeValue
+
+func (c attributeCarrier) Get(key string) string {
+	if v, ok := c[key]; ok {
+		return aws.StringValue(v.StringValue)
+	}
+	return ""
+}
+
+func (c attributeCarrier) Set(key, value string) {
+	c[key] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
+
+func (c attributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startSpan starts a span of sending or processing a message in the queue.
+func startSpan(ctx context.Context, name, queue string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("aws_sqs"),
+			semconv.MessagingDestinationKey.String(queue),
+		),
+	)
+}
+
+// injectTrace adds the trace context of the span in the context to the message attributes.
+func injectTrace(ctx context.Context, attrs map[string]*sqs.MessageAttributeValue) {
+	otel.GetTextMapPropagator().Inject(ctx, attributeCarrier(attrs))
+}
+
+// extractTrace returns the context with the trace context from the message attributes.
+func extractTrace(ctx context.Context, attrs map[string]*sqs.MessageAttributeValue) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, attributeCarrier(attrs))
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// messageIDAttribute returns the attribute with the ID of the received message.
+func messageIDAttribute(m *sqs.Message) attribute.KeyValue {
+	return semconv.MessagingMessageIDKey.String(aws.StringValue(m.MessageId))
+}
@@ -0,0 +1,114 @@
+package queue_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+// tracedProcessor records span contexts of processed messages.
+type tracedProcessor struct {
+	mu    sync.Mutex
+	spans []trace.SpanContext
+}
+
+func (p *tracedProcessor) ProcessMessage(ctx context.Context, _ any) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.spans = append(p.spans, trace.SpanContextFromContext(ctx))
+	return nil
+}
+
+func (p *tracedProcessor) processed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.spans)
+}
+
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "saga")
+	defer parent.End()
+
+	t.Run("single message", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses")
+		s, err := queue.NewSender(fake.client(), "responses")
+		require.NoError(t, err)
+
+		require.NoError(t, s.Send(ctx, queue.Response{ID: uuid.New(), SagaID: uuid.New(), Status: "done"}))
+		assert.NotEmpty(t, fake.messages("responses")[0].Attributes["traceparent"])
+
+		target := &tracedProcessor{}
+		runPoll(t, fake, target, func() bool { return target.processed() == 1 })
+
+		assert.Equal(t, parent.SpanContext().TraceID(), target.spans[0].TraceID())
+		assert.Equal(t, []string{"saga", "queue.send", "queue.process"}, spanNames(recorder.Started(), parent.SpanContext().TraceID()))
+	})
+
+	t.Run("batch of messages", func(t *testing.T) {
+		fake := newFakeSQS(t, "responses")
+		s, err := queue.NewSender(fake.client(), "responses")
+		require.NoError(t, err)
+
+		b := queue.NewBatchSender(s, 10*time.Millisecond)
+		runCtx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- b.Run(runCtx)
+		}()
+
+		other, span := provider.Tracer("test").Start(context.Background(), "other saga")
+		defer span.End()
+
+		var wg sync.WaitGroup
+		for _, c := range []context.Context{ctx, other} {
+			wg.Add(1)
+			go func(c context.Context) {
+				defer wg.Done()
+				assert.NoError(t, b.Send(c, queue.Response{ID: uuid.New(), SagaID: uuid.New(), Status: "done"}))
+			}(c)
+		}
+		wg.Wait()
+		cancel()
+		require.NoError(t, <-done)
+
+		target := &tracedProcessor{}
+		runPoll(t, fake, target, func() bool { return target.processed() == 2 })
+
+		traces := []trace.TraceID{target.spans[0].TraceID(), target.spans[1].TraceID()}
+		assert.ElementsMatch(t, []trace.TraceID{parent.SpanContext().TraceID(), span.SpanContext().TraceID()}, traces)
+	})
+}
+
+// spanNames returns names of the started spans of the trace.
+func spanNames(spans []sdktrace.ReadWriteSpan, traceID trace.TraceID) []string {
+	var names []string
+	for _, s := range spans {
+		if s.SpanContext().TraceID() == traceID {
+			names = append(names, s.Name())
+		}
+	}
+	return names
+}
@@ -0,0 +1,223 @@
+// Package participant is a library for building services which take part in sagas. A participant
+// registers handlers of commands, receives the commands from its queue and sends the responses
+// to the orchestrator:
+//
+//	p := participant.New("payments", sender, participant.WithStore(store))
+//	p.Execute(func(ctx context.Context, cmd queue.Command) (json.RawMessage, error) {
+//		if declined {
+//			return nil, participant.Fail("card declined")
+//		}
+//		return payload, nil
+//	})
+//	poller, err := queue.NewPoll[queue.Command](&receiver, p, log)
+//
+// Commands are processed once: the response of a processed command is kept in the store and sent
+// again for its duplicates. Failures of handlers are classified into business failures, which are
+// sent to the orchestrator as errors, and transient failures, which are retried.
+package participant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+// Set of names of commands sent by the orchestrator.
+const (
+	CommandExecute    = saga.CommandStart
+	CommandCompensate = saga.CommandCompensate
+)
+
+// Handler handles a command of a saga and returns the payload of the response.
+type Handler func(context.Context, queue.Command) (json.RawMessage, error)
+
+// Sender interface abstracts sending a response to the orchestrator.
+type Sender interface {
+	Send(context.Context, any) error
+}
+
+// BusinessError is a failure of a command which is not retried, e.g. a declined payment.
+// It is sent to the orchestrator as an error response with the payload.
+type BusinessError struct {
+	Reason  string
+	Payload json.RawMessage
+}
+
+func (e *BusinessError) Error() string {
+	return e.Reason
+}
+
+// Fail returns a business failure with the reason.
+func Fail(reason string) error {
+	return &BusinessError{Reason: reason}
+}
+
+// Participant processes commands of sagas for a service. It implements queue.Processor.
+type Participant struct {
+	service  string
+	sender   Sender
+	store    Store
+	handlers map[string]Handler
+	attempts int
+	backoff  time.Duration
+	log      *zap.SugaredLogger
+}
+
+// Option configures optional behaviour of a Participant.
+type Option func(*Participant)
+
+// WithStore makes the Participant keep processed commands in the store. By default they are kept in memory.
+func WithStore(s Store) Option {
+	return func(p *Participant) {
+		p.store = s
+	}
+}
+
+// WithRetries makes the Participant retry transient failures of handlers the given number of attempts
+// with an exponential backoff. By default a command is attempted 3 times starting with 100ms backoff.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(p *Participant) {
+		p.attempts = attempts
+		p.backoff = backoff
+	}
+}
+
+// WithLogger makes the Participant log processing of commands.
+func WithLogger(log *zap.SugaredLogger) Option {
+	return func(p *Participant) {
+		p.log = log
+	}
+}
+
+// New constructs a Participant of the service sending responses with the sender.
+func New(service string, sender Sender, opts ...Option) *Participant {
+	p := Participant{
+		service:  service,
+		sender:   sender,
+		store:    NewMemoryStore(),
+		handlers: make(map[string]Handler),
+		attempts: 3,
+		backoff:  100 * time.Millisecond,
+		log:      zap.NewNop().Sugar(),
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	return &p
+}
+
+// Handle registers the handler of the command.
+func (p *Participant) Handle(command string, h Handler) {
+	p.handlers[command] = h
+}
+
+// Execute registers the handler doing the work of the service.
+func (p *Participant) Execute(h Handler) {
+	p.Handle(CommandExecute, h)
+}
+
+// Compensate registers the handler undoing the work of the service.
+func (p *Participant) Compensate(h Handler) {
+	p.Handle(CommandCompensate, h)
+}
+
+// ProcessMessage handles the command and sends the response. A command without a handler is rejected,
+// and so is a command whose handler returns queue.ErrRejected. If a transient failure persists after all attempts,
+// an error is returned, so the command is redelivered.
+func (p *Participant) ProcessMessage(ctx context.Context, inp any) error {
+	cmd, ok := inp.(queue.Command)
+	if !ok {
+		return fmt.Errorf("%w: malformed command", queue.ErrRejected)
+	}
+
+	resp, ok, err := p.store.Get(ctx, cmd.ID)
+	if err != nil {
+		return fmt.Errorf("get processed command: %w", err)
+	}
+	if ok {
+		p.log.Infow("participant", "status", "duplicate command", "command", cmd.Name, "saga", cmd.SagaID)
+		return p.send(ctx, resp)
+	}
+
+	h, ok := p.handlers[cmd.Name]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %s of saga %s", queue.ErrRejected, cmd.Name, cmd.SagaID)
+	}
+
+	payload, err := p.handle(ctx, h, cmd)
+	var businessErr *BusinessError
+	switch {
+	case err == nil:
+		resp = p.response(cmd, successStatus(cmd.Name), payload)
+	case errors.As(err, &businessErr):
+		p.log.Infow("participant", "status", "command failed", "command", cmd.Name, "saga", cmd.SagaID, "reason", businessErr.Reason)
+		resp = p.response(cmd, saga.StatusError, businessErr.Payload)
+	default:
+		return fmt.Errorf("handle %s of saga %s: %w", cmd.Name, cmd.SagaID, err)
+	}
+
+	// The response is stored before it is sent, so a redelivered command gets it even if sending fails.
+	if err := p.store.Put(ctx, cmd.ID, resp); err != nil {
+		return fmt.Errorf("put processed command: %w", err)
+	}
+
+	return p.send(ctx, resp)
+}
+
+// handle calls the handler until it succeeds, fails for a business reason or runs out of attempts.
+func (p *Participant) handle(ctx context.Context, h Handler, cmd queue.Command) (json.RawMessage, error) {
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		payload, err := h(ctx, cmd)
+		var businessErr *BusinessError
+		if err == nil || errors.As(err, &businessErr) || errors.Is(err, queue.ErrRejected) || attempt >= p.attempts {
+			return payload, err
+		}
+		p.log.Warnw("participant", "WARNING", fmt.Errorf("handle %s of saga %s: %w", cmd.Name, cmd.SagaID, err),
+			"attempt", attempt)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// response returns the response to the command. Its ID is derived from the command, so duplicates
+// of the response get the same ID.
+func (p *Participant) response(cmd queue.Command, status string, payload json.RawMessage) queue.Response {
+	return queue.Response{
+		ID:      queue.MessageID(cmd.ID, p.service+"/"+status),
+		SagaID:  cmd.SagaID,
+		Service: p.service,
+		Status:  status,
+		Payload: payload,
+	}
+}
+
+func (p *Participant) send(ctx context.Context, resp queue.Response) error {
+	if err := p.sender.Send(ctx, resp); err != nil {
+		return fmt.Errorf("send response: %w", err)
+	}
+
+	return nil
+}
+
+// successStatus returns the status of a successful response to the command.
+func successStatus(command string) string {
+	if command == CommandCompensate {
+		return saga.StatusCompensated
+	}
+
+	return saga.StatusWorkDone
+}
@@ -0,0 +1,211 @@
+package participant_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/business/saga"
+	"github.com/illyasch/saga-service/pkg/data/queue"
+	"github.com/illyasch/saga-service/pkg/participant"
+)
+
+type fakeSender struct {
+	sent []queue.Response
+	err  error
+}
+
+func (s *fakeSender) Send(_ context.Context, msg any) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, msg.(queue.Response))
+	return nil
+}
+
+func newCommand(name string) queue.Command {
+	return queue.Command{ID: uuid.New(), SagaID: uuid.New(), Name: name, Payload: json.RawMessage(`{"order_id":42}`)}
+}
+
+func TestParticipant_ProcessMessage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("execute", func(t *testing.T) {
+		sender := &fakeSender{}
+		p := participant.New("payments", sender)
+		p.Execute(func(_ context.Context, cmd queue.Command) (json.RawMessage, error) {
+			return cmd.Payload, nil
+		})
+
+		cmd := newCommand(participant.CommandExecute)
+		require.NoError(t, p.ProcessMessage(ctx, cmd))
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, queue.Response{
+			ID:      queue.MessageID(cmd.ID, "payments/"+saga.StatusWorkDone),
+			SagaID:  cmd.SagaID,
+			Service: "payments",
+			Status:  saga.StatusWorkDone,
+			Payload: cmd.Payload,
+		}, sender.sent[0])
+	})
+
+	t.Run("compensate", func(t *testing.T) {
+		sender := &fakeSender{}
+		p := participant.New("payments", sender)
+		p.Compensate(func(context.Context, queue.Command) (json.RawMessage, error) {
+			return nil, nil
+		})
+
+		require.NoError(t, p.ProcessMessage(ctx, newCommand(participant.CommandCompensate)))
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, saga.StatusCompensated, sender.sent[0].Status)
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		sender := &fakeSender{}
+		p := participant.New("payments", sender)
+
+		err := p.ProcessMessage(ctx, newCommand(participant.CommandCompensate))
+		assert.ErrorIs(t, err, queue.ErrRejected)
+		assert.Empty(t, sender.sent)
+	})
+
+	t.Run("rejected by handler", func(t *testing.T) {
+		sender := &fakeSender{}
+		p := participant.New("payments", sender, participant.WithRetries(3, time.Millisecond))
+		calls := 0
+		p.Execute(func(context.Context, queue.Command) (json.RawMessage, error) {
+			calls++
+			return nil, queue.ErrRejected
+		})
+
+		err := p.ProcessMessage(ctx, newCommand(participant.CommandExecute))
+		assert.ErrorIs(t, err, queue.ErrRejected)
+		assert.Equal(t, 1, calls)
+		assert.Empty(t, sender.sent)
+	})
+
+	t.Run("business failure", func(t *testing.T) {
+		sender := &fakeSender{}
+		p := participant.New("payments", sender)
+		calls := 0
+		p.Execute(func(context.Context, queue.Command) (json.RawMessage, error) {
+			calls++
+			return nil, participant.Fail("card declined")
+		})
+
+		cmd := newCommand(participant.CommandExecute)
+		require.NoError(t, p.ProcessMessage(ctx, cmd))
+		assert.Equal(t, 1, calls)
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, saga.StatusError, sender.sent[0].Status)
+		assert.Equal(t, queue.MessageID(cmd.ID, "payments/"+saga.StatusError), sender.sent[0].ID)
+	})
+
+	t.Run("transient failure retried", func(t *testing.T) {
+		sender := &fakeSender{}
+		p := participant.New("payments", sender, participant.WithRetries(3, time.Millisecond))
+		calls := 0
+		p.Execute(func(context.Context, queue.Command) (json.RawMessage, error) {
+			calls++
+			if calls < 3 {
+				return nil, errors.New("connection refused")
+			}
+			return nil, nil
+		})
+
+		require.NoError(t, p.ProcessMessage(ctx, newCommand(participant.CommandExecute)))
+		assert.Equal(t, 3, calls)
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, saga.StatusWorkDone, sender.sent[0].Status)
+	})
+
+	t.Run("transient failure exhausted", func(t *testing.T) {
+		sender := &fakeSender{}
+		p := participant.New("payments", sender, participant.WithRetries(2, time.Millisecond))
+		calls := 0
+		p.Execute(func(context.Context, queue.Command) (json.RawMessage, error) {
+			calls++
+			return nil, errors.New("connection refused")
+		})
+
+		cmd := newCommand(participant.CommandExecute)
+		err := p.ProcessMessage(ctx, cmd)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, queue.ErrRejected)
+		assert.Equal(t, 2, calls)
+		assert.Empty(t, sender.sent)
+
+		// The redelivered command is handled again.
+		_ = p.ProcessMessage(ctx, cmd)
+		assert.Equal(t, 4, calls)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		sender := &fakeSender{}
+		p := participant.New("payments", sender)
+		calls := 0
+		p.Execute(func(context.Context, queue.Command) (json.RawMessage, error) {
+			calls++
+			return nil, nil
+		})
+
+		cmd := newCommand(participant.CommandExecute)
+		require.NoError(t, p.ProcessMessage(ctx, cmd))
+		require.NoError(t, p.ProcessMessage(ctx, cmd))
+		assert.Equal(t, 1, calls)
+		require.Len(t, sender.sent, 2)
+		assert.Equal(t, sender.sent[0], sender.sent[1])
+	})
+
+	t.Run("send failed", func(t *testing.T) {
+		sender := &fakeSender{err: errors.New("queue unavailable")}
+		p := participant.New("payments", sender)
+		calls := 0
+		p.Execute(func(context.Context, queue.Command) (json.RawMessage, error) {
+			calls++
+			return nil, nil
+		})
+
+		cmd := newCommand(participant.CommandExecute)
+		require.Error(t, p.ProcessMessage(ctx, cmd))
+
+		// The response is sent for the redelivered command without handling it again.
+		sender.err = nil
+		require.NoError(t, p.ProcessMessage(ctx, cmd))
+		assert.Equal(t, 1, calls)
+		assert.Len(t, sender.sent, 1)
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "processed.jsonl")
+	commandID := uuid.New()
+	resp := queue.Response{ID: uuid.New(), SagaID: uuid.New(), Service: "payments", Status: saga.StatusWorkDone}
+
+	store, err := participant.NewFileStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, commandID, resp))
+	require.NoError(t, store.Close())
+
+	store, err = participant.NewFileStore(path)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+
+	got, ok, err := store.Get(ctx, commandID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, resp, got)
+
+	_, ok, err = store.Get(ctx, uuid.New())
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,116 @@
+package participant
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/illyasch/saga-service/pkg/data/queue"
+)
+
+// Store interface abstracts keeping responses of processed commands by the command IDs.
+type Store interface {
+	Get(context.Context, uuid.UUID) (queue.Response, bool, error)
+	Put(context.Context, uuid.UUID, queue.Response) error
+}
+
+// MemoryStore keeps processed commands in memory. They are lost on restart.
+type MemoryStore struct {
+	mu        sync.Mutex
+	responses map[uuid.UUID]queue.Response
+}
+
+// NewMemoryStore constructs an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{responses: make(map[uuid.UUID]queue.Response)}
+}
+
+// Get returns the response of the command if it was processed.
+func (s *MemoryStore) Get(_ context.Context, commandID uuid.UUID) (queue.Response, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp, ok := s.responses[commandID]
+	return resp, ok, nil
+}
+
+// Put keeps the response of the processed command.
+func (s *MemoryStore) Put(_ context.Context, commandID uuid.UUID, resp queue.Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[commandID] = resp
+	return nil
+}
+
+// processed is a line of the file of a FileStore.
+type processed struct {
+	CommandID uuid.UUID      `json:"command_id"`
+	Response  queue.Response `json:"response"`
+}
+
+// FileStore keeps processed commands in memory and appends them to a JSON lines file,
+// so they survive restarts.
+type FileStore struct {
+	memory *MemoryStore
+	mu     sync.Mutex
+	file   *os.File
+}
+
+// NewFileStore opens the file of processed commands and loads them.
+func NewFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	s := FileStore{memory: NewMemoryStore(), file: f}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var p processed
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("parse store: %w", err)
+		}
+		s.memory.responses[p.CommandID] = p.Response
+	}
+	if err := scanner.Err(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("read store: %w", err)
+	}
+
+	return &s, nil
+}
+
+// Get returns the response of the command if it was processed.
+func (s *FileStore) Get(ctx context.Context, commandID uuid.UUID) (queue.Response, bool, error) {
+	return s.memory.Get(ctx, commandID)
+}
+
+// Put appends the response of the processed command to the file.
+func (s *FileStore) Put(ctx context.Context, commandID uuid.UUID, resp queue.Response) error {
+	line, err := json.Marshal(processed{CommandID: commandID, Response: resp})
+	if err != nil {
+		return fmt.Errorf("json marshal: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write store: %w", err)
+	}
+
+	return s.memory.Put(ctx, commandID, resp)
+}
+
+// Close closes the file.
+func (s *FileStore) Close() error {
+	return s.file.Close()
+}
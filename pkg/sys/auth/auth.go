@@ -0,0 +1,221 @@
+// Package auth authenticates callers of the API with static API keys or JWT bearer tokens
+// and authorizes them by scopes.
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Set of scopes of the API. The manage scope allows cancelling and retrying sagas. The admin scope grants all scopes.
+const (
+	ScopeStart  = "saga:start"
+	ScopeRead   = "saga:read"
+	ScopeManage = "saga:manage"
+	ScopeAdmin  = "saga:admin"
+)
+
+// HeaderAPIKey is the header with a static API key.
+const HeaderAPIKey = "X-API-Key"
+
+// Set of authentication errors.
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+)
+
+// Claims are the identity of an authenticated caller and its scopes.
+type Claims struct {
+	Subject string
+	Scopes  []string
+}
+
+// HasScope reports if the claims grant the scope.
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Require returns ErrForbidden if the claims don't grant the scope.
+func (c Claims) Require(scope string) error {
+	if !c.HasScope(scope) {
+		return fmt.Errorf("%w: scope %s is required", ErrForbidden, scope)
+	}
+
+	return nil
+}
+
+// Config is the locally configured keys. APIKeysFile is a JSON file with API keys of clients:
+//
+//	[{"client": "billing", "key": "...", "scopes": ["saga:start", "saga:read"]}]
+//
+// HMACSecret verifies HS256 tokens and RSAPublicKeyFile is a PEM file verifying RS256 tokens.
+// Issuer and Audience are optional and checked in tokens if set.
+type Config struct {
+	APIKeysFile      string
+	HMACSecret       string
+	RSAPublicKeyFile string
+	Issuer           string
+	Audience         string
+}
+
+// Auth authenticates requests with the configured keys.
+type Auth struct {
+	apiKeys    map[[sha256.Size]byte]Claims
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+	parser     *jwt.Parser
+	issuer     string
+	audience   string
+}
+
+// tokenClaims are the claims of a JWT. Scopes are separated by spaces as in OAuth 2.0.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope"`
+}
+
+// New constructs an Auth with the keys of the configuration. It returns nil if no keys are configured.
+func New(cfg Config) (*Auth, error) {
+	if cfg.APIKeysFile == "" && cfg.HMACSecret == "" && cfg.RSAPublicKeyFile == "" {
+		return nil, nil
+	}
+
+	a := Auth{
+		apiKeys:  make(map[[sha256.Size]byte]Claims),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+	}
+
+	if cfg.APIKeysFile != "" {
+		if err := a.loadAPIKeys(cfg.APIKeysFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var methods []string
+	if cfg.HMACSecret != "" {
+		a.hmacSecret = []byte(cfg.HMACSecret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.RSAPublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.RSAPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read public key: %w", err)
+		}
+		if a.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	a.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+
+	return &a, nil
+}
+
+// loadAPIKeys reads the API keys of clients from the file. Only hashes of the keys are kept.
+func (a *Auth) loadAPIKeys(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read api keys: %w", err)
+	}
+
+	var keys []struct {
+		Client string   `json:"client"`
+		Key    string   `json:"key"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("parse api keys: %w", err)
+	}
+
+	for _, k := range keys {
+		if k.Client == "" || k.Key == "" {
+			return fmt.Errorf("api key without client or key")
+		}
+		a.apiKeys[sha256.Sum256([]byte(k.Key))] = Claims{Subject: k.Client, Scopes: k.Scopes}
+	}
+
+	return nil
+}
+
+// Authenticate returns the claims of the API key or the bearer token of the request.
+func (a *Auth) Authenticate(r *http.Request) (Claims, error) {
+	if apiKey := r.Header.Get(HeaderAPIKey); apiKey != "" {
+		// Keys are looked up by their hashes, so the lookup time doesn't depend on the key.
+		claims, ok := a.apiKeys[sha256.Sum256([]byte(apiKey))]
+		if !ok {
+			return Claims{}, fmt.Errorf("%w: unknown api key", ErrUnauthenticated)
+		}
+		return claims, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if header == "" || token == header {
+		return Claims{}, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+	}
+
+	return a.verify(token)
+}
+
+// verify checks the signature and the claims of the token.
+func (a *Auth) verify(token string) (Claims, error) {
+	var claims tokenClaims
+	_, err := a.parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		// A method without a configured key is rejected, an empty HMAC secret would verify any token.
+		switch {
+		case t.Method.Alg() == jwt.SigningMethodHS256.Alg() && a.hmacSecret != nil:
+			return a.hmacSecret, nil
+		case t.Method.Alg() == jwt.SigningMethodRS256.Alg() && a.rsaKey != nil:
+			return a.rsaKey, nil
+		}
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	})
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	switch {
+	case !claims.VerifyExpiresAt(time.Now(), true):
+		return Claims{}, fmt.Errorf("%w: token without expiration", ErrUnauthenticated)
+	case a.issuer != "" && !claims.VerifyIssuer(a.issuer, true):
+		return Claims{}, fmt.Errorf("%w: unexpected issuer", ErrUnauthenticated)
+	case a.audience != "" && !claims.VerifyAudience(a.audience, true):
+		return Claims{}, fmt.Errorf("%w: unexpected audience", ErrUnauthenticated)
+	}
+
+	return Claims{Subject: claims.Subject, Scopes: strings.Fields(claims.Scope)}, nil
+}
+
+// ctxKey represents the type of value for the context key.
+type ctxKey int
+
+// key is how the claims are stored/retrieved.
+const key ctxKey = 1
+
+// NewContext returns a new context carrying the claims.
+func NewContext(ctx context.Context, c Claims) context.Context {
+	return context.WithValue(ctx, key, c)
+}
+
+// FromContext returns the claims carried by the context, if any.
+func FromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(key).(Claims)
+	return c, ok
+}
@@ -0,0 +1,138 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/sys/auth"
+)
+
+func TestAuth_Authenticate(t *testing.T) {
+	dir := t.TempDir()
+
+	keysFile := filepath.Join(dir, "keys.json")
+	require.NoError(t, os.WriteFile(keysFile,
+		[]byte(`[{"client": "billing", "key": "key1", "scopes": ["saga:start"]}]`), 0600))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	pubFile := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	a, err := auth.New(auth.Config{
+		APIKeysFile:      keysFile,
+		HMACSecret:       "secret",
+		RSAPublicKeyFile: pubFile,
+		Issuer:           "issuer",
+		Audience:         "saga-service",
+	})
+	require.NoError(t, err)
+
+	claims := func(exp time.Time, aud string) jwt.MapClaims {
+		return jwt.MapClaims{"sub": "dashboard", "iss": "issuer", "aud": aud, "exp": exp.Unix(), "scope": "saga:read saga:start"}
+	}
+	sign := func(method jwt.SigningMethod, key any, c jwt.Claims) string {
+		token, err := jwt.NewWithClaims(method, c).SignedString(key)
+		require.NoError(t, err)
+		return "Bearer " + token
+	}
+	hour := time.Now().Add(time.Hour)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		want    auth.Claims
+		wantErr bool
+	}{
+		{name: "api key", header: auth.HeaderAPIKey, value: "key1",
+			want: auth.Claims{Subject: "billing", Scopes: []string{"saga:start"}}},
+		{name: "unknown api key", header: auth.HeaderAPIKey, value: "key2", wantErr: true},
+		{name: "HS256 token", header: "Authorization", value: sign(jwt.SigningMethodHS256, []byte("secret"), claims(hour, "saga-service")),
+			want: auth.Claims{Subject: "dashboard", Scopes: []string{"saga:read", "saga:start"}}},
+		{name: "RS256 token", header: "Authorization", value: sign(jwt.SigningMethodRS256, rsaKey, claims(hour, "saga-service")),
+			want: auth.Claims{Subject: "dashboard", Scopes: []string{"saga:read", "saga:start"}}},
+		{name: "wrong secret", header: "Authorization", value: sign(jwt.SigningMethodHS256, []byte("other"), claims(hour, "saga-service")), wantErr: true},
+		{name: "wrong RSA key", header: "Authorization", value: sign(jwt.SigningMethodRS256, otherKey, claims(hour, "saga-service")), wantErr: true},
+		{name: "expired token", header: "Authorization", value: sign(jwt.SigningMethodHS256, []byte("secret"), claims(time.Now().Add(-time.Hour), "saga-service")), wantErr: true},
+		{name: "token without expiration", header: "Authorization", value: sign(jwt.SigningMethodHS256, []byte("secret"), jwt.MapClaims{"iss": "issuer", "aud": "saga-service"}), wantErr: true},
+		{name: "wrong audience", header: "Authorization", value: sign(jwt.SigningMethodHS256, []byte("secret"), claims(hour, "other")), wantErr: true},
+		{name: "unsigned token", header: "Authorization", value: sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(hour, "saga-service")), wantErr: true},
+		{name: "basic credentials", header: "Authorization", value: "Basic dXNlcjpwYXNz", wantErr: true},
+		{name: "no credentials", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+
+			got, err := a.Authenticate(r)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, auth.ErrUnauthenticated)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAuth_HMACNotConfigured(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(keysFile, []byte(`[{"client": "billing", "key": "key1"}]`), 0600))
+
+	a, err := auth.New(auth.Config{APIKeysFile: keysFile})
+	require.NoError(t, err)
+
+	// A token signed with an empty secret is rejected when HS256 is not configured.
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}).
+		SignedString([]byte{})
+	require.NoError(t, err)
+
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	r.Header.Set("Authorization", "Bearer "+token)
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, auth.ErrUnauthenticated)
+}
+
+func TestClaims(t *testing.T) {
+	c := auth.Claims{Subject: "billing", Scopes: []string{auth.ScopeStart}}
+	assert.True(t, c.HasScope(auth.ScopeStart))
+	assert.False(t, c.HasScope(auth.ScopeRead))
+	assert.False(t, c.HasScope(auth.ScopeManage))
+	assert.True(t, auth.Claims{Scopes: []string{auth.ScopeAdmin}}.HasScope(auth.ScopeManage))
+	assert.True(t, auth.Claims{Scopes: []string{auth.ScopeAdmin}}.HasScope(auth.ScopeRead))
+	assert.NoError(t, c.Require(auth.ScopeStart))
+	assert.ErrorIs(t, c.Require(auth.ScopeRead), auth.ErrForbidden)
+
+	_, ok := auth.FromContext(context.Background())
+	assert.False(t, ok)
+	got, ok := auth.FromContext(auth.NewContext(context.Background(), c))
+	assert.True(t, ok)
+	assert.Equal(t, c, got)
+
+	a, err := auth.New(auth.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, a)
+}
@@ -0,0 +1,146 @@
+// Package envelope provides envelope encryption of sensitive data. Every value is encrypted
+// with its own random data key, and the data key is encrypted with a key-encryption key
+// supplied by a KeyProvider.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const dataKeySize = 32
+
+// Set of error variables for encryption.
+var (
+	ErrNotSealed  = errors.New("data is not sealed")
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// KeyProvider supplies key-encryption keys. New values are sealed with the current key,
+// values sealed with older keys can be opened as long as the provider still knows them.
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+// sealed is the JSON representation of an encrypted value.
+type sealed struct {
+	KeyID   string `json:"kid"`
+	DataKey []byte `json:"edk"`
+	Data    []byte `json:"data"`
+}
+
+// Cipher seals and opens values using envelope encryption.
+type Cipher struct {
+	keys KeyProvider
+}
+
+// New constructs a Cipher with the key provider.
+func New(keys KeyProvider) Cipher {
+	return Cipher{keys: keys}
+}
+
+// Encrypt seals the plaintext with a new data key and returns the sealed value as JSON.
+func (c Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	keyID, kek, err := c.keys.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("current key: %w", err)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+
+	data, err := seal(dataKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("seal data: %w", err)
+	}
+
+	encryptedKey, err := seal(kek, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("seal data key: %w", err)
+	}
+
+	return json.Marshal(sealed{KeyID: keyID, DataKey: encryptedKey, Data: data})
+}
+
+// Decrypt opens a value sealed by Encrypt.
+func (c Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	var s sealed
+	if err := json.Unmarshal(ciphertext, &s); err != nil || s.KeyID == "" || len(s.DataKey) == 0 {
+		return nil, ErrNotSealed
+	}
+
+	kek, err := c.keys.Key(s.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", s.KeyID, err)
+	}
+
+	dataKey, err := open(kek, s.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("open data key: %w", err)
+	}
+
+	plaintext, err := open(dataKey, s.Data)
+	if err != nil {
+		return nil, fmt.Errorf("open data: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// IsEncrypted reports whether the data looks like a value sealed by Encrypt.
+func (c Cipher) IsEncrypted(data []byte) bool {
+	return IsSealed(data)
+}
+
+// IsSealed reports whether the data looks like a value sealed by Encrypt.
+func IsSealed(data []byte) bool {
+	var s sealed
+	return json.Unmarshal(data, &s) == nil && s.KeyID != "" && len(s.DataKey) > 0
+}
+
+// seal encrypts the plaintext with AES-GCM and prepends the nonce to the result.
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts the output of seal.
+func open(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
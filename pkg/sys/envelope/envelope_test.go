@@ -0,0 +1,113 @@
+package envelope_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/illyasch/saga-service/pkg/sys/envelope"
+)
+
+var (
+	key1 = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	key2 = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func TestCipher(t *testing.T) {
+	plaintext := []byte(`{"card_token": "tok_4242"}`)
+
+	t.Run("encrypt and decrypt", func(t *testing.T) {
+		keyring, err := envelope.NewFileKeyring("k1", map[string]string{"k1": key1})
+		require.NoError(t, err)
+		c := envelope.New(keyring)
+
+		sealed, err := c.Encrypt(plaintext)
+		require.NoError(t, err)
+		assert.True(t, json.Valid(sealed))
+		assert.True(t, c.IsEncrypted(sealed))
+		assert.NotContains(t, string(sealed), "tok_4242")
+
+		got, err := c.Decrypt(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, got)
+	})
+
+	t.Run("decrypt after key rotation", func(t *testing.T) {
+		old, err := envelope.NewFileKeyring("k1", map[string]string{"k1": key1})
+		require.NoError(t, err)
+		rotated, err := envelope.NewFileKeyring("k2", map[string]string{"k1": key1, "k2": key2})
+		require.NoError(t, err)
+
+		sealed, err := envelope.New(old).Encrypt(plaintext)
+		require.NoError(t, err)
+
+		got, err := envelope.New(rotated).Decrypt(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, got)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		k1, err := envelope.NewFileKeyring("k1", map[string]string{"k1": key1})
+		require.NoError(t, err)
+		k2, err := envelope.NewFileKeyring("k2", map[string]string{"k2": key2})
+		require.NoError(t, err)
+
+		sealed, err := envelope.New(k2).Encrypt(plaintext)
+		require.NoError(t, err)
+
+		_, err = envelope.New(k1).Decrypt(sealed)
+		assert.ErrorIs(t, err, envelope.ErrUnknownKey)
+	})
+
+	t.Run("tampered data", func(t *testing.T) {
+		keyring, err := envelope.NewFileKeyring("k1", map[string]string{"k1": key1})
+		require.NoError(t, err)
+		c := envelope.New(keyring)
+
+		sealed, err := c.Encrypt(plaintext)
+		require.NoError(t, err)
+
+		var doc struct {
+			KeyID   string `json:"kid"`
+			DataKey []byte `json:"edk"`
+			Data    []byte `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(sealed, &doc))
+		doc.Data[len(doc.Data)-1] ^= 0xff
+		tampered, err := json.Marshal(doc)
+		require.NoError(t, err)
+
+		_, err = c.Decrypt(tampered)
+		assert.Error(t, err)
+	})
+
+	t.Run("plaintext is not sealed", func(t *testing.T) {
+		keyring, err := envelope.NewFileKeyring("k1", map[string]string{"k1": key1})
+		require.NoError(t, err)
+
+		assert.False(t, envelope.IsSealed(plaintext))
+		_, err = envelope.New(keyring).Decrypt(plaintext)
+		assert.ErrorIs(t, err, envelope.ErrNotSealed)
+	})
+}
+
+func TestLoadFileKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	doc := `{"current": "k2", "keys": {"k1": "` + key1 + `", "k2": "` + key2 + `"}}`
+	require.NoError(t, os.WriteFile(path, []byte(doc), 0o600))
+
+	keyring, err := envelope.LoadFileKeyring(path)
+	require.NoError(t, err)
+
+	id, _, err := keyring.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, "k2", id)
+
+	_, err = keyring.Key("k3")
+	assert.ErrorIs(t, err, envelope.ErrUnknownKey)
+}
@@ -0,0 +1,74 @@
+package envelope
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// FileKeyring is a KeyProvider reading key-encryption keys from a local JSON file.
+// It is intended for development and tests. The file has the following format,
+// where keys are base64 encoded AES keys of 16, 24 or 32 bytes:
+//
+//	{"current": "2022-07", "keys": {"2022-06": "...", "2022-07": "..."}}
+type FileKeyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// LoadFileKeyring reads the keyring from the file.
+func LoadFileKeyring(path string) (FileKeyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FileKeyring{}, fmt.Errorf("read keyring: %w", err)
+	}
+
+	var doc struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return FileKeyring{}, fmt.Errorf("parse keyring: %w", err)
+	}
+
+	return NewFileKeyring(doc.Current, doc.Keys)
+}
+
+// NewFileKeyring constructs the keyring from key ids mapped to base64 encoded keys.
+func NewFileKeyring(current string, keys map[string]string) (FileKeyring, error) {
+	k := FileKeyring{current: current, keys: make(map[string][]byte, len(keys))}
+	for id, encoded := range keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return FileKeyring{}, fmt.Errorf("decode key %q: %w", id, err)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return FileKeyring{}, fmt.Errorf("key %q: invalid size %d", id, len(key))
+		}
+		k.keys[id] = key
+	}
+
+	if _, ok := k.keys[current]; !ok {
+		return FileKeyring{}, fmt.Errorf("current key %q: %w", current, ErrUnknownKey)
+	}
+
+	return k, nil
+}
+
+// CurrentKey returns the key used for sealing new values.
+func (k FileKeyring) CurrentKey() (string, []byte, error) {
+	return k.current, k.keys[k.current], nil
+}
+
+// Key returns the key with the id.
+func (k FileKeyring) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
@@ -0,0 +1,89 @@
+// Package ratelimit limits rates of requests per client with token buckets.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long a bucket of a client without requests is kept.
+const idleTimeout = 10 * time.Minute
+
+// bucket is a token bucket of a client with the time of its last request.
+type bucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// Limiter keeps a token bucket per client. Buckets of idle clients are removed.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    rate.Limit
+	burst   int
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// New constructs a Limiter allowing every client the given number of requests per second
+// with bursts of the given size. Without a burst a client gets the requests of one second at once,
+// at least one, as a bucket without tokens would reject every request.
+func New(perSecond float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = int(math.Ceil(perSecond))
+		if burst < 1 {
+			burst = 1
+		}
+	}
+
+	return &Limiter{
+		rate:    rate.Limit(perSecond),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+// Allow reports if a request of the client is allowed now. If it is not, it returns how long
+// the client should wait before the next request.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.buckets[client] = b
+	}
+	b.seen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return false, idleTimeout
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// sweep removes buckets of idle clients, at most once per idle timeout.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < idleTimeout {
+		return
+	}
+	l.swept = now
+
+	for client, b := range l.buckets {
+		if now.Sub(b.seen) >= idleTimeout {
+			delete(l.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/illyasch/saga-service/pkg/sys/ratelimit"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := ratelimit.New(1, 2)
+
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("client1")
+		assert.True(t, ok, "burst request %d", i)
+	}
+
+	ok, retryAfter := l.Allow("client1")
+	assert.False(t, ok)
+	assert.InDelta(t, time.Second, retryAfter, float64(100*time.Millisecond))
+
+	// Buckets are per client.
+	ok, _ = l.Allow("client2")
+	assert.True(t, ok)
+
+	// A rejected request doesn't consume a token.
+	time.Sleep(retryAfter)
+	ok, _ = l.Allow("client1")
+	assert.True(t, ok)
+}
+
+func TestLimiter_ZeroBurst(t *testing.T) {
+	// The burst defaults to the requests of one second.
+	l := ratelimit.New(2.5, 0)
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("client1")
+		assert.True(t, ok, "request %d", i)
+	}
+	ok, retryAfter := l.Allow("client1")
+	assert.False(t, ok)
+	assert.Positive(t, retryAfter)
+
+	// A slow rate still allows a request.
+	l = ratelimit.New(0.1, 0)
+	ok, _ = l.Allow("client1")
+	assert.True(t, ok)
+}
@@ -0,0 +1,47 @@
+// Package requestid carries the ID of a request which caused an operation through the context,
+// so it can be correlated across the HTTP API, queue messages and audit records.
+package requestid
+
+import "context"
+
+// Header is the HTTP header with the request ID.
+const Header = "X-Request-ID"
+
+// MaxLength is the maximum length of a valid request ID.
+const MaxLength = 128
+
+// Valid reports whether the ID is safe to pass on to logs, queue messages and audit records: it is not empty,
+// not longer than MaxLength and consists of ASCII letters, digits and the characters "-", "_", ".", ":".
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// ctxKey represents the type of value for the context key.
+type ctxKey int
+
+// key is how the request ID is stored/retrieved.
+const key ctxKey = 1
+
+// NewContext returns a new context carrying the request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key, id)
+}
+
+// FromContext returns the request ID from the context or an empty string if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key).(string)
+	return id
+}
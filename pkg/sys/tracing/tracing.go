@@ -0,0 +1,94 @@
+// Package tracing configures OpenTelemetry tracing of the services. Trace context is propagated
+// in W3C trace context format, so a saga is traced across the services as one trace.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+// Set of supported exporters.
+const (
+	ExporterNone = ""
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+)
+
+// Config is the required properties to use tracing.
+// Endpoint is the host and port of an OTLP HTTP collector, File is the path of the file exporter.
+type Config struct {
+	ServiceName string
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	File        string
+	Probability float64
+}
+
+// Start configures the global tracer provider and propagator. Without an exporter the trace context
+// is still propagated, but no spans are recorded. The returned function flushes and stops the exporter.
+func Start(cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	closeFile := func() error { return nil }
+	switch cfg.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		var err error
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("creating otlp exporter: %w", err)
+		}
+
+	case ExporterFile:
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening trace file: %w", err)
+		}
+		closeFile = f.Close
+
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("creating file exporter: %w", err)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", cfg.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Probability))),
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(cfg.ServiceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		if err := provider.Shutdown(ctx); err != nil {
+			_ = closeFile()
+			return fmt.Errorf("shutting down tracer provider: %w", err)
+		}
+		return closeFile()
+	}, nil
+}